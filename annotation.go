@@ -0,0 +1,55 @@
+package caps
+
+import "errors"
+
+// HasAnnotation reports whether annotation id is in the list.
+func HasAnnotation(ans Annotation_List, id uint64) bool {
+	for _, a := range ans.ToArray() {
+		if a.Id() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldCodec describes how a field is named and treated by the codecs
+// according to its $Field annotations.
+type FieldCodec struct {
+	Name     string
+	Required bool
+	Optional bool
+	Ignored  bool
+}
+
+// CodecOf returns codec description of the field.
+func CodecOf(f Field) FieldCodec {
+	c := FieldCodec{Name: f.Name()}
+
+	for _, a := range f.Annotations().ToArray() {
+		switch a.Id() {
+		case FieldRequired:
+			c.Required = true
+			c.Name = a.Value().Text()
+		case FieldOptional:
+			c.Optional = true
+			c.Name = a.Value().Text()
+		case FieldIgnored:
+			c.Ignored = true
+		}
+	}
+	return c
+}
+
+// Err returns error for combinations of $Field annotations which can't be
+// generated.
+func (c FieldCodec) Err() error {
+	switch {
+	case c.Required && c.Ignored:
+		return errors.New("$Field.required and $Field.ignored are incompatible")
+	case c.Required && c.Optional:
+		return errors.New("$Field.required and $Field.optional are incompatible")
+	case c.Optional && c.Ignored:
+		return errors.New("$Field.optional and $Field.ignored are incompatible")
+	}
+	return nil
+}
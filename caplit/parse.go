@@ -0,0 +1,324 @@
+package caplit
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+const maxDepth = 1000
+
+type parser struct {
+	data   []byte
+	pos    int
+	line   int
+	column int
+	depth  int
+}
+
+// Parse parses single literal. Comments starting with # are allowed.
+// References to constants are not supported.
+func Parse(data []byte) (*Value, error) {
+	p := &parser{data: data, line: 1, column: 1}
+
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.data) {
+		return nil, p.errorf("unexpected %q after literal", p.data[p.pos])
+	}
+	return v, nil
+}
+
+func (p *parser) errorf(format string, a ...interface{}) error {
+	v := &Value{Line: p.line, Column: p.column}
+	return v.Errorf(format, a...)
+}
+
+func (p *parser) next() byte {
+	c := p.data[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+		p.column = 1
+	} else if c < utf8.RuneSelf || utf8.RuneStart(c) {
+		p.column++
+	}
+	return c
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.next()
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.next()
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.data) {
+		return p.data[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		if p.pos == len(p.data) {
+			return p.errorf("expected %q, got end of input", c)
+		}
+		return p.errorf("expected %q, got %q", c, p.data[p.pos])
+	}
+	p.next()
+	return nil
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func (p *parser) ident() string {
+	start := p.pos
+	for p.pos < len(p.data) && isIdent(p.data[p.pos]) {
+		p.next()
+	}
+	return string(p.data[start:p.pos])
+}
+
+func (p *parser) value() (*Value, error) {
+	c := p.peek()
+	v := &Value{Line: p.line, Column: p.column}
+
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, p.errorf("literal is nested too deep")
+	}
+
+	switch {
+	case p.pos == len(p.data):
+		return nil, p.errorf("unexpected end of input")
+
+	case c == '(':
+		v.Kind = Struct
+		v.Fields = []Field{}
+		p.next()
+		for p.peek() != ')' {
+			if len(v.Fields) > 0 {
+				if err := p.expect(','); err != nil {
+					return nil, err
+				}
+			}
+			p.skipSpace()
+			name := p.ident()
+			if name == "" {
+				return nil, p.errorf("expected field name")
+			}
+			if err := p.expect('='); err != nil {
+				return nil, err
+			}
+			fv, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			v.Fields = append(v.Fields, Field{name, fv})
+		}
+		p.next()
+
+	case c == '[':
+		v.Kind = List
+		v.Items = []*Value{}
+		p.next()
+		for p.peek() != ']' {
+			if len(v.Items) > 0 {
+				if err := p.expect(','); err != nil {
+					return nil, err
+				}
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			v.Items = append(v.Items, item)
+		}
+		p.next()
+
+	case c == '"':
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		v.Kind, v.Text = String, s
+
+	case bytes.HasPrefix(p.data[p.pos:], []byte("0x\"")):
+		p.next()
+		p.next()
+		d, err := p.hexData()
+		if err != nil {
+			return nil, err
+		}
+		v.Kind, v.Data = Data, d
+
+	case c == '-' || '0' <= c && c <= '9':
+		start := p.pos
+		p.next()
+		for p.pos < len(p.data) {
+			c := p.data[p.pos]
+			if !(isIdent(c) || c == '.' || (c == '-' || c == '+') && (p.data[p.pos-1] == 'e' || p.data[p.pos-1] == 'E')) {
+				break
+			}
+			p.next()
+		}
+		v.Kind, v.Text = Number, string(p.data[start:p.pos])
+
+	case c == '.':
+		return nil, p.errorf("references to constants are not supported")
+
+	case isIdent(c):
+		switch name := p.ident(); name {
+		case "void":
+			v.Kind = Void
+		case "true", "false":
+			v.Kind, v.Bool = Bool, name == "true"
+		default:
+			v.Kind, v.Text = Ident, name
+		}
+
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+
+	return v, nil
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func (p *parser) str() (string, error) {
+	var b []byte
+	p.next()
+	for {
+		if p.pos == len(p.data) {
+			return "", p.errorf("unterminated text literal")
+		}
+
+		c := p.next()
+		switch c {
+		case '"':
+			return string(b), nil
+		case '\n':
+			return "", p.errorf("newline in text literal")
+		case '\\':
+			if p.pos == len(p.data) {
+				return "", p.errorf("unterminated text literal")
+			}
+			switch e := p.next(); e {
+			case 'a':
+				b = append(b, '\a')
+			case 'b':
+				b = append(b, '\b')
+			case 'f':
+				b = append(b, '\f')
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'v':
+				b = append(b, '\v')
+			case '\\', '\'', '"', '?':
+				b = append(b, e)
+			case 'x':
+				var x byte
+				for i := 0; i < 2; i++ {
+					if p.pos == len(p.data) {
+						return "", p.errorf("invalid \\x escape")
+					}
+					h, ok := unhex(p.data[p.pos])
+					if !ok {
+						return "", p.errorf("invalid \\x escape")
+					}
+					x = x<<4 | h
+					p.next()
+				}
+				b = append(b, x)
+			default:
+				if '0' <= e && e <= '7' {
+					x := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && '0' <= p.data[p.pos] && p.data[p.pos] <= '7'; i++ {
+						x = x<<3 | int(p.next()-'0')
+					}
+					if x > 0xff {
+						return "", p.errorf("octal escape \\%o is out of range", x)
+					}
+					b = append(b, byte(x))
+				} else {
+					return "", p.errorf("invalid escape \\%c", e)
+				}
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+}
+
+// hexData reads "0a 1b" part of data literal, spaces are allowed.
+func (p *parser) hexData() ([]byte, error) {
+	var d []byte
+	var half byte
+	odd := false
+
+	p.next()
+	for {
+		if p.pos == len(p.data) {
+			return nil, p.errorf("unterminated data literal")
+		}
+
+		c := p.next()
+		if c == '"' {
+			break
+		}
+		if c == ' ' {
+			continue
+		}
+
+		h, ok := unhex(c)
+		if !ok {
+			return nil, p.errorf("invalid hex digit %q in data literal", c)
+		}
+		if odd {
+			d = append(d, half<<4|h)
+		} else {
+			half = h
+		}
+		odd = !odd
+	}
+
+	if odd {
+		return nil, p.errorf("odd number of hex digits in data literal")
+	}
+	if d == nil {
+		d = []byte{}
+	}
+	return d, nil
+}
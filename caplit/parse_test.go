@@ -0,0 +1,160 @@
+package caplit
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseText(t *testing.T) {
+	for _, tt := range []struct{ in, out string }{
+		{`"abc"`, "abc"},
+		{`"\a\b\f\n\r\t\v\\\'\"\?"`, "\a\b\f\n\r\t\v\\'\"?"},
+		{`"\x41\x7a"`, "Az"},
+		{`"\101\0\12x"`, "A\x00\nx"},
+		{`"\377"`, "\xff"},
+		{`"\1234"`, "S4"},
+		{`"héllo"`, "héllo"},
+	} {
+		v, err := Parse([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if s, err := v.Str(); err != nil || s != tt.out {
+			t.Errorf("%s is parsed as %q, want %q: %v", tt.in, s, tt.out, err)
+		}
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		out interface{}
+	}{
+		{`void`, nil},
+		{`true`, true},
+		{`-12`, int64(-12)},
+		{`0x1f`, int64(31)},
+		{`18446744073709551615`, uint64(math.MaxUint64)},
+		{`1.5e3`, 1500.0},
+		{`inf`, math.Inf(1)},
+		{`0x"0a 1b"`, []byte{0x0a, 0x1b}},
+		{`0x""`, []byte{}},
+		{`[]`, []interface{}{}},
+		{`[1, [2, [true, "x"]], []] # comment`, []interface{}{int64(1), []interface{}{int64(2), []interface{}{true, "x"}}, []interface{}{}}},
+	} {
+		v, err := Parse([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if i, err := v.Interface(); err != nil || !reflect.DeepEqual(i, tt.out) {
+			t.Errorf("%s is parsed as %#v, want %#v: %v", tt.in, i, tt.out, err)
+		}
+	}
+}
+
+func TestParseStruct(t *testing.T) {
+	v, err := Parse([]byte("(name = \"Bob\",\n  address = (city = \"X\", zip = [1, 2]),\n  kind = mobile)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := v.StructFields()
+	if err != nil || len(fields) != 3 {
+		t.Fatalf("fields %v: %v", fields, err)
+	}
+	if fields[0].Name != "name" || fields[0].Value.Text != "Bob" {
+		t.Errorf("name is %+v", fields[0])
+	}
+
+	address, err := fields[1].Value.StructFields()
+	if err != nil || len(address) != 2 || address[0].Name != "city" {
+		t.Fatalf("address %v: %v", address, err)
+	}
+	zip, err := address[1].Value.Interface()
+	if err != nil || !reflect.DeepEqual(zip, []interface{}{int64(1), int64(2)}) {
+		t.Errorf("zip is %v: %v", zip, err)
+	}
+	if address[1].Value.Line != 2 || address[1].Value.Column != 32 {
+		t.Errorf("zip is at %d:%d", address[1].Value.Line, address[1].Value.Column)
+	}
+
+	if name, err := fields[2].Value.Enum(); err != nil || name != "mobile" {
+		t.Errorf("kind is %s: %v", name, err)
+	}
+	if _, err := fields[2].Value.Interface(); err == nil {
+		t.Errorf("enumerant is decoded without schema")
+	}
+	if _, err := v.Interface(); err == nil {
+		t.Errorf("struct is decoded without schema")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct{ in, err string }{
+		{``, `caplit: 1:1: unexpected end of input`},
+		{`"abc`, `caplit: 1:5: unterminated text literal`},
+		{"\"a\nb\"", `caplit: 2:1: newline in text literal`},
+		{`"\q"`, `caplit: 1:4: invalid escape \q`},
+		{`"\x4"`, `caplit: 1:5: invalid \x escape`},
+		{`"\777"`, `caplit: 1:6: octal escape \777 is out of range`},
+		{`"\400"`, `caplit: 1:6: octal escape \400 is out of range`},
+		{`0x"abc"`, `caplit: 1:8: odd number of hex digits in data literal`},
+		{`0x"zz"`, `caplit: 1:5: invalid hex digit 'z' in data literal`},
+		{`(a = 1`, `caplit: 1:7: expected ',', got end of input`},
+		{`(a = 1 b = 2)`, `caplit: 1:8: expected ',', got 'b'`},
+		{`(= 1)`, `caplit: 1:2: expected field name`},
+		{`(a 1)`, `caplit: 1:4: expected '=', got '1'`},
+		{`[1 2]`, `caplit: 1:4: expected ',', got '2'`},
+		{`.bob`, `caplit: 1:1: references to constants are not supported`},
+		{`1 2`, `caplit: 1:3: unexpected '2' after literal`},
+		{`}`, `caplit: 1:1: unexpected '}'`},
+	} {
+		_, err := Parse([]byte(tt.in))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: error is %v, want %s", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestParseDepth(t *testing.T) {
+	deep := make([]byte, 2*maxDepth+2)
+	for i := 0; i <= maxDepth; i++ {
+		deep[i], deep[len(deep)-1-i] = '[', ']'
+	}
+	if _, err := Parse(deep); err == nil {
+		t.Errorf("literal nested %d times is parsed", maxDepth+1)
+	}
+	if _, err := Parse(deep[1 : len(deep)-1]); err != nil {
+		t.Errorf("literal nested %d times: %v", maxDepth, err)
+	}
+}
+
+func TestValueErrors(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		get func(*Value) error
+		err string
+	}{
+		{`256`, func(v *Value) error { _, err := v.Uint(8); return err }, `caplit: 1:1: invalid UInt8 256`},
+		{`-1`, func(v *Value) error { _, err := v.Uint(64); return err }, `caplit: 1:1: invalid UInt64 -1`},
+		{`128`, func(v *Value) error { _, err := v.Int(8); return err }, `caplit: 1:1: invalid Int8 128`},
+		{`"x"`, func(v *Value) error { _, err := v.Int(32); return err }, `caplit: 1:1: expected number, got text`},
+		{`1`, func(v *Value) error { _, err := v.Str(); return err }, `caplit: 1:1: expected text, got number`},
+		{`[1]`, func(v *Value) error { _, err := v.StructFields(); return err }, `caplit: 1:1: expected struct, got list`},
+		{`(a = 1)`, func(v *Value) error { _, err := v.ListItems(); return err }, `caplit: 1:1: expected list, got struct`},
+		{`true`, func(v *Value) error { return v.IsVoid() }, `caplit: 1:1: expected void, got bool`},
+		{`x`, func(v *Value) error { _, err := v.Float(64); return err }, `caplit: 1:1: expected number, got identifier`},
+	} {
+		v, err := Parse([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if err := tt.get(v); err == nil || err.Error() != tt.err {
+			t.Errorf("%s: error is %v, want %s", tt.in, err, tt.err)
+		}
+	}
+}
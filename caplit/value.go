@@ -0,0 +1,202 @@
+package caplit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Kind of the literal
+type Kind int
+
+const (
+	Void Kind = iota
+	Bool
+	Number
+	String
+	Data
+	Ident
+	List
+	Struct
+)
+
+var kindNames = [...]string{"void", "bool", "number", "text", "data", "identifier", "list", "struct"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Field of the struct literal
+type Field struct {
+	Name  string
+	Value *Value
+}
+
+// Value is parsed literal. Text holds source of number and identifier
+// literals and content of text literal.
+type Value struct {
+	Kind   Kind
+	Line   int
+	Column int
+
+	Bool   bool
+	Text   string
+	Data   []byte
+	Items  []*Value
+	Fields []Field
+}
+
+// Error describes invalid literal or its mismatch with the schema.
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("caplit: %d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Errorf returns error positioned at the value.
+func (v *Value) Errorf(format string, a ...interface{}) error {
+	return &Error{v.Line, v.Column, fmt.Sprintf(format, a...)}
+}
+
+func (v *Value) expect(k Kind) error {
+	if v.Kind != k {
+		return v.Errorf("expected %s, got %s", k, v.Kind)
+	}
+	return nil
+}
+
+// StructFields returns fields of struct literal.
+func (v *Value) StructFields() ([]Field, error) {
+	return v.Fields, v.expect(Struct)
+}
+
+// ListItems returns items of list literal.
+func (v *Value) ListItems() ([]*Value, error) {
+	return v.Items, v.expect(List)
+}
+
+// IsVoid checks the value is void.
+func (v *Value) IsVoid() error {
+	return v.expect(Void)
+}
+
+// Boolean returns value of bool literal.
+func (v *Value) Boolean() (bool, error) {
+	return v.Bool, v.expect(Bool)
+}
+
+// Str returns value of text literal.
+func (v *Value) Str() (string, error) {
+	return v.Text, v.expect(String)
+}
+
+// Bytes returns value of data literal. Text literals are accepted as well.
+func (v *Value) Bytes() ([]byte, error) {
+	if v.Kind == String {
+		return []byte(v.Text), nil
+	}
+	return v.Data, v.expect(Data)
+}
+
+// Enum returns name of the enumerant.
+func (v *Value) Enum() (string, error) {
+	return v.Text, v.expect(Ident)
+}
+
+// Int returns value of integer literal which fits into bitSize bits.
+func (v *Value) Int(bitSize int) (int64, error) {
+	if err := v.expect(Number); err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(v.Text, 0, bitSize)
+	if err != nil {
+		return 0, v.Errorf("invalid Int%d %s", bitSize, v.Text)
+	}
+	return i, nil
+}
+
+// Uint returns value of unsigned integer literal which fits into bitSize bits.
+func (v *Value) Uint(bitSize int) (uint64, error) {
+	if err := v.expect(Number); err != nil {
+		return 0, err
+	}
+	u, err := strconv.ParseUint(v.Text, 0, bitSize)
+	if err != nil {
+		return 0, v.Errorf("invalid UInt%d %s", bitSize, v.Text)
+	}
+	return u, nil
+}
+
+// Float returns value of number literal, inf and nan included.
+func (v *Value) Float(bitSize int) (float64, error) {
+	if v.Kind == Ident {
+		switch v.Text {
+		case "inf":
+			return math.Inf(1), nil
+		case "nan":
+			return math.NaN(), nil
+		}
+	}
+	if err := v.expect(Number); err != nil {
+		return 0, err
+	}
+
+	switch v.Text {
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+
+	f, err := strconv.ParseFloat(v.Text, bitSize)
+	if err != nil {
+		if i, ierr := strconv.ParseInt(v.Text, 0, 64); ierr == nil {
+			return float64(i), nil
+		}
+		return 0, v.Errorf("invalid Float%d %s", bitSize, v.Text)
+	}
+	return f, nil
+}
+
+// Interface returns value as nil, bool, int64, uint64, float64, string,
+// []byte or []interface{}. Struct literals and enumerants can't be
+// represented without schema.
+func (v *Value) Interface() (interface{}, error) {
+	switch v.Kind {
+	case Void:
+		return nil, nil
+	case Bool:
+		return v.Bool, nil
+	case Number:
+		if i, err := strconv.ParseInt(v.Text, 0, 64); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(v.Text, 0, 64); err == nil {
+			return u, nil
+		}
+		return v.Float(64)
+	case String:
+		return v.Text, nil
+	case Data:
+		return v.Data, nil
+	case List:
+		l := make([]interface{}, len(v.Items))
+		for i, item := range v.Items {
+			var err error
+			if l[i], err = item.Interface(); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	case Ident:
+		if f, err := v.Float(64); err == nil {
+			return f, nil
+		}
+	}
+	return nil, v.Errorf("%s can't be decoded without schema", v.Kind)
+}
@@ -0,0 +1,123 @@
+// Package caplit implements Cap'n Proto text format, the literal syntax of
+// schema language, used by code generated with $Codec.caplit annotation.
+//
+//	(name = "Bob", email = "bob@example.com", tags = ["a", "b"])
+package caplit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// AppendField appends separator if needed and `name = ` prefix of the
+// struct field.
+func AppendField(b []byte, name string) []byte {
+	if len(b) > 0 && b[len(b)-1] != '(' {
+		b = append(b, ", "...)
+	}
+	return append(append(b, name...), " = "...)
+}
+
+// AppendBool appends boolean literal.
+func AppendBool(b []byte, t bool) []byte {
+	return strconv.AppendBool(b, t)
+}
+
+// AppendInt appends integer literal.
+func AppendInt(b []byte, i int64) []byte {
+	return strconv.AppendInt(b, i, 10)
+}
+
+// AppendUint appends unsigned integer literal.
+func AppendUint(b []byte, u uint64) []byte {
+	return strconv.AppendUint(b, u, 10)
+}
+
+// AppendFloat appends float literal, bitSize is 32 or 64.
+func AppendFloat(b []byte, f float64, bitSize int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(b, "nan"...)
+	case math.IsInf(f, 1):
+		return append(b, "inf"...)
+	case math.IsInf(f, -1):
+		return append(b, "-inf"...)
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, bitSize)
+}
+
+// AppendString appends quoted text literal.
+func AppendString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		default:
+			if c < 0x20 || c == 0x7f {
+				b = append(b, fmt.Sprintf("\\x%02x", c)...)
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, '"')
+}
+
+// AppendData appends data literal in form of 0x"0a1b".
+func AppendData(b []byte, d []byte) []byte {
+	const hex = "0123456789abcdef"
+
+	b = append(b, '0', 'x', '"')
+	for _, c := range d {
+		b = append(b, hex[c>>4], hex[c&0xf])
+	}
+	return append(b, '"')
+}
+
+// AppendIntf appends value of dynamic type as returned by Value.Interface.
+func AppendIntf(b []byte, i interface{}) ([]byte, error) {
+	switch v := i.(type) {
+	case nil:
+		return append(b, "void"...), nil
+	case bool:
+		return AppendBool(b, v), nil
+	case int64:
+		return AppendInt(b, v), nil
+	case uint64:
+		return AppendUint(b, v), nil
+	case float64:
+		return AppendFloat(b, v, 64), nil
+	case string:
+		return AppendString(b, v), nil
+	case []byte:
+		return AppendData(b, v), nil
+	case []interface{}:
+		var err error
+		b = append(b, '[')
+		for i, e := range v {
+			if i > 0 {
+				b = append(b, ", "...)
+			}
+			if b, err = AppendIntf(b, e); err != nil {
+				return b, err
+			}
+		}
+		return append(b, ']'), nil
+	case Marshaler:
+		return v.AppendCapLit(b)
+	}
+	return b, fmt.Errorf("caplit: type %T not supported", i)
+}
+
+// Marshaler is implemented by types generated with $Codec.caplit.
+type Marshaler interface {
+	AppendCapLit(b []byte) ([]byte, error)
+}
@@ -0,0 +1,59 @@
+package caplit
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAppendRoundTrip(t *testing.T) {
+	for _, v := range []interface{}{
+		nil,
+		true,
+		int64(math.MinInt64),
+		uint64(math.MaxUint64),
+		1.5,
+		math.Inf(-1),
+		"",
+		"tab\t\"quoted\" back\\slash\nline\x00\x7f é",
+		[]byte{},
+		[]byte{0, 0xff, 0x10},
+		[]interface{}{},
+		[]interface{}{int64(1), []interface{}{"a", []interface{}{true, nil}}, []byte{1}},
+	} {
+		b, err := AppendIntf(nil, v)
+		if err != nil {
+			t.Errorf("%#v: %v", v, err)
+			continue
+		}
+		p, err := Parse(b)
+		if err != nil {
+			t.Errorf("%#v is written as %s: %v", v, b, err)
+			continue
+		}
+		if out, err := p.Interface(); err != nil || !reflect.DeepEqual(out, v) {
+			t.Errorf("%#v is written as %s and read as %#v: %v", v, b, out, err)
+		}
+	}
+
+	if v, _ := Parse(AppendFloat(nil, math.NaN(), 64)); v == nil {
+		t.Errorf("nan is not parsed")
+	} else if f, err := v.Float(64); err != nil || !math.IsNaN(f) {
+		t.Errorf("nan is read as %v: %v", f, err)
+	}
+	if _, err := AppendIntf(nil, struct{}{}); err == nil {
+		t.Errorf("struct{} is written")
+	}
+}
+
+func TestAppendField(t *testing.T) {
+	b := append([]byte{}, '(')
+	b = AppendField(b, "a")
+	b = AppendInt(b, 1)
+	b = AppendField(b, "b")
+	b = AppendString(b, "x")
+	b = append(b, ')')
+	if string(b) != `(a = 1, b = "x")` {
+		t.Errorf("struct is written as %s", b)
+	}
+}
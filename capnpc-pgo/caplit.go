@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+const CAPLIT_IMPORT = "github.com/tpukep/caps/caplit"
+
+// caplitWriter writes Cap'n Proto text format (un)marshalers. Literals use
+// field and enumerant names of the schema, so they can be pasted to it.
+type caplitWriter struct {
+	codeWriter
+}
+
+// caplitScalar returns Go type and caplit.Value method reading the scalar
+// type, with bit size argument for numbers.
+func caplitScalar(t caps.Type) (string, string) {
+	switch t.Which() {
+	case caps.TYPE_BOOL:
+		return "bool", "Boolean()"
+	case caps.TYPE_INT8:
+		return "int8", "Int(8)"
+	case caps.TYPE_INT16:
+		return "int16", "Int(16)"
+	case caps.TYPE_INT32:
+		return "int32", "Int(32)"
+	case caps.TYPE_INT64:
+		return "int64", "Int(64)"
+	case caps.TYPE_UINT8:
+		return "uint8", "Uint(8)"
+	case caps.TYPE_UINT16:
+		return "uint16", "Uint(16)"
+	case caps.TYPE_UINT32:
+		return "uint32", "Uint(32)"
+	case caps.TYPE_UINT64:
+		return "uint64", "Uint(64)"
+	case caps.TYPE_FLOAT32:
+		return "float32", "Float(32)"
+	case caps.TYPE_FLOAT64:
+		return "float64", "Float(64)"
+	case caps.TYPE_TEXT:
+		return "string", "Str()"
+	case caps.TYPE_DATA:
+		return "[]byte", "Bytes()"
+	case caps.TYPE_ANYPOINTER:
+		return "interface{}", "Interface()"
+	}
+	return "", ""
+}
+
+func (c *caplitWriter) appendValue(expr, goType string, t caps.Type) {
+	switch t.Which() {
+	case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+		c.printf("b = append(b, \"void\"...)\n")
+	case caps.TYPE_BOOL:
+		c.printf("b = caplit.AppendBool(b, %s)\n", expr)
+	case caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_INT32, caps.TYPE_INT64:
+		// Conversion through capnp type keeps the sign of list elements
+		ctype, _ := caplitScalar(t)
+		c.printf("b = caplit.AppendInt(b, int64(%s(%s)))\n", ctype, expr)
+	case caps.TYPE_UINT8, caps.TYPE_UINT16, caps.TYPE_UINT32, caps.TYPE_UINT64:
+		c.printf("b = caplit.AppendUint(b, uint64(%s))\n", expr)
+	case caps.TYPE_FLOAT32:
+		c.printf("b = caplit.AppendFloat(b, float64(%s), 32)\n", expr)
+	case caps.TYPE_FLOAT64:
+		c.printf("b = caplit.AppendFloat(b, %s, 64)\n", expr)
+	case caps.TYPE_TEXT:
+		c.printf("b = caplit.AppendString(b, %s)\n", expr)
+	case caps.TYPE_DATA:
+		c.printf("b = caplit.AppendData(b, %s)\n", expr)
+	case caps.TYPE_ENUM, caps.TYPE_STRUCT:
+		c.printf("if b, err = %s.AppendCapLit(b); err != nil { return b, err }\n", expr)
+	case caps.TYPE_ANYPOINTER:
+		c.printf("if b, err = caplit.AppendIntf(b, %s); err != nil { return b, err }\n", expr)
+	case caps.TYPE_LIST:
+		i, v := c.newVar("i"), c.newVar("v")
+		c.printf("b = append(b, '[')\n")
+		c.printf("for %s, %s := range %s {\n", i, v, expr)
+		c.printf("if %s > 0 { b = append(b, \", \"...) }\n", i)
+		if et := t.List().ElementType(); goType == "[]interface{}" {
+			c.printf("if b, err = caplit.AppendIntf(b, %s); err != nil { return b, err }\n", v)
+		} else {
+			if et.Which() == caps.TYPE_VOID || et.Which() == caps.TYPE_INTERFACE {
+				c.printf("_ = %s\n", v)
+			}
+			c.appendValue(v, goType[2:], et)
+		}
+		c.printf("}\n")
+		c.printf("b = append(b, ']')\n")
+	}
+}
+
+func (c *caplitWriter) appendFields(n *node, expr string) {
+	c.printf("b = append(b, '(')\n")
+
+	union := false
+	for _, f := range n.codeOrderFields() {
+		if codecField(f).Ignored {
+			continue
+		}
+
+		// The union is written in place of its first member
+		if f.DiscriminantValue() != caps.FieldNoDiscriminant {
+			if !union {
+				c.appendUnion(n, expr)
+				union = true
+			}
+			continue
+		}
+
+		if !hasGoField(f) {
+			continue
+		}
+
+		// Unset presence fields are left out
+		if n.hasPresence(f) {
+			c.printf("if %s != nil {\n", expr+"."+goFieldName(f))
+			c.appendField(n, f, expr)
+			c.printf("}\n")
+		} else {
+			c.appendField(n, f, expr)
+		}
+	}
+
+	c.printf("b = append(b, ')')\n")
+}
+
+// appendUnion writes the member of union of n that is set, so the literal
+// sets only one of them.
+func (c *caplitWriter) appendUnion(n *node, expr string) {
+	c.printf("switch {\n")
+
+	var first caps.Field
+	for i, f := range n.unionMembers() {
+		if i == 0 {
+			first = f
+		}
+		if codecField(f).Ignored || !hasGoField(f) {
+			continue
+		}
+
+		fexpr := expr + "." + goFieldName(f)
+		switch ct := fieldCustomType(n, f); {
+		case n.hasPresence(f):
+			c.printf("case %s != nil:\n", fexpr)
+		case ct != nil:
+			c.printf("case %s:\n", nonZeroCond(ct.toWire(fexpr), f))
+		default:
+			c.printf("case %s:\n", nonZeroCond(fexpr, f))
+		}
+		c.appendField(n, f, expr)
+	}
+
+	// Every member is zero, so the first one is set. Unset presence
+	// member is left out.
+	c.printf("default:\n")
+	switch {
+	case codecField(first).Ignored:
+	case first.Which() == caps.FIELD_SLOT && first.Slot().Type().Which() == caps.TYPE_VOID:
+		c.printf("b = caplit.AppendField(b, %q)\n", first.Name())
+		c.printf("b = append(b, \"void\"...)\n")
+	case hasGoField(first) && !n.hasPresence(first):
+		c.appendField(n, first, expr)
+	}
+
+	c.printf("}\n")
+}
+
+// appendField writes field f of struct n, which is set if it is presence
+// field.
+func (c *caplitWriter) appendField(n *node, f caps.Field, expr string) {
+	fexpr := expr + "." + goFieldName(f)
+
+	c.printf("b = caplit.AppendField(b, %q)\n", f.Name())
+	switch ct := fieldCustomType(n, f); {
+	case f.Which() == caps.FIELD_GROUP:
+		c.appendFields(findNode(f.Group().TypeId()), fexpr)
+	case n.hasPresence(f):
+		c.appendValue("*"+fexpr, GoTypeName(n, f.Slot(), ""), f.Slot().Type())
+	case ct != nil:
+		c.appendValue(ct.toWire(fexpr), ct.wire, f.Slot().Type())
+	default:
+		c.appendValue(fexpr, GoTypeName(n, f.Slot(), ""), f.Slot().Type())
+	}
+}
+
+func (c *caplitWriter) readValue(expr, goType string, t caps.Type, val string) {
+	switch t.Which() {
+	case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+		c.printf("if err := %s.IsVoid(); err != nil { return err }\n", val)
+	case caps.TYPE_ENUM, caps.TYPE_STRUCT:
+		c.printf("if err := %s.DecodeCapLit(%s); err != nil { return err }\n", expr, val)
+	case caps.TYPE_LIST:
+		items, i, item := c.newVar("items"), c.newVar("i"), c.newVar("item")
+		c.printf("%s, err := %s.ListItems()\n", items, val)
+		c.printf("if err != nil { return err }\n")
+		c.printf("%s = make(%s, len(%s))\n", expr, goType, items)
+		c.printf("for %s, %s := range %s {\n", i, item, items)
+		elem := fmt.Sprintf("%s[%s]", expr, i)
+		if et := t.List().ElementType(); goType == "[]interface{}" {
+			c.printf("if %s, err = %s.Interface(); err != nil { return err }\n", elem, item)
+		} else {
+			c.readValue(elem, goType[2:], et, item)
+		}
+		c.printf("}\n")
+	default:
+		ctype, method := caplitScalar(t)
+		c.printf("{\n")
+		c.printf("x, err := %s.%s\n", val, method)
+		c.printf("if err != nil { return err }\n")
+		if strings.HasPrefix(ctype, "int") || strings.HasPrefix(ctype, "uint") || strings.HasPrefix(ctype, "float") {
+			c.printf("%s = %s(x)\n", expr, goType)
+		} else {
+			c.printf("%s = x\n", expr)
+		}
+		c.printf("}\n")
+	}
+}
+
+func (c *caplitWriter) readFields(n *node, expr, val string) {
+	fields, f := c.newVar("fields"), c.newVar("f")
+
+	c.printf("%s, err := %s.StructFields()\n", fields, val)
+	c.printf("if err != nil { return err }\n")
+
+	// Name of the union member set by the literal
+	member := ""
+	if n.Struct().DiscriminantCount() > 0 {
+		member = c.newVar("member")
+		c.printf("%s := \"\"\n", member)
+	}
+
+	c.printf("for _, %s := range %s {\n", f, fields)
+	c.printf("switch %s.Name {\n", f)
+
+	for _, fld := range n.codeOrderFields() {
+		c.printf("case %q:\n", fld.Name())
+		fexpr := expr + "." + goFieldName(fld)
+
+		if fld.DiscriminantValue() != caps.FieldNoDiscriminant {
+			c.printf("if %s != \"\" { return %s.Value.Errorf(\"%%s and %%s are members of the same union\", %s, %s.Name) }\n", member, f, member, f)
+			c.printf("%s = %s.Name\n", member, f)
+		}
+
+		if codecField(fld).Ignored {
+			c.printf("// ignored\n")
+			continue
+		}
+		if fld.Which() == caps.FIELD_GROUP {
+			c.printf("{\n")
+			c.readFields(findNode(fld.Group().TypeId()), fexpr, f+".Value")
+			c.printf("}\n")
+			continue
+		}
+
+		switch t := fld.Slot().Type(); {
+		case fieldCustomType(n, fld) != nil:
+			ct := fieldCustomType(n, fld)
+			c.printf("{\n")
+			c.printf("var v %s\n", ct.wire)
+			c.readValue("v", ct.wire, t, f+".Value")
+			c.printf("%s = %s\n", fexpr, ct.fromWire("v"))
+			c.printf("}\n")
+		case n.hasPresence(fld):
+			goType := GoTypeName(n, fld.Slot(), "")
+			c.printf("%s = new(%s)\n", fexpr, goType)
+			c.readValue("*"+fexpr, goType, t, f+".Value")
+		case t.Which() == caps.TYPE_VOID || t.Which() == caps.TYPE_INTERFACE:
+			c.readValue(fexpr, "", t, f+".Value")
+		case t.Which() == caps.TYPE_LIST:
+			c.printf("{\n")
+			c.readValue(fexpr, GoTypeName(n, fld.Slot(), ""), t, f+".Value")
+			c.printf("}\n")
+		default:
+			c.readValue(fexpr, GoTypeName(n, fld.Slot(), ""), t, f+".Value")
+		}
+	}
+
+	c.printf("default:\n")
+	c.printf("return %s.Value.Errorf(\"unknown field %%s of %s\", %s.Name)\n", f, n.name, f)
+	c.printf("}\n")
+	c.printf("}\n")
+}
+
+// defineCapLitMethods writes Cap'n Proto text format marshalers of the struct.
+func (n *node) defineCapLitMethods(w io.Writer) {
+	g_imported[CAPLIT_IMPORT] = true
+	c := &caplitWriter{codeWriter: codeWriter{w: w}}
+
+	c.printf("func (z *%s) MarshalCapLit() ([]byte, error) {\n", n.name)
+	c.printf("return z.AppendCapLit(nil)\n")
+	c.printf("}\n\n")
+
+	c.printf("// AppendCapLit appends %s in Cap'n Proto text format to b\n", n.name)
+	c.printf("func (z *%s) AppendCapLit(b []byte) ([]byte, error) {\n", n.name)
+	c.printf("var err error\n")
+	c.appendFields(n, "z")
+	c.printf("return b, err\n")
+	c.printf("}\n\n")
+
+	c.printf("func (z *%s) UnmarshalCapLit(data []byte) error {\n", n.name)
+	c.printf("v, err := caplit.Parse(data)\n")
+	c.printf("if err != nil { return err }\n")
+	c.printf("return z.DecodeCapLit(v)\n")
+	c.printf("}\n\n")
+
+	c.printf("// DecodeCapLit sets %s from parsed struct literal\n", n.name)
+	c.printf("func (z *%s) DecodeCapLit(v *caplit.Value) error {\n", n.name)
+	c.readFields(n, "z", "v")
+	c.printf("return nil\n")
+	c.printf("}\n\n")
+}
+
+// defineEnumCapLit writes enum marshalers using enumerant names of the schema.
+func (n *node) defineEnumCapLit(w io.Writer, ev []enumval) {
+	g_imported[CAPLIT_IMPORT] = true
+	g_imported["fmt"] = true
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "func (c %s) AppendCapLit(b []byte) ([]byte, error) {\n", n.name)
+	fmt.Fprintf(w, "switch c {\n")
+	for _, e := range ev {
+		fmt.Fprintf(w, "case %s: return append(b, \"%s\"...), nil\n", e.fullName(), e.Name())
+	}
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "return b, fmt.Errorf(\"invalid %s value %%d\", c)\n", n.name)
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "func (c *%s) DecodeCapLit(v *caplit.Value) error {\n", n.name)
+	fmt.Fprintf(w, "name, err := v.Enum()\n")
+	fmt.Fprintf(w, "if err != nil { return err }\n")
+	fmt.Fprintf(w, "switch name {\n")
+	for _, e := range ev {
+		fmt.Fprintf(w, "case \"%s\": *c = %s\n", e.Name(), e.fullName())
+	}
+	fmt.Fprintf(w, "default: return v.Errorf(\"unknown enumerant %%s of %s\", name)\n", n.name)
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "return nil\n")
+	fmt.Fprintf(w, "}\n")
+}
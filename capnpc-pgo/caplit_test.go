@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tpukep/caps"
+)
+
+const (
+	contactFileID = 0xe1a5c0ffee000500 + iota
+	contactID
+	contactKindID
+)
+
+// contactFile holds:
+//
+//	struct Contact {
+//	  name    @0 :Text;
+//	  union { phone @1 :Text; email @2 :Text; none @3 :Void; }
+//	  tags    @4 :List(List(Text));
+//	  kind    @5 :Kind;
+//	  data    @6 :Data;
+//	  friends @7 :List(Contact);
+//	}
+//	enum Kind { home @0; work @1; }
+var contactFile = testFile{id: contactFileID, name: "contact.capnp",
+	anns: []testAnnotation{goPackage("gentest"), {id: caps.CodecCaplit}},
+	nodes: []testNode{
+		{id: contactID, name: "Contact", data: 1, ptrs: 5, discCount: 3, fields: []testField{
+			{name: "name", typ: textType, disc: -1},
+			{name: "phone", typ: textType, offset: 1, disc: 0},
+			{name: "email", typ: textType, offset: 1, disc: 1},
+			{name: "none", typ: voidType, disc: 2},
+			{name: "tags", typ: listTypeOf(listTypeOf(textType)), offset: 2, disc: -1},
+			{name: "kind", typ: enumTypeOf(contactKindID), offset: 1, disc: -1},
+			{name: "data", typ: dataType, offset: 3, disc: -1},
+			{name: "friends", typ: listTypeOf(structTypeOf(contactID)), offset: 4, disc: -1},
+		}},
+		{id: contactKindID, name: "Kind", enumerants: []string{"home", "work"}},
+	},
+}
+
+const caplitTest = `package gentest
+
+import "testing"
+
+func TestCapLit(t *testing.T) {
+	for _, tt := range []struct {
+		in   Contact
+		want string
+	}{
+		// Every union member is zero, the first one is written
+		{Contact{}, "(name = \"\", phone = \"\", tags = [], kind = home, data = 0x\"\", friends = [])"},
+		{Contact{Email: "a@b"}, "(name = \"\", email = \"a@b\", tags = [], kind = home, data = 0x\"\", friends = [])"},
+		{Contact{Phone: "1", Email: "a@b"}, "(name = \"\", phone = \"1\", tags = [], kind = home, data = 0x\"\", friends = [])"},
+		{Contact{Name: "q\"\n", Tags: []interface{}{[]interface{}{"a", "b"}, []interface{}{}}, Kind: KIND_WORK, Data: []byte{1, 0xff},
+			Friends: []Contact{{Email: "f"}}},
+			"(name = \"q\\\"\\n\", phone = \"\", tags = [[\"a\", \"b\"], []], kind = work, data = 0x\"01ff\", " +
+				"friends = [(name = \"\", email = \"f\", tags = [], kind = home, data = 0x\"\", friends = [])])"},
+	} {
+		b, err := tt.in.MarshalCapLit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%+v is written as\n%s, want\n%s", tt.in, b, tt.want)
+		}
+		var out Contact
+		if err := out.UnmarshalCapLit(b); err != nil {
+			t.Errorf("%s: %v", b, err)
+		}
+		// Only the first of members set is written
+		if tt.in.Phone != "" && tt.in.Email != "" {
+			tt.in.Email = ""
+		}
+		if !out.Equal(&tt.in) {
+			t.Errorf("%s is read as %+v, want %+v", b, out, tt.in)
+		}
+	}
+
+	for _, tt := range []struct{ in, err string }{
+		{"(phone = \"1\", email = \"x\")", "caplit: 1:23: phone and email are members of the same union"},
+		{"(email = \"x\", none = void)", "caplit: 1:22: email and none are members of the same union"},
+		{"(none = 1)", "caplit: 1:9: expected void, got number"},
+		{"(kind = office)", "caplit: 1:9: unknown enumerant office of Kind"},
+		{"(address = \"x\")", "caplit: 1:12: unknown field address of Contact"},
+		{"(tags = \"a\")", "caplit: 1:9: expected list, got text"},
+		{"(friends = [(name = 1)])", "caplit: 1:21: expected text, got number"},
+		{"[]", "caplit: 1:1: expected struct, got list"},
+	} {
+		var c Contact
+		if err := c.UnmarshalCapLit([]byte(tt.in)); err == nil || err.Error() != tt.err {
+			t.Errorf("%s: error is %v, want %s", tt.in, err, tt.err)
+		}
+	}
+}
+`
+
+func TestCapLit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated package")
+	}
+	goTest(t, generate(t, nil, contactFile), caplitTest)
+}
@@ -5,10 +5,11 @@ import (
 	"fmt"
 	"go/ast"
 	"go/format"
+	"go/token"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -117,6 +118,33 @@ func nameAnnotation(annotations caps.Annotation_List) string {
 	return ""
 }
 
+func docAnnotation(annotations caps.Annotation_List) string {
+	for _, a := range annotations.ToArray() {
+		if a.Id() == C.Doc {
+			return a.Value().Text()
+		}
+	}
+	return ""
+}
+
+// enumTag returns the string form of the enumerant used by String() and
+// FromString(), or "" if the enumerant is annotated with $Go.notag.
+func enumTag(e caps.Enumerant) string {
+	t := e.Name()
+	if an := nameAnnotation(e.Annotations()); an != "" {
+		t = an
+	}
+
+	for _, an := range e.Annotations().ToArray() {
+		if an.Id() == C.Tag {
+			t = an.Value().Text()
+		} else if an.Id() == C.Notag {
+			t = ""
+		}
+	}
+	return t
+}
+
 type enumval struct {
 	caps.Enumerant
 	val    int
@@ -137,6 +165,7 @@ func (n *node) defineEnum(w io.Writer, x *bam.Extractor) {
 	}
 	fmt.Fprintf(w, "type %s uint16\n", n.name)
 	x.NewEnum(n.name)
+	n.defineTypeMeta(w)
 
 	if es := n.Enum().Enumerants(); es.Len() > 0 {
 		fmt.Fprintf(w, "const (\n")
@@ -149,15 +178,7 @@ func (n *node) defineEnum(w io.Writer, x *bam.Extractor) {
 				ename = an
 			}
 
-			t := ename
-			for _, an := range e.Annotations().ToArray() {
-				if an.Id() == C.Tag {
-					t = an.Value().Text()
-				} else if an.Id() == C.Notag {
-					t = ""
-				}
-			}
-			ev[e.CodeOrder()] = enumval{e, i, ename, t, n}
+			ev[e.CodeOrder()] = enumval{e, i, ename, enumTag(e), n}
 		}
 
 		// not an iota, so type has to go on each line
@@ -186,6 +207,25 @@ func (n *node) defineEnum(w io.Writer, x *bam.Extractor) {
 		}
 		fmt.Fprintf(w, "default: return 0\n")
 		fmt.Fprintf(w, "}\n}\n")
+
+		_, yaml := n.codecs[caps.CodecYaml]
+		_, toml := n.codecs[caps.CodecToml]
+		if yaml || toml || n.jsonDescribed() {
+			n.defineEnumSetString(w, ev)
+		}
+		if n.jsonDescribed() {
+			n.defineEnumJSON(w)
+		}
+		if yaml {
+			n.defineEnumYAML(w)
+		}
+		if toml {
+			n.defineEnumTOML(w)
+		}
+
+		if _, found := n.codecs[caps.CodecCaplit]; found {
+			n.defineEnumCapLit(w, ev)
+		}
 	}
 }
 
@@ -243,7 +283,7 @@ func (n *node) writeValue(w io.Writer, t caps.Type, v caps.Value) {
 		fmt.Fprintf(w, "float64(%f)", v.Float64())
 
 	case caps.TYPE_TEXT:
-		assert(v.Which() == caps.VALUE_TEXT, "expected text value"+" got "+strconv.Itoa(int(v.Which())))
+		assert(v.Which() == caps.VALUE_TEXT, "expected text value got %d", v.Which())
 		fmt.Fprintf(w, "%s", strconv.Quote(v.Text()))
 
 	case caps.TYPE_DATA:
@@ -586,27 +626,15 @@ func (n *node) defineField(w io.Writer, f caps.Field, x *bam.Extractor) {
 		return
 	}
 
-	customtype := ""
 	for _, a := range f.Annotations().ToArray() {
 		if a.Id() == C.Doc {
 			fmt.Fprintf(&g, "// %s\n", a.Value().Text())
 		}
-		if a.Id() == C.Customtype {
-			customtype = a.Value().Text()
-			if i := strings.LastIndex(customtype, "."); i != -1 {
-				g_imported[customtype[:i]] = true
-			}
-		}
-	}
-
-	if len(customtype) != 0 {
-		log.Println("CUSTOM TYPE:", customtype)
 	}
 
-	fmt.Fprintf(&s, "%s ", fname)
+	fmt.Fprintf(&s, "%s %s", fname, n.fieldGoType(f))
 
-	typeName := GoTypeName(n, f.Slot(), customtype)
-	fmt.Fprintf(&s, "%s", typeName)
+	typeName := GoTypeName(n, f.Slot(), "")
 
 	fld := &ast.Field{}
 	goseq := strings.SplitAfter(typeName, "[]")
@@ -616,6 +644,12 @@ func (n *node) defineField(w io.Writer, f caps.Field, x *bam.Extractor) {
 		typePrefix = goseq[0]
 	}
 
+	// bambam can't translate custom types and pointers, translators of these
+	// fields are written by writeTranslators
+	if fieldCustomType(n, f) != nil || n.hasPresence(f) {
+		fld.Tag = &ast.BasicLit{Kind: token.STRING, Value: "`capid:\"skip\"`"}
+	}
+
 	x.GenerateStructField(fname, typePrefix, typeName, fld, t.Which() == caps.TYPE_LIST, fld.Tag, false, goseq)
 
 	ans := f.Annotations()
@@ -627,7 +661,25 @@ func (n *node) defineField(w io.Writer, f caps.Field, x *bam.Extractor) {
 	w.Write(s.Bytes())
 }
 
+// fieldGoType returns type of the field in generated struct
+func (n *node) fieldGoType(f caps.Field) string {
+	if c := fieldCustomType(n, f); c != nil {
+		return GoTypeName(n, f.Slot(), c.name)
+	}
+
+	typeName := GoTypeName(n, f.Slot(), "")
+	if n.hasPresence(f) {
+		return "*" + typeName
+	}
+	return typeName
+}
+
+// GoTypeName returns Go type of the slot, customtype if it is set.
 func GoTypeName(n *node, s caps.FieldSlot, customtype string) string {
+	if customtype != "" {
+		return customtype
+	}
+
 	def := s.DefaultValue()
 	t := s.Type()
 
@@ -683,21 +735,7 @@ func GoTypeName(n *node, s caps.FieldSlot, customtype string) string {
 
 	case caps.TYPE_DATA:
 		assert(def.Which() == caps.VALUE_VOID || def.Which() == caps.VALUE_DATA, "expected data default")
-		if def.Which() == caps.VALUE_DATA && len(def.Data()) > 0 {
-			dstr := "[]byte{"
-			for i, b := range def.Data() {
-				if i > 0 {
-					dstr += ", "
-				}
-				dstr += fmt.Sprintf("%d", b)
-			}
-			dstr += "}"
-			if len(customtype) != 0 {
-				return fmt.Sprintf("%s\n", dstr)
-			}
-		} else {
-			return "[]byte"
-		}
+		return "[]byte"
 	case caps.TYPE_ENUM:
 		ni := findNode(t.Enum().TypeId())
 		assert(def.Which() == caps.VALUE_VOID || def.Which() == caps.VALUE_ENUM, "expected enum default")
@@ -768,9 +806,38 @@ func (n *node) codeOrderFields() []caps.Field {
 	return mbrs
 }
 
+// ordinalOrderFields returns fields sorted by ordinal. Groups take ordinal
+// of their first member.
+func (n *node) ordinalOrderFields() []caps.Field {
+	mbrs := n.codeOrderFields()
+	sort.Stable(byOrdinal(mbrs))
+	return mbrs
+}
+
+type byOrdinal []caps.Field
+
+func (s byOrdinal) Len() int           { return len(s) }
+func (s byOrdinal) Less(i, j int) bool { return fieldNumber(s[i]) < fieldNumber(s[j]) }
+func (s byOrdinal) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// msgpTuple reports whether msgp encodes the struct as array
+func (n *node) msgpTuple() bool {
+	if _, found := n.codecs[caps.CodecMsgp]; !found {
+		return false
+	}
+	if _, found := n.codecs[caps.CodecMsgpTuple]; found {
+		return true
+	}
+	return caps.HasAnnotation(n.Annotations(), caps.CodecMsgpTuple)
+}
+
 func (n *node) defineStructTypes(w io.Writer, baseNode *node, x *bam.Extractor) {
 	assert(n.Which() == caps.NODE_STRUCT, "invalid struct node")
 
+	if baseNode == nil && n.msgpTuple() {
+		fmt.Fprintf(w, "//msgp:tuple %s\n\n", n.name)
+	}
+
 	for _, a := range n.Annotations().ToArray() {
 		if a.Id() == C.Doc {
 			fmt.Fprintf(w, "// %s\n", a.Value().Text())
@@ -785,6 +852,49 @@ func (n *node) defineStructTypes(w io.Writer, baseNode *node, x *bam.Extractor)
 
 		baseNode = n
 		x.EndStruct()
+
+		n.defineStructMeta(w)
+		n.definePresenceMethods(w, n, "", "")
+
+		if n.cloneable() {
+			n.defineCloneMethods(w)
+		}
+
+		if _, found := n.codecs[caps.CodecDiff]; found {
+			n.defineDiffMethods(w)
+		}
+
+		if _, found := n.codecs[caps.CodecMask]; found {
+			n.defineMaskMethods(w)
+		}
+
+		if n.hasSensitive(make(map[uint64]bool)) {
+			n.defineRedactMethods(w)
+		}
+
+		if n.describedUnion() {
+			n.defineUnionJSON(w)
+		}
+
+		if _, found := n.codecs[caps.CodecYaml]; found {
+			n.defineYAMLMethods(w)
+		}
+
+		if _, found := n.codecs[caps.CodecCbor]; found {
+			n.defineCBORMethods(w)
+		}
+
+		if _, found := n.codecs[caps.CodecSql]; found {
+			n.defineSQL(w)
+		}
+
+		if _, found := n.codecs[caps.CodecCaplit]; found {
+			n.defineCapLitMethods(w)
+		}
+
+		if _, found := n.codecs[caps.CodecRandom]; found {
+			n.defineRandom(w)
+		}
 	}
 
 	for _, f := range n.codeOrderFields() {
@@ -821,7 +931,13 @@ func (n *node) defineStructEnums(w io.Writer) {
 func (n *node) defineStructFields(w io.Writer, x *bam.Extractor) {
 	assert(n.Which() == caps.NODE_STRUCT, "invalid struct node")
 
-	for _, f := range n.codeOrderFields() {
+	fields := n.codeOrderFields()
+	if n.msgpTuple() {
+		// Tuple elements follow order of the struct fields
+		fields = n.ordinalOrderFields()
+	}
+
+	for _, f := range fields {
 		switch f.Which() {
 		case caps.FIELD_SLOT:
 			n.defineField(w, f, x)
@@ -860,6 +976,16 @@ func (n *node) writeImports(file *os.File) {
 	}
 }
 
+// codecField returns codec description of the field and stops generation
+// for annotations which can't be combined.
+func codecField(f caps.Field) caps.FieldCodec {
+	c := caps.CodecOf(f)
+	if err := c.Err(); err != nil {
+		assert(false, "Field %s: %v", f.Name(), err)
+	}
+	return c
+}
+
 func (n *node) processAnnotations(w io.Writer, f caps.Field, t caps.Type_Which, ans caps.Annotation_List) {
 	annotations := make(map[uint64]caps.Annotation)
 
@@ -867,51 +993,86 @@ func (n *node) processAnnotations(w io.Writer, f caps.Field, t caps.Type_Which,
 		annotations[a.Id()] = a
 	}
 
-	req, required := annotations[caps.FieldRequired]
-	opt, optional := annotations[caps.FieldOptional]
-	_, ignored := annotations[caps.FieldIgnored]
-
-	assert(!(required && ignored), "Field annnotations 'required' and 'ignored' are incompatible.")
-	assert(!(required && optional), "Annnotations 'required' and 'optional' are incompatible")
-	assert(!(optional && ignored), "Annnotations 'optional' and 'ignored' are incompatible")
+	fc := codecField(f)
 
 	var tags []string
 	var checkTags []string
 
+	// Data is written by generated MarshalYAML as base64 text, custom
+	// types marshal themselves
+	ct := fieldCustomType(n, f)
+	yamlName := fc.Name
+	if t == caps.TYPE_DATA && ct == nil {
+		yamlName = "-"
+	}
+
+	msgName := fc.Name
+	if ct != nil && ct.ext {
+		msgName += ",extension"
+	}
+
 	// Codecs Tags
-	if ignored {
+	if fc.Ignored {
 		if _, found := n.codecs[caps.CodecJson]; found {
 			tags = append(tags, fmt.Sprintf("json:\"-\""))
 		}
+		if _, found := n.codecs[caps.CodecYaml]; found {
+			tags = append(tags, fmt.Sprintf("yaml:\"-\""))
+		}
+		if _, found := n.codecs[caps.CodecToml]; found {
+			tags = append(tags, fmt.Sprintf("toml:\"-\""))
+		}
 		if _, found := n.codecs[caps.CodecMsgp]; found {
 			tags = append(tags, fmt.Sprintf("msg:\"-\""))
 		}
 
 		checkTags = append(checkTags, "-")
-	} else if optional {
+	} else if fc.Optional {
 		if _, found := n.codecs[caps.CodecJson]; found {
-			tags = append(tags, fmt.Sprintf("json:\"%s,omitempty\"", opt.Value().Text()))
+			tags = append(tags, fmt.Sprintf("json:\"%s,omitempty\"", fc.Name))
+		}
+		if _, found := n.codecs[caps.CodecYaml]; found {
+			if yamlName == "-" {
+				tags = append(tags, fmt.Sprintf("yaml:\"-\""))
+			} else {
+				tags = append(tags, fmt.Sprintf("yaml:\"%s,omitempty\"", yamlName))
+			}
+		}
+		if _, found := n.codecs[caps.CodecToml]; found {
+			tags = append(tags, fmt.Sprintf("toml:\"%s,omitempty\"", fc.Name))
 		}
 		if _, found := n.codecs[caps.CodecMsgp]; found {
-			tags = append(tags, fmt.Sprintf("msg:\"%s\"", opt.Value().Text()))
+			tags = append(tags, fmt.Sprintf("msg:\"%s\"", msgName))
 		}
 
 		checkTags = append(checkTags, "omitempty")
-	} else if required {
+	} else if fc.Required {
 		if _, found := n.codecs[caps.CodecJson]; found {
-			tags = append(tags, fmt.Sprintf("json:\"%s\"", req.Value().Text()))
+			tags = append(tags, fmt.Sprintf("json:\"%s\"", fc.Name))
+		}
+		if _, found := n.codecs[caps.CodecYaml]; found {
+			tags = append(tags, fmt.Sprintf("yaml:\"%s\"", yamlName))
+		}
+		if _, found := n.codecs[caps.CodecToml]; found {
+			tags = append(tags, fmt.Sprintf("toml:\"%s\"", fc.Name))
 		}
 		if _, found := n.codecs[caps.CodecMsgp]; found {
-			tags = append(tags, fmt.Sprintf("msg:\"%s\"", req.Value().Text()))
+			tags = append(tags, fmt.Sprintf("msg:\"%s\"", msgName))
 		}
 
 		checkTags = append(checkTags, "required")
 	} else {
 		if _, found := n.codecs[caps.CodecJson]; found {
-			tags = append(tags, fmt.Sprintf("json:\"%s\"", f.Name()))
+			tags = append(tags, fmt.Sprintf("json:\"%s\"", fc.Name))
+		}
+		if _, found := n.codecs[caps.CodecYaml]; found {
+			tags = append(tags, fmt.Sprintf("yaml:\"%s\"", yamlName))
+		}
+		if _, found := n.codecs[caps.CodecToml]; found {
+			tags = append(tags, fmt.Sprintf("toml:\"%s\"", fc.Name))
 		}
 		if _, found := n.codecs[caps.CodecMsgp]; found {
-			tags = append(tags, fmt.Sprintf("msg:\"%s\"", f.Name()))
+			tags = append(tags, fmt.Sprintf("msg:\"%s\"", msgName))
 		}
 	}
 
@@ -945,8 +1106,6 @@ func main() {
 		}
 	}
 
-	g_imported = make(map[string]bool)
-
 	for _, f := range allfiles {
 		for _, a := range f.Annotations().ToArray() {
 			if v := a.Value(); v.Which() == caps.VALUE_TEXT {
@@ -960,16 +1119,53 @@ func main() {
 				switch a.Id() {
 				case caps.CodecCapnp:
 					enableCodec(f, caps.CodecCapnp)
-					g_imported["io"] = true
-					g_imported[GO_CAPNP_IMPORT] = true
 				case caps.CodecJson:
 					enableCodec(f, caps.CodecJson)
 				case caps.CodecMsgp:
 					enableCodec(f, caps.CodecMsgp)
+				case caps.CodecJsonSchema:
+					enableCodec(f, caps.CodecJsonSchema)
+				case caps.CodecOpenApi:
+					enableCodec(f, caps.CodecOpenApi)
+				case caps.CodecProto:
+					enableCodec(f, caps.CodecProto)
+				case caps.CodecTypescript:
+					enableCodec(f, caps.CodecTypescript)
+				case caps.CodecYaml:
+					enableCodec(f, caps.CodecYaml)
+				case caps.CodecToml:
+					enableCodec(f, caps.CodecToml)
+				case caps.CodecCbor:
+					enableCodec(f, caps.CodecCbor)
+				case caps.CodecCborOrdinalKeys:
+					enableCodec(f, caps.CodecCborOrdinalKeys)
+				case caps.CodecSql:
+					enableCodec(f, caps.CodecSql)
+				case caps.CodecMsgpTuple:
+					enableCodec(f, caps.CodecMsgpTuple)
+				case caps.CodecCaplit:
+					enableCodec(f, caps.CodecCaplit)
+				case caps.CodecRegistry:
+					enableCodec(f, caps.CodecRegistry)
+				case caps.CodecNoClone:
+					enableCodec(f, caps.CodecNoClone)
+				case caps.CodecRandom:
+					enableCodec(f, caps.CodecRandom)
+				case caps.CodecDiff:
+					enableCodec(f, caps.CodecDiff)
+				case caps.CodecMask:
+					enableCodec(f, caps.CodecMask)
+				case caps.FieldPresence:
+					enableCodec(f, caps.FieldPresence)
 				}
 			}
 		}
 
+		// Generated tests take values from RandomX
+		if os.Getenv(caps.TestsEnv) != "" {
+			enableCodec(f, caps.CodecRandom)
+		}
+
 		for _, nn := range f.NestedNodes().ToArray() {
 			if ni := g_nodes[nn.Id()]; ni != nil {
 				ni.resolveName("", nn.Name(), f)
@@ -986,6 +1182,17 @@ func main() {
 		buf := bytes.Buffer{}
 		g_segment = C.NewBuffer([]byte{})
 
+		// Imports are collected while the code of the file is written
+		g_imported = make(map[string]bool)
+		if _, found := f.codecs[caps.CodecCapnp]; found {
+			g_imported["io"] = true
+			g_imported[GO_CAPNP_IMPORT] = true
+		}
+
+		if _, found := f.codecs[caps.CodecMsgp]; found {
+			defineMsgpShims(&buf, f)
+		}
+
 		defineConstNodes(&buf, f.nodes)
 
 		for _, n := range f.nodes {
@@ -1002,24 +1209,48 @@ func main() {
 			}
 		}
 
+		if _, found := f.codecs[caps.CodecRegistry]; found {
+			writeRegistry(&buf, f, req)
+		}
+
 		// Write translation functions
 		if _, found := f.codecs[caps.CodecCapnp]; found {
-			_, err = x.WriteToTranslators(&buf)
-			assert(err == nil, "%v\n", err)
+			writeTranslators(&buf, x, f.nodes)
 		}
 
 		assert(f.pkg != "", "missing package annotation for %s", reqf.Filename())
 		x.PkgName = f.pkg
 
-		if dirPath, _ := filepath.Split(reqf.Filename()); dirPath != "" {
+		dirPath, _ := filepath.Split(reqf.Filename())
+		if dirPath != "" {
 			err := os.MkdirAll(dirPath, os.ModePerm)
 			assert(err == nil, "%v\n", err)
 			x.OutDir = dirPath
 		}
 
+		if _, found := f.codecs[caps.CodecJsonSchema]; found {
+			writeJSONSchemas(dirPath, f)
+		}
+
 		// Create output file
 		filename := strings.TrimSuffix(reqf.Filename(), ".capnp")
 
+		if _, found := f.codecs[caps.CodecOpenApi]; found {
+			writeOpenAPI(filename+".openapi.json", f)
+		}
+
+		if _, found := f.codecs[caps.CodecProto]; found {
+			writeProto(filename+".proto", f)
+		}
+
+		if _, found := f.codecs[caps.CodecTypescript]; found {
+			writeTypeScript(filename+".ts", f)
+		}
+
+		if os.Getenv(caps.TestsEnv) != "" {
+			writeTests(filename+"_test.go", f)
+		}
+
 		file, err := os.Create(filename + ".go")
 		assert(err == nil, "%v\n", err)
 
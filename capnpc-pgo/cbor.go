@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+const CBOR_IMPORT = "github.com/tpukep/caps/cbor"
+
+// goFieldName returns name of the Go struct field generated for the field.
+func goFieldName(f caps.Field) string {
+	if an := nameAnnotation(f.Annotations()); an != "" {
+		return strings.Title(an)
+	}
+	return strings.Title(f.Name())
+}
+
+// cborWriter writes CBOR (un)marshalers of a struct. Map keys are either
+// codec names of the fields or their ordinals.
+type cborWriter struct {
+	codeWriter
+	ordinal bool
+}
+
+// cborFields returns fields of the struct encoded by the codec.
+func cborFields(n *node) []caps.Field {
+	var fields []caps.Field
+	for _, f := range n.codeOrderFields() {
+		if codecField(f).Ignored {
+			continue
+		}
+		if f.Which() == caps.FIELD_SLOT {
+			switch f.Slot().Type().Which() {
+			case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+				continue
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// key returns Go literal of the map key of the field. Groups have no
+// ordinal on their own, they take one of their first member.
+func (c *cborWriter) key(f caps.Field) string {
+	if c.ordinal {
+		return fmt.Sprintf("%d", fieldNumber(f)-1)
+	}
+	return fmt.Sprintf("%q", codecField(f).Name)
+}
+
+func (c *cborWriter) appendKey(f caps.Field) {
+	if c.ordinal {
+		c.printf("b = cbor.AppendUint64(b, %s)\n", c.key(f))
+	} else {
+		c.printf("b = cbor.AppendString(b, %s)\n", c.key(f))
+	}
+}
+
+func cborPrimitive(goType string) (string, bool) {
+	switch goType {
+	case "bool":
+		return "Bool", true
+	case "int8", "int16", "int32", "int64":
+		return "Int64", true
+	case "uint8", "uint16", "uint32", "uint64":
+		return "Uint64", true
+	case "float32":
+		return "Float32", true
+	case "float64":
+		return "Float64", true
+	case "string":
+		return "String", true
+	case "[]byte", "[]uint8":
+		return "Bytes", true
+	case "interface{}":
+		return "Intf", true
+	}
+	return "", false
+}
+
+func (c *cborWriter) appendValue(expr, goType string, t caps.Type) {
+	// Unset presence fields are null
+	if strings.HasPrefix(goType, "*") {
+		c.printf("if %s == nil {\n", expr)
+		c.printf("b = cbor.AppendNil(b)\n")
+		c.printf("} else {\n")
+		c.appendValue("*"+expr, goType[1:], t)
+		c.printf("}\n")
+		return
+	}
+
+	if kind, ok := cborPrimitive(goType); ok {
+		switch kind {
+		case "Int64", "Uint64":
+			c.printf("b = cbor.Append%s(b, %s(%s))\n", kind, strings.ToLower(kind), expr)
+		case "Intf":
+			c.printf("b, err = cbor.AppendIntf(b, %s)\n", expr)
+			c.printf("if err != nil { return b, err }\n")
+		default:
+			c.printf("b = cbor.Append%s(b, %s)\n", kind, expr)
+		}
+		return
+	}
+
+	switch {
+	case goType == "struct{}":
+		c.printf("b = cbor.AppendNil(b)\n")
+	case goType == "[]struct{}":
+		c.printf("b = cbor.AppendArrayHeader(b, uint64(len(%s)))\n", expr)
+		c.printf("for range %s {\n", expr)
+		c.printf("b = cbor.AppendNil(b)\n")
+		c.printf("}\n")
+	case strings.HasPrefix(goType, "[]"):
+		v := c.newVar("v")
+		c.printf("b = cbor.AppendArrayHeader(b, uint64(len(%s)))\n", expr)
+		c.printf("for _, %s := range %s {\n", v, expr)
+		c.appendValue(v, goType[2:], t.List().ElementType())
+		c.printf("}\n")
+	case t.Which() == caps.TYPE_ENUM:
+		c.printf("b = cbor.AppendUint64(b, uint64(%s))\n", expr)
+	case t.Which() == caps.TYPE_STRUCT:
+		c.printf("b, err = %s.AppendCBOR(b)\n", expr)
+		c.printf("if err != nil { return b, err }\n")
+	default:
+		panic("Unsupported CBOR type " + goType)
+	}
+}
+
+func (c *cborWriter) appendFields(n *node, expr string) {
+	fields := cborFields(n)
+	c.printf("b = cbor.AppendMapHeader(b, %d)\n", len(fields))
+
+	for _, f := range fields {
+		c.appendKey(f)
+		fexpr := expr + "." + goFieldName(f)
+		if f.Which() == caps.FIELD_GROUP {
+			c.appendFields(findNode(f.Group().TypeId()), fexpr)
+			continue
+		}
+		if ct := fieldCustomType(n, f); ct != nil {
+			c.appendValue(ct.toWire(fexpr), ct.wire, f.Slot().Type())
+			continue
+		}
+		c.appendValue(fexpr, n.fieldGoType(f), f.Slot().Type())
+	}
+}
+
+func (c *cborWriter) readValue(expr, goType string, t caps.Type) {
+	if strings.HasPrefix(goType, "*") {
+		c.printf("if cbor.IsNil(b) {\n")
+		c.printf("%s = nil\n", expr)
+		c.printf("b = b[1:]\n")
+		c.printf("} else {\n")
+		c.printf("%s = new(%s)\n", expr, goType[1:])
+		c.readValue("*"+expr, goType[1:], t)
+		c.printf("}\n")
+		return
+	}
+
+	if kind, ok := cborPrimitive(goType); ok {
+		switch kind {
+		case "Int64", "Uint64":
+			kind = strings.Title(goType)
+		case "Float32", "Float64":
+			kind = strings.Title(goType)
+		}
+		c.printf("%s, b, err = cbor.Read%sBytes(b)\n", expr, kind)
+		c.printf("if err != nil { return b, err }\n")
+		return
+	}
+
+	switch {
+	case goType == "struct{}":
+		c.printf("b, err = cbor.Skip(b)\n")
+		c.printf("if err != nil { return b, err }\n")
+	case strings.HasPrefix(goType, "[]"):
+		sz, i := c.newVar("sz"), c.newVar("i")
+		c.printf("var %s uint64\n", sz)
+		c.printf("%s, b, err = cbor.ReadArrayHeaderBytes(b)\n", sz)
+		c.printf("if err != nil { return b, err }\n")
+		c.printf("%s = make(%s, %s)\n", expr, goType, sz)
+		c.printf("for %s := range %s {\n", i, expr)
+		c.readValue(fmt.Sprintf("%s[%s]", expr, i), goType[2:], t.List().ElementType())
+		c.printf("}\n")
+	case t.Which() == caps.TYPE_ENUM:
+		v := c.newVar("v")
+		c.printf("var %s uint16\n", v)
+		c.printf("%s, b, err = cbor.ReadUint16Bytes(b)\n", v)
+		c.printf("if err != nil { return b, err }\n")
+		c.printf("%s = %s(%s)\n", expr, goType, v)
+	case t.Which() == caps.TYPE_STRUCT:
+		c.printf("b, err = %s.DecodeCBOR(b)\n", expr)
+		c.printf("if err != nil { return b, err }\n")
+	default:
+		panic("Unsupported CBOR type " + goType)
+	}
+}
+
+// readFields decodes map into the struct. Unknown keys are skipped, so
+// data written by newer schema versions can be read.
+func (c *cborWriter) readFields(n *node, expr string) {
+	sz, key := c.newVar("sz"), c.newVar("key")
+
+	c.printf("var %s uint64\n", sz)
+	c.printf("%s, b, err = cbor.ReadMapHeaderBytes(b)\n", sz)
+	c.printf("if err != nil { return b, err }\n")
+	c.printf("for ; %s > 0; %s-- {\n", sz, sz)
+	if c.ordinal {
+		c.printf("var %s uint64\n", key)
+		c.printf("%s, b, err = cbor.ReadUint64Bytes(b)\n", key)
+	} else {
+		c.printf("var %s string\n", key)
+		c.printf("%s, b, err = cbor.ReadStringBytes(b)\n", key)
+	}
+	c.printf("if err != nil { return b, err }\n")
+	c.printf("switch %s {\n", key)
+
+	for _, f := range cborFields(n) {
+		c.printf("case %s:\n", c.key(f))
+		fexpr := expr + "." + goFieldName(f)
+		if f.Which() == caps.FIELD_GROUP {
+			c.readFields(findNode(f.Group().TypeId()), fexpr)
+			continue
+		}
+		if ct := fieldCustomType(n, f); ct != nil {
+			v := c.newVar("v")
+			c.printf("var %s %s\n", v, ct.wire)
+			c.readValue(v, ct.wire, f.Slot().Type())
+			c.printf("%s = %s\n", fexpr, ct.fromWire(v))
+			continue
+		}
+		c.readValue(fexpr, n.fieldGoType(f), f.Slot().Type())
+	}
+
+	c.printf("default:\n")
+	c.printf("b, err = cbor.Skip(b)\n")
+	c.printf("if err != nil { return b, err }\n")
+	c.printf("}\n")
+	c.printf("}\n")
+}
+
+// defineCBORMethods writes reflection free CBOR (RFC 8949) marshalers of
+// the struct. Structs are encoded as maps, groups as nested maps.
+func (n *node) defineCBORMethods(w io.Writer) {
+	g_imported[CBOR_IMPORT] = true
+
+	_, ordinal := n.codecs[caps.CodecCborOrdinalKeys]
+	c := &cborWriter{codeWriter: codeWriter{w: w}, ordinal: ordinal}
+
+	c.printf("func (z *%s) MarshalCBOR() ([]byte, error) {\n", n.name)
+	c.printf("return z.AppendCBOR(nil)\n")
+	c.printf("}\n\n")
+
+	c.printf("// AppendCBOR appends CBOR encoding of %s to b\n", n.name)
+	c.printf("func (z *%s) AppendCBOR(b []byte) (o []byte, err error) {\n", n.name)
+	c.appendFields(n, "z")
+	c.printf("return b, nil\n")
+	c.printf("}\n\n")
+
+	c.printf("func (z *%s) UnmarshalCBOR(data []byte) error {\n", n.name)
+	c.printf("o, err := z.DecodeCBOR(data)\n")
+	c.printf("if err == nil && len(o) > 0 { err = cbor.ErrTrailingBytes }\n")
+	c.printf("return err\n")
+	c.printf("}\n\n")
+
+	c.printf("// DecodeCBOR decodes %s from b and returns the remaining bytes\n", n.name)
+	c.printf("func (z *%s) DecodeCBOR(b []byte) (o []byte, err error) {\n", n.name)
+	c.readFields(n, "z")
+	c.printf("return b, nil\n")
+	c.printf("}\n\n")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+const SELF_IMPORT = "github.com/tpukep/caps"
+
+// cloneWriter writes Clone and Equal methods of a struct
+type cloneWriter struct {
+	codeWriter
+}
+
+// cloneable reports whether Clone and Equal are generated for the struct
+func (n *node) cloneable() bool {
+	if _, found := n.codecs[caps.CodecNoClone]; found {
+		return false
+	}
+	return !caps.HasAnnotation(n.Annotations(), caps.CodecNoClone)
+}
+
+// cloneStruct reports whether t is a struct with Clone and Equal methods
+func cloneStruct(t caps.Type) bool {
+	return t.Which() == caps.TYPE_STRUCT && findNode(t.Struct().TypeId()).cloneable()
+}
+
+// cloneFields deep copies fields of src to dst, which is already a shallow
+// copy of src.
+func (c *cloneWriter) cloneFields(n *node, dst, src string) {
+	for _, f := range n.codeOrderFields() {
+		name := "." + goFieldName(f)
+
+		if f.Which() == caps.FIELD_GROUP {
+			c.cloneFields(findNode(f.Group().TypeId()), dst+name, src+name)
+			continue
+		}
+
+		t := f.Slot().Type()
+		switch t.Which() {
+		case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+			continue
+		}
+		c.cloneValue(dst+name, src+name, n.fieldGoType(f), t)
+	}
+}
+
+func (c *cloneWriter) cloneValue(dst, src, goType string, t caps.Type) {
+	switch {
+	case goType == "interface{}":
+		g_imported[SELF_IMPORT] = true
+		c.printf("%s = caps.CloneValue(%s)\n", dst, src)
+
+	case t.Which() == caps.TYPE_STRUCT:
+		if cloneStruct(t) {
+			c.printf("%s = *%s.Clone()\n", dst, src)
+		}
+
+	case strings.HasPrefix(goType, "*"):
+		v := c.newVar("v")
+		c.printf("if %s != nil {\n", src)
+		c.printf("%s := *%s\n", v, src)
+		c.printf("%s = &%s\n", dst, v)
+		c.printf("}\n")
+
+	case strings.HasPrefix(goType, "[]"):
+		c.printf("if %s != nil {\n", src)
+		c.printf("%s = make(%s, len(%s))\n", dst, goType, src)
+
+		switch {
+		case goType[2:] == "interface{}":
+			g_imported[SELF_IMPORT] = true
+			i := c.newVar("i")
+			c.printf("for %s := range %s {\n", i, src)
+			c.printf("%s[%s] = caps.CloneValue(%s[%s])\n", dst, i, src, i)
+			c.printf("}\n")
+		case t.Which() == caps.TYPE_LIST && cloneStruct(t.List().ElementType()):
+			i := c.newVar("i")
+			c.printf("for %s := range %s {\n", i, src)
+			c.printf("%s[%s] = *%s[%s].Clone()\n", dst, i, src, i)
+			c.printf("}\n")
+		case goType != "[]struct{}":
+			c.printf("copy(%s, %s)\n", dst, src)
+		}
+		c.printf("}\n")
+	}
+}
+
+// equalFields compares fields of a and b
+func (c *cloneWriter) equalFields(n *node, a, b string) {
+	for _, f := range n.codeOrderFields() {
+		name := "." + goFieldName(f)
+
+		if f.Which() == caps.FIELD_GROUP {
+			c.equalFields(findNode(f.Group().TypeId()), a+name, b+name)
+			continue
+		}
+
+		t := f.Slot().Type()
+		switch t.Which() {
+		case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+			continue
+		}
+		if ct := fieldCustomType(n, f); ct != nil {
+			// Custom values are equal if their wire values are
+			c.equalValue(ct.toWire(a+name), ct.toWire(b+name), ct.wire, t)
+			continue
+		}
+		c.equalValue(a+name, b+name, n.fieldGoType(f), t)
+	}
+}
+
+func (c *cloneWriter) equalValue(a, b, goType string, t caps.Type) {
+	switch {
+	case goType == "interface{}":
+		g_imported[SELF_IMPORT] = true
+		c.printf("if !caps.EqualValue(%s, %s) { return false }\n", a, b)
+
+	case t.Which() == caps.TYPE_STRUCT:
+		if cloneStruct(t) {
+			c.printf("if !%s.Equal(&%s) { return false }\n", a, b)
+		} else {
+			g_imported["reflect"] = true
+			c.printf("if !reflect.DeepEqual(%s, %s) { return false }\n", a, b)
+		}
+
+	case strings.HasPrefix(goType, "*"):
+		c.printf("if (%s == nil) != (%s == nil) || %s != nil && *%s != *%s { return false }\n", a, b, a, a, b)
+
+	case goType == "[]byte" || goType == "[]uint8":
+		g_imported["bytes"] = true
+		c.printf("if !bytes.Equal(%s, %s) { return false }\n", a, b)
+
+	case goType == "[]struct{}":
+		c.printf("if len(%s) != len(%s) { return false }\n", a, b)
+
+	case strings.HasPrefix(goType, "[]"):
+		i := c.newVar("i")
+		c.printf("if len(%s) != len(%s) { return false }\n", a, b)
+		c.printf("for %s := range %s {\n", i, a)
+
+		ea, eb := fmt.Sprintf("%s[%s]", a, i), fmt.Sprintf("%s[%s]", b, i)
+		if t.Which() == caps.TYPE_LIST {
+			c.equalValue(ea, eb, goType[2:], t.List().ElementType())
+		} else {
+			// List(Data) is generated as []byte
+			c.printf("if %s != %s { return false }\n", ea, eb)
+		}
+		c.printf("}\n")
+
+	default:
+		c.printf("if %s != %s { return false }\n", a, b)
+	}
+}
+
+func (n *node) defineCloneMethods(w io.Writer) {
+	c := &cloneWriter{codeWriter: codeWriter{w: w}}
+
+	c.printf("// Clone returns deep copy of %s\n", n.name)
+	c.printf("func (z *%s) Clone() *%s {\n", n.name, n.name)
+	c.printf("if z == nil { return nil }\n")
+	c.printf("c := *z\n")
+	c.cloneFields(n, "c", "z")
+	c.printf("return &c\n")
+	c.printf("}\n\n")
+
+	c.printf("// Equal reports whether %s is deeply equal to o. Nil and empty lists are equal.\n", n.name)
+	c.printf("func (z *%s) Equal(o *%s) bool {\n", n.name, n.name)
+	c.printf("if z == nil || o == nil { return z == o }\n")
+	c.equalFields(n, "z", "o")
+	c.printf("return true\n")
+	c.printf("}\n\n")
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// codeWriter writes statements of generated functions. Local variables get
+// unique names from newVar.
+type codeWriter struct {
+	w    io.Writer
+	vars int
+}
+
+func (c *codeWriter) printf(format string, a ...interface{}) {
+	fmt.Fprintf(c.w, format, a...)
+}
+
+func (c *codeWriter) newVar(prefix string) string {
+	c.vars++
+	return fmt.Sprintf("%s%d", prefix, c.vars)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+// customType describes Go type of the field replaced with $Go.customtype.
+// Values are converted to and from Go type of the schema slot, the wire
+// type, either by Go conversion or by functions of $Field.converter.
+type customType struct {
+	name string // Go type like "time.Time"
+	wire string // Go type of the slot like "int64"
+	conv string // Prefix of converter functions like "conv.Time"
+	ext  bool   // Encoded by msgp as extension type
+}
+
+// qualifiedName imports package of name like "github.com/google/uuid.UUID"
+// and returns the name as used in generated code, like "uuid.UUID".
+func qualifiedName(name string) string {
+	i := strings.LastIndex(name, ".")
+	if i == -1 {
+		return name
+	}
+	g_imported[name[:i]] = true
+	return path.Base(name[:i]) + name[i:]
+}
+
+// fieldCustomType returns custom type of the field or nil. $Type
+// annotations are custom types with converters of the caps package.
+func fieldCustomType(n *node, f caps.Field) *customType {
+	if f.Which() != caps.FIELD_SLOT {
+		return nil
+	}
+
+	name := textAnnotation(f.Annotations(), C.Customtype)
+	conv := textAnnotation(f.Annotations(), caps.FieldConverter)
+	if c := semanticType(n, f); c != nil {
+		assert(name == "" && conv == "", "%s.%s: $Type annotation can't be used with $Go.customtype", n.DisplayName(), f.Name())
+		return c
+	}
+	if name == "" {
+		assert(conv == "", "%s.%s: $Field.converter requires $Go.customtype", n.DisplayName(), f.Name())
+		return nil
+	}
+
+	switch f.Slot().Type().Which() {
+	case caps.TYPE_VOID, caps.TYPE_STRUCT, caps.TYPE_LIST, caps.TYPE_ANYPOINTER, caps.TYPE_INTERFACE:
+		assert(false, "%s.%s: $Go.customtype applies to scalar, enum, Text and Data fields", n.DisplayName(), f.Name())
+	}
+	assert(!n.hasPresence(f), "%s.%s: $Go.customtype can't be used with $Field.presence", n.DisplayName(), f.Name())
+
+	c := &customType{name: qualifiedName(name), wire: GoTypeName(n, f.Slot(), "")}
+	if conv != "" {
+		c.conv = qualifiedName(conv)
+	}
+	return c
+}
+
+// semanticType returns custom type of $Type annotation of the field or nil
+func semanticType(n *node, f caps.Field) *customType {
+	var c *customType
+	var want caps.Type_Which
+	var wantName string
+
+	for _, a := range f.Annotations().ToArray() {
+		switch a.Id() {
+		case caps.TypeTimestamp:
+			unit := caps.TimeUnit(a.Value().Enum()).String()
+			assert(unit != "", "%s.%s: unknown unit of $Type.timestamp", n.DisplayName(), f.Name())
+			c, want, wantName = &customType{name: "time.Time", conv: SELF_IMPORT + ".Timestamp" + strings.Title(unit)}, caps.TYPE_INT64, "Int64"
+		case caps.TypeDuration:
+			c, want, wantName = &customType{name: "time.Duration"}, caps.TYPE_INT64, "Int64"
+		case caps.TypeUuid:
+			c, want, wantName = &customType{name: SELF_IMPORT + ".UUID", conv: SELF_IMPORT + ".UUID", ext: true}, caps.TYPE_DATA, "Data"
+		case caps.TypeIp:
+			c, want, wantName = &customType{name: "net/netip.Addr", conv: SELF_IMPORT + ".IP"}, caps.TYPE_DATA, "Data"
+		default:
+			continue
+		}
+		assert(f.Slot().Type().Which() == want, "%s.%s: $Type annotation requires %s field", n.DisplayName(), f.Name(), wantName)
+	}
+	if c == nil {
+		return nil
+	}
+	assert(!n.hasPresence(f), "%s.%s: $Type annotation can't be used with $Field.presence", n.DisplayName(), f.Name())
+
+	c.name = qualifiedName(c.name)
+	c.wire = GoTypeName(n, f.Slot(), "")
+	if c.conv != "" {
+		c.conv = qualifiedName(c.conv)
+	}
+	return c
+}
+
+// toWire returns expression converting custom value to the wire type
+func (c *customType) toWire(expr string) string {
+	if c.conv != "" {
+		return fmt.Sprintf("%sToCapn(%s)", c.conv, expr)
+	}
+	return fmt.Sprintf("%s(%s)", c.wire, expr)
+}
+
+// fromWire returns expression converting wire value to the custom type
+func (c *customType) fromWire(expr string) string {
+	if c.conv != "" {
+		return fmt.Sprintf("%sFromCapn(%s)", c.conv, expr)
+	}
+	return fmt.Sprintf("%s(%s)", c.name, expr)
+}
+
+// customShims collects msgp shims of custom types of the structs and their
+// groups.
+func customShims(n *node, shims map[string]*customType) {
+	for _, f := range n.codeOrderFields() {
+		if f.Which() == caps.FIELD_GROUP {
+			customShims(findNode(f.Group().TypeId()), shims)
+			continue
+		}
+
+		// msgp encodes time.Time and extensions itself
+		c := fieldCustomType(n, f)
+		if c == nil || c.name == "time.Time" || c.ext {
+			continue
+		}
+		if s, found := shims[c.name]; found {
+			assert(s.conv == c.conv && s.wire == c.wire, "%s.%s: %s has different converters", n.DisplayName(), f.Name(), c.name)
+			continue
+		}
+		shims[c.name] = c
+	}
+}
+
+// shimPrefix returns prefix of msgp shim functions of the file. msgp code
+// has no imports of converter packages, so shims wrap the converters.
+func shimPrefix(f *node, c *customType) string {
+	base := strings.TrimSuffix(path.Base(f.DisplayName()), ".capnp")
+
+	var name []byte
+	for i, part := range strings.FieldsFunc(base+"."+c.name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if i == 0 {
+			part = strings.ToLower(part[:1]) + part[1:]
+		} else {
+			part = strings.Title(part)
+		}
+		name = append(name, part...)
+	}
+	return string(name)
+}
+
+// defineMsgpShims writes msgp directives encoding custom types as their
+// wire values. msgp converts shimmed values to []byte with Go conversion
+// when reading, which custom types don't have, so Data is shimmed as string.
+func defineMsgpShims(w io.Writer, f *node) {
+	shims := make(map[string]*customType)
+	for _, n := range f.nodes {
+		if n.Which() == caps.NODE_STRUCT {
+			customShims(n, shims)
+		}
+	}
+
+	var names []string
+	for name := range shims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		c := shims[name]
+		p := shimPrefix(f, c)
+		wire, toWire, fromWire := c.wire, c.toWire("v"), c.fromWire("v")
+		if wire == "[]byte" {
+			wire, toWire, fromWire = "string", "string("+toWire+")", c.fromWire("[]byte(v)")
+		}
+		fmt.Fprintf(w, "//msgp:shim %s as:%s using:%sToCapn/%sFromCapn\n\n", c.name, wire, p, p)
+		fmt.Fprintf(w, "func %sToCapn(v %s) %s { return %s }\n\n", p, c.name, wire, toWire)
+		fmt.Fprintf(w, "func %sFromCapn(v %s) %s { return %s }\n\n", p, wire, c.name, fromWire)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+// diffWriter writes Diff methods of a struct
+type diffWriter struct {
+	codeWriter
+}
+
+// diffFields compares fields of a and b. path is Go expression of the
+// field path prefix.
+func (c *diffWriter) diffFields(n *node, a, b, path string) {
+	for _, f := range n.codeOrderFields() {
+		fc := codecField(f)
+		if fc.Ignored {
+			continue
+		}
+
+		name := "." + goFieldName(f)
+		fpath := fmt.Sprintf("%s+%q", path, fc.Name)
+
+		if f.Which() == caps.FIELD_GROUP {
+			c.diffFields(findNode(f.Group().TypeId()), a+name, b+name, fpath+`+"."`)
+			continue
+		}
+
+		t := f.Slot().Type()
+		switch t.Which() {
+		case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+			continue
+		}
+		if ct := fieldCustomType(n, f); ct != nil {
+			c.diffCustom(a+name, b+name, fpath, ct)
+			continue
+		}
+		c.diffValue(a+name, b+name, fpath, n.fieldGoType(f), t)
+	}
+}
+
+func (c *diffWriter) change(a, b, path string) {
+	c.printf("changes = append(changes, caps.FieldChange{Path: %s, Old: %s, New: %s})\n", path, a, b)
+}
+
+// diffCustom compares wire values of custom type fields
+func (c *diffWriter) diffCustom(a, b, path string, ct *customType) {
+	if ct.wire == "[]byte" {
+		g_imported["bytes"] = true
+		c.printf("if !bytes.Equal(%s, %s) {\n", ct.toWire(a), ct.toWire(b))
+	} else {
+		c.printf("if %s != %s {\n", ct.toWire(a), ct.toWire(b))
+	}
+	c.change(a, b, path)
+	c.printf("}\n")
+}
+
+func (c *diffWriter) diffValue(a, b, path, goType string, t caps.Type) {
+	switch {
+	case goType == "interface{}":
+		c.printf("if !caps.EqualValue(%s, %s) {\n", a, b)
+		c.change(a, b, path)
+		c.printf("}\n")
+
+	case t.Which() == caps.TYPE_STRUCT:
+		c.printf("changes = %s.AppendDiff(changes, %s+\".\", &%s)\n", a, path, b)
+
+	case strings.HasPrefix(goType, "*"):
+		// Unset fields are reported with nil value
+		va, vb := c.newVar("va"), c.newVar("vb")
+		c.printf("if (%s == nil) != (%s == nil) || %s != nil && *%s != *%s {\n", a, b, a, a, b)
+		c.printf("var %s, %s interface{}\n", va, vb)
+		c.printf("if %s != nil { %s = *%s }\n", a, va, a)
+		c.printf("if %s != nil { %s = *%s }\n", b, vb, b)
+		c.change(va, vb, path)
+		c.printf("}\n")
+
+	case goType == "[]byte" || goType == "[]uint8":
+		g_imported["bytes"] = true
+		c.printf("if !bytes.Equal(%s, %s) {\n", a, b)
+		c.change(a, b, path)
+		c.printf("}\n")
+
+	case goType == "[]struct{}":
+		c.printf("if len(%s) != len(%s) {\n", a, b)
+		c.change(a, b, path)
+		c.printf("}\n")
+
+	case strings.HasPrefix(goType, "[]"):
+		// Elements are compared pairwise, extra ones are added or removed
+		g_imported["strconv"] = true
+		i := c.newVar("i")
+		epath := fmt.Sprintf("%s+\"[\"+strconv.Itoa(%s)+\"]\"", path, i)
+		ea, eb := fmt.Sprintf("%s[%s]", a, i), fmt.Sprintf("%s[%s]", b, i)
+
+		c.printf("for %s := 0; %s < len(%s) || %s < len(%s); %s++ {\n", i, i, a, i, b, i)
+		c.printf("switch {\n")
+		c.printf("case %s >= len(%s):\n", i, b)
+		c.change(ea, "nil", epath)
+		c.printf("case %s >= len(%s):\n", i, a)
+		c.change("nil", eb, epath)
+		c.printf("default:\n")
+		if t.Which() == caps.TYPE_LIST {
+			c.diffValue(ea, eb, epath, goType[2:], t.List().ElementType())
+		} else {
+			c.printf("if %s != %s {\n", ea, eb)
+			c.change(ea, eb, epath)
+			c.printf("}\n")
+		}
+		c.printf("}\n")
+		c.printf("}\n")
+
+	default:
+		c.printf("if %s != %s {\n", a, b)
+		c.change(a, b, path)
+		c.printf("}\n")
+	}
+}
+
+func (n *node) defineDiffMethods(w io.Writer) {
+	g_imported[SELF_IMPORT] = true
+	c := &diffWriter{codeWriter: codeWriter{w: w}}
+
+	c.printf("// Diff returns changes of fields from z to o. Paths follow schema field names.\n")
+	c.printf("func (z *%s) Diff(o *%s) []caps.FieldChange {\n", n.name, n.name)
+	c.printf("return z.AppendDiff(nil, \"\", o)\n")
+	c.printf("}\n\n")
+
+	c.printf("// AppendDiff appends changes of fields from z to o with paths prefixed by path.\n")
+	c.printf("func (z *%s) AppendDiff(changes []caps.FieldChange, path string, o *%s) []caps.FieldChange {\n", n.name, n.name)
+	c.printf("if z == nil { z = &%s{} }\n", n.name)
+	c.printf("if o == nil { o = &%s{} }\n", n.name)
+	c.diffFields(n, "z", "o", "path")
+	c.printf("return changes\n")
+	c.printf("}\n\n")
+}
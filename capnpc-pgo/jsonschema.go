@@ -0,0 +1,569 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+const JSON_SCHEMA_DIALECT = "https://json-schema.org/draft/2020-12/schema"
+
+type jsonSchema struct {
+	Schema           string                 `json:"$schema,omitempty"`
+	ID               string                 `json:"$id,omitempty"`
+	Ref              string                 `json:"$ref,omitempty"`
+	Title            string                 `json:"title,omitempty"`
+	Description      string                 `json:"description,omitempty"`
+	Type             string                 `json:"type,omitempty"`
+	Nullable         bool                   `json:"-"` // Type or null
+	Format           string                 `json:"format,omitempty"`
+	ContentEncoding  string                 `json:"contentEncoding,omitempty"`
+	Pattern          string                 `json:"pattern,omitempty"`
+	Enum             []interface{}          `json:"enum,omitempty"`
+	MinLength        *uint64                `json:"minLength,omitempty"`
+	MaxLength        *uint64                `json:"maxLength,omitempty"`
+	Minimum          json.Number            `json:"minimum,omitempty"`
+	Maximum          json.Number            `json:"maximum,omitempty"`
+	ExclusiveMinimum json.Number            `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum json.Number            `json:"exclusiveMaximum,omitempty"`
+	Items            *jsonSchema            `json:"items,omitempty"`
+	MinItems         *uint64                `json:"minItems,omitempty"`
+	MaxItems         *uint64                `json:"maxItems,omitempty"`
+	Properties       map[string]*jsonSchema `json:"properties,omitempty"`
+	Required         []string               `json:"required,omitempty"`
+	OneOf            []*jsonSchema          `json:"oneOf,omitempty"`
+	AnyOf            []*jsonSchema          `json:"anyOf,omitempty"`
+	Not              *jsonSchema            `json:"not,omitempty"`
+	Defs             map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+func (s *jsonSchema) MarshalJSON() ([]byte, error) {
+	type plain jsonSchema
+	v := struct {
+		*plain
+		Type interface{} `json:"type,omitempty"`
+	}{plain: (*plain)(s)}
+	if s.Nullable {
+		v.Type = []string{s.Type, "null"}
+	} else if s.Type != "" {
+		v.Type = s.Type
+	}
+	return json.Marshal(v)
+}
+
+// schemaBuilder converts schema nodes of file to JSON Schema. Named types
+// are collected in defs and referenced through refBase.
+type schemaBuilder struct {
+	refBase string
+	file    *node
+	defs    map[string]*jsonSchema
+}
+
+func newSchemaBuilder(refBase string, file *node) *schemaBuilder {
+	return &schemaBuilder{refBase: refBase, file: file, defs: make(map[string]*jsonSchema)}
+}
+
+// defName returns name of the type in defs. Types of other files are
+// prefixed with their package.
+func (b *schemaBuilder) defName(n *node) string {
+	if f := fileOf(n); f != nil && f != b.file {
+		return f.pkg + "." + n.name
+	}
+	return n.name
+}
+
+func (b *schemaBuilder) ref(n *node) *jsonSchema {
+	name := b.defName(n)
+	if _, found := b.defs[name]; !found {
+		// Reserve the name first, recursive types refer to themselves.
+		b.defs[name] = nil
+
+		switch n.Which() {
+		case caps.NODE_ENUM:
+			b.defs[name] = b.enumSchema(n)
+		case caps.NODE_STRUCT:
+			b.defs[name] = b.structSchema(n)
+		}
+	}
+
+	return &jsonSchema{Ref: b.refBase + name}
+}
+
+// jsonDescribed reports whether JSON of the types of n is described by JSON
+// Schema, OpenAPI or TypeScript. Such enums are encoded by their names and
+// structs write only the member of union that is set, so the JSON has a
+// shape the descriptions can state.
+func (n *node) jsonDescribed() bool {
+	for _, c := range []uint64{caps.CodecJsonSchema, caps.CodecOpenApi, caps.CodecTypescript} {
+		if _, found := n.codecs[c]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// defineEnumJSON makes jsonDescribed enum encode as its tag in JSON.
+// Values without tags are written as numbers.
+func (n *node) defineEnumJSON(w io.Writer) {
+	g_imported["encoding/json"] = true
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "func (c %s) MarshalJSON() ([]byte, error) {\n", n.name)
+	fmt.Fprintf(w, "if s := c.String(); s != \"\" { return json.Marshal(s) }\n")
+	fmt.Fprintf(w, "return json.Marshal(uint16(c))\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "func (c *%s) UnmarshalJSON(data []byte) error {\n", n.name)
+	fmt.Fprintf(w, "var s string\n")
+	fmt.Fprintf(w, "if err := json.Unmarshal(data, &s); err == nil { return c.setString(s) }\n")
+	fmt.Fprintf(w, "var v uint16\n")
+	fmt.Fprintf(w, "if err := json.Unmarshal(data, &v); err != nil { return err }\n")
+	fmt.Fprintf(w, "*c = %s(v)\n", n.name)
+	fmt.Fprintf(w, "return nil\n")
+	fmt.Fprintf(w, "}\n")
+}
+
+// defineUnionJSON writes MarshalJSON of jsonDescribed struct with union. It
+// writes only the member of the union that is set.
+func (n *node) defineUnionJSON(w io.Writer) {
+	type member struct {
+		goName, jsonName, cond string
+	}
+
+	var members []member
+	for _, f := range n.unionMembers() {
+		if !hasGoField(f) {
+			members = append(members, member{})
+			continue
+		}
+		name := n.jsonName(f)
+		if name == "" {
+			continue
+		}
+
+		m := member{goName: goFieldName(f), jsonName: name}
+		expr := "z." + m.goName
+		if n.hasPresence(f) {
+			m.cond = expr + " != nil"
+		} else if ct := fieldCustomType(n, f); ct != nil {
+			m.cond = nonZeroCond(ct.toWire(expr), f)
+		} else {
+			m.cond = nonZeroCond(expr, f)
+		}
+		members = append(members, m)
+	}
+
+	g_imported["encoding/json"] = true
+
+	fmt.Fprintf(w, "func (z %s) MarshalJSON() ([]byte, error) {\n", n.name)
+	fmt.Fprintf(w, "type plain %s\n", n.name)
+	fmt.Fprintf(w, "v := struct {\n")
+	fmt.Fprintf(w, "plain\n")
+	for _, m := range members {
+		if m.goName != "" {
+			fmt.Fprintf(w, "%s interface{} `json:\"%s,omitempty\"`\n", m.goName, m.jsonName)
+		}
+	}
+	fmt.Fprintf(w, "}{plain: plain(z)}\n")
+	fmt.Fprintf(w, "switch {\n")
+	for _, m := range members {
+		if m.goName != "" {
+			fmt.Fprintf(w, "case %s: v.%s = z.%s\n", m.cond, m.goName, m.goName)
+		}
+	}
+	if len(members) > 0 && members[0].goName != "" {
+		fmt.Fprintf(w, "default: v.%s = z.%s\n", members[0].goName, members[0].goName)
+	}
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "return json.Marshal(v)\n")
+	fmt.Fprintf(w, "}\n\n")
+}
+
+// describedUnion reports whether n is jsonDescribed struct with union.
+// Groups hold every union member.
+func (n *node) describedUnion() bool {
+	return n.Struct().DiscriminantCount() > 0 && !n.Struct().IsGroup() && n.jsonDescribed()
+}
+
+// enumValues returns values encoding/json writes for enumerants of n:
+// tags, numbers for enumerants without a tag, or only numbers if enum is
+// not jsonDescribed.
+func (n *node) enumValues() []interface{} {
+	var values []interface{}
+	for i, e := range n.Enum().Enumerants().ToArray() {
+		if t := enumTag(e); t != "" && n.jsonDescribed() {
+			values = append(values, t)
+		} else {
+			values = append(values, i)
+		}
+	}
+	return values
+}
+
+// enumSchema describes enum the way encoding/json writes it.
+func (b *schemaBuilder) enumSchema(n *node) *jsonSchema {
+	s := &jsonSchema{Description: docAnnotation(n.Annotations()), Enum: n.enumValues()}
+
+	var ints, names bool
+	for _, v := range s.Enum {
+		switch v.(type) {
+		case int:
+			ints = true
+		case string:
+			names = true
+		}
+	}
+
+	// enum alone constrains mixed names and numbers
+	switch {
+	case !names:
+		s.Type = "integer"
+	case !ints:
+		s.Type = "string"
+	}
+	return s
+}
+
+// jsonName returns the key encoding/json uses for the field of generated
+// struct n, or "" if the field is not serialized.
+func (n *node) jsonName(f caps.Field) string {
+	if _, found := n.codecs[caps.CodecJson]; !found {
+		if an := nameAnnotation(f.Annotations()); an != "" {
+			return strings.Title(an)
+		}
+		return strings.Title(f.Name())
+	}
+
+	if fc := codecField(f); !fc.Ignored {
+		return fc.Name
+	}
+	return ""
+}
+
+// schemaFields returns the fields of the struct that are present in the
+// generated Go code, in code order.
+func (n *node) schemaFields() []caps.Field {
+	var fields []caps.Field
+
+	for _, f := range n.codeOrderFields() {
+		if hasGoField(f) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func (b *schemaBuilder) structSchema(n *node) *jsonSchema {
+	s := &jsonSchema{
+		Type:        "object",
+		Description: docAnnotation(n.Annotations()),
+		Properties:  make(map[string]*jsonSchema),
+	}
+
+	for _, f := range n.schemaFields() {
+		name := n.jsonName(f)
+		if name == "" {
+			continue
+		}
+
+		var fs *jsonSchema
+		if f.Which() == caps.FIELD_GROUP {
+			fs = b.structSchema(findNode(f.Group().TypeId()))
+		} else if fs = semanticSchema(f); fs == nil {
+			fs = b.typeSchema(f.Slot().Type())
+		}
+
+		if doc := docAnnotation(f.Annotations()); doc != "" {
+			if fs.Ref != "" {
+				// Siblings of $ref are allowed since draft 2019-09.
+				fs = &jsonSchema{Ref: fs.Ref}
+			}
+			fs.Description = doc
+		}
+
+		required := codecField(f).Required
+		for _, a := range f.Annotations().ToArray() {
+			if a.Id() == caps.CheckValue && f.Which() == caps.FIELD_SLOT {
+				if applyChecks(fs, f.Slot().Type(), a.Value().Text()) {
+					required = true
+				}
+			}
+		}
+
+		s.Properties[name] = fs
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	s.OneOf = unionSchemas(n)
+	return s
+}
+
+// unionSchemas returns alternatives of union members of n, each of them
+// requiring the member to be present. Void members have no Go field, so
+// they share an alternative without any member. Go structs hold every
+// member, so only jsonDescribed structs write just one of them.
+func unionSchemas(n *node) []*jsonSchema {
+	if !n.describedUnion() {
+		return nil
+	}
+
+	var alts []*jsonSchema
+	var present []*jsonSchema
+	void := false
+	for _, f := range n.unionMembers() {
+		if !hasGoField(f) {
+			void = true
+		} else if name := n.jsonName(f); name != "" {
+			alts = append(alts, &jsonSchema{Required: []string{name}})
+			present = append(present, &jsonSchema{Required: []string{name}})
+		}
+	}
+	if void && len(present) > 0 {
+		alts = append(alts, &jsonSchema{Not: &jsonSchema{AnyOf: present}})
+	}
+	if len(alts) < 2 {
+		return nil
+	}
+	return alts
+}
+
+func (b *schemaBuilder) typeSchema(t caps.Type) *jsonSchema {
+	switch t.Which() {
+	case caps.TYPE_BOOL:
+		return &jsonSchema{Type: "boolean"}
+
+	case caps.TYPE_INT8:
+		return intSchema("-128", "127")
+	case caps.TYPE_INT16:
+		return intSchema("-32768", "32767")
+	case caps.TYPE_INT32:
+		return intSchema("-2147483648", "2147483647")
+	case caps.TYPE_INT64:
+		return intSchema("-9223372036854775808", "9223372036854775807")
+	case caps.TYPE_UINT8:
+		return intSchema("0", "255")
+	case caps.TYPE_UINT16:
+		return intSchema("0", "65535")
+	case caps.TYPE_UINT32:
+		return intSchema("0", "4294967295")
+	case caps.TYPE_UINT64:
+		return intSchema("0", "18446744073709551615")
+
+	case caps.TYPE_FLOAT32, caps.TYPE_FLOAT64:
+		return &jsonSchema{Type: "number"}
+
+	case caps.TYPE_TEXT:
+		return &jsonSchema{Type: "string"}
+
+	case caps.TYPE_DATA:
+		// nil slices are written as null
+		return &jsonSchema{Type: "string", Nullable: true, ContentEncoding: "base64"}
+
+	case caps.TYPE_ENUM:
+		return b.ref(findNode(t.Enum().TypeId()))
+
+	case caps.TYPE_STRUCT:
+		return b.ref(findNode(t.Struct().TypeId()))
+
+	case caps.TYPE_LIST:
+		et := t.List().ElementType()
+		if et.Which() == caps.TYPE_UINT8 {
+			// []uint8 is marshalled by encoding/json the same way as []byte
+			return &jsonSchema{Type: "string", Nullable: true, ContentEncoding: "base64"}
+		}
+		return &jsonSchema{Type: "array", Nullable: true, Items: b.typeSchema(et)}
+	}
+
+	// AnyPointer, Void and Interface may hold any value
+	return &jsonSchema{}
+}
+
+// semanticSchema returns schema of $Type fields that Go types encode as
+// text or nil
+func semanticSchema(f caps.Field) *jsonSchema {
+	switch {
+	case caps.HasAnnotation(f.Annotations(), caps.TypeTimestamp):
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case caps.HasAnnotation(f.Annotations(), caps.TypeUuid):
+		return &jsonSchema{Type: "string", Format: "uuid"}
+	case caps.HasAnnotation(f.Annotations(), caps.TypeIp):
+		return &jsonSchema{Type: "string", OneOf: []*jsonSchema{{Format: "ipv4"}, {Format: "ipv6"}}}
+	}
+	return nil
+}
+
+func intSchema(min, max string) *jsonSchema {
+	return &jsonSchema{Type: "integer", Minimum: json.Number(min), Maximum: json.Number(max)}
+}
+
+var checkPatterns = map[string]string{
+	"alpha":        "^[a-zA-Z]+$",
+	"alphanum":     "^[a-zA-Z0-9]+$",
+	"alphaunicode": "^[\\p{L}]+$",
+	"numeric":      "^[-+]?[0-9]+(?:\\.[0-9]+)?$",
+	"number":       "^[0-9]+$",
+	"hexadecimal":  "^(0[xX])?[0-9a-fA-F]+$",
+	"hexcolor":     "^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$",
+	"lowercase":    "^[^A-Z]*$",
+	"uppercase":    "^[^a-z]*$",
+	"ascii":        "^[\\x00-\\x7F]*$",
+}
+
+var checkFormats = map[string]string{
+	"email":    "email",
+	"url":      "uri",
+	"uri":      "uri",
+	"uuid":     "uuid",
+	"uuid3":    "uuid",
+	"uuid4":    "uuid",
+	"uuid5":    "uuid",
+	"ipv4":     "ipv4",
+	"ipv6":     "ipv6",
+	"hostname": "hostname",
+	"datetime": "date-time",
+}
+
+// applyChecks translates a go-playground/validator expression to JSON Schema
+// keywords of s. Rules without a JSON Schema counterpart are skipped.
+// It reports whether the expression makes the field required.
+func applyChecks(s *jsonSchema, t caps.Type, exp string) (required bool) {
+	target := s
+
+	for _, rule := range strings.Split(exp, ",") {
+		if strings.Contains(rule, "|") {
+			continue
+		}
+
+		key, param := rule, ""
+		if i := strings.Index(rule, "="); i != -1 {
+			key, param = rule[:i], rule[i+1:]
+		}
+
+		if key == "required" {
+			required = true
+			continue
+		}
+
+		if target.Ref != "" {
+			// Constraints don't apply to named types
+			continue
+		}
+
+		switch key {
+		case "dive":
+			if target.Items == nil {
+				return
+			}
+			target = target.Items
+			t = t.List().ElementType()
+
+		case "min", "max", "len", "gt", "gte", "lt", "lte":
+			applyBound(target, key, param)
+
+		case "oneof":
+			for _, v := range strings.Fields(param) {
+				if target.Type == "string" {
+					target.Enum = append(target.Enum, v)
+				} else {
+					target.Enum = append(target.Enum, json.Number(v))
+				}
+			}
+
+		default:
+			if target.Type != "string" {
+				continue
+			}
+			if f, found := checkFormats[key]; found {
+				target.Format = f
+			} else if p, found := checkPatterns[key]; found {
+				target.Pattern = p
+			}
+		}
+	}
+	return
+}
+
+func applyBound(s *jsonSchema, key, param string) {
+	switch s.Type {
+	case "integer", "number":
+		v := json.Number(param)
+		switch key {
+		case "min", "gte":
+			s.Minimum = v
+		case "max", "lte":
+			s.Maximum = v
+		case "len":
+			s.Minimum, s.Maximum = v, v
+		case "gt":
+			s.Minimum, s.ExclusiveMinimum = "", v
+		case "lt":
+			s.Maximum, s.ExclusiveMaximum = "", v
+		}
+
+	case "string", "array":
+		v, err := strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			return
+		}
+
+		min, max := &s.MinLength, &s.MaxLength
+		if s.Type == "array" {
+			min, max = &s.MinItems, &s.MaxItems
+		}
+
+		switch key {
+		case "min", "gte":
+			*min = &v
+		case "max", "lte":
+			*max = &v
+		case "len":
+			*min, *max = &v, &v
+		case "gt":
+			v++
+			*min = &v
+		case "lt":
+			if v > 0 {
+				v--
+			}
+			*max = &v
+		}
+	}
+}
+
+// writeJSONSchemas writes a self-contained JSON Schema document for every
+// struct of the file into dir.
+func writeJSONSchemas(dir string, file *node) {
+	for _, n := range file.nodes {
+		if n.Which() != caps.NODE_STRUCT || n.Struct().IsGroup() {
+			continue
+		}
+
+		b := newSchemaBuilder("#/$defs/", file)
+		s := b.structSchema(n)
+		s.Schema = JSON_SCHEMA_DIALECT
+		s.ID = n.name + ".schema.json"
+		s.Title = n.name
+
+		// The struct itself is only referenced by recursive fields
+		if d, found := b.defs[n.name]; found && d != nil {
+			b.defs[n.name] = &jsonSchema{Ref: "#"}
+		}
+		if len(b.defs) > 0 {
+			s.Defs = b.defs
+		}
+
+		data, err := json.MarshalIndent(s, "", "  ")
+		assert(err == nil, "%v\n", err)
+
+		err = ioutil.WriteFile(filepath.Join(dir, s.ID), append(data, '\n'), 0644)
+		assert(err == nil, "%v\n", err)
+	}
+}
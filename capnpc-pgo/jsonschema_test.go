@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+const (
+	shapeFileID = 0xe1a5c0ffee000400 + iota
+	shapeID
+	colorID
+	pointFileID
+	pointID
+)
+
+// shapeFile holds:
+//
+//	struct Shape {
+//	  color  @0 :Color;
+//	  image  @1 :Data;
+//	  tags   @2 :List(Text);
+//	  union { none @3 :Void; circle @4 :Int64; square @5 :Text; }
+//	  origin @6 :Point.Point;
+//	}
+//	enum Color { red @0 $Go.tag("rot"); green @1; blue @2 $Go.notag; }
+var shapeFile = testFile{id: shapeFileID, name: "shape.capnp",
+	anns: []testAnnotation{goPackage("gentest"), {id: caps.CodecJson}, {id: caps.CodecJsonSchema}},
+	nodes: []testNode{
+		{id: shapeID, name: "Shape", data: 2, ptrs: 4, discCount: 3, discOffset: 1, fields: []testField{
+			{name: "color", typ: enumTypeOf(colorID), disc: -1},
+			{name: "image", typ: dataType, disc: -1},
+			{name: "tags", typ: listTypeOf(textType), offset: 1, disc: -1},
+			{name: "none", typ: voidType, disc: 0},
+			{name: "circle", typ: int64Type, offset: 1, disc: 1},
+			{name: "square", typ: textType, offset: 2, disc: 2},
+			{name: "origin", typ: structTypeOf(pointID), offset: 3, disc: -1},
+		}},
+		{id: colorID, name: "Color", enumerants: []string{"red", "green", "blue"},
+			enumAnns: [][]testAnnotation{{{id: C.Tag, text: "rot"}}, nil, {{id: C.Notag}}}},
+	},
+}
+
+// pointFile holds:
+//
+//	struct Point { x @0 :Int32; }
+var pointFile = testFile{id: pointFileID, name: "point.capnp", anns: []testAnnotation{goPackage("gentest")},
+	nodes: []testNode{
+		{id: pointID, name: "Point", data: 1, fields: []testField{
+			{name: "x", typ: int32Type, disc: -1},
+		}},
+	},
+}
+
+func TestJSONSchema(t *testing.T) {
+	var s map[string]interface{}
+	if err := json.Unmarshal([]byte(readOutput(t, generate(t, nil, shapeFile, pointFile), "Shape.schema.json")), &s); err != nil {
+		t.Fatal(err)
+	}
+	props := s["properties"].(map[string]interface{})
+	defs := s["$defs"].(map[string]interface{})
+
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"enum", defs["Color"], map[string]interface{}{"enum": []interface{}{"rot", "green", 2.0}}},
+		{"data", props["image"], map[string]interface{}{"type": []interface{}{"string", "null"}, "contentEncoding": "base64"}},
+		{"list", props["tags"].(map[string]interface{})["type"], []interface{}{"array", "null"}},
+		{"imported", props["origin"], map[string]interface{}{"$ref": "#/$defs/gentest.Point"}},
+		{"union", s["oneOf"], []interface{}{
+			map[string]interface{}{"required": []interface{}{"circle"}},
+			map[string]interface{}{"required": []interface{}{"square"}},
+			map[string]interface{}{"not": map[string]interface{}{"anyOf": []interface{}{
+				map[string]interface{}{"required": []interface{}{"circle"}},
+				map[string]interface{}{"required": []interface{}{"square"}},
+			}}},
+		}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if _, found := defs["gentest.Point"]; !found {
+		t.Errorf("no gentest.Point in %v", defs)
+	}
+}
+
+const jsonTest = `package gentest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		in   Shape
+		want string
+	}{
+		{Shape{}, "{\"color\":\"rot\",\"image\":null,\"tags\":null,\"origin\":{\"X\":0}}"},
+		{Shape{Color: COLOR_BLUE, Circle: 0, Image: []byte{1}}, "{\"color\":2,\"image\":\"AQ==\",\"tags\":null,\"origin\":{\"X\":0}}"},
+		{Shape{Color: COLOR_GREEN, Circle: 5}, "{\"color\":\"green\",\"image\":null,\"tags\":null,\"origin\":{\"X\":0},\"circle\":5}"},
+		{Shape{Square: "x", Tags: []string{}}, "{\"color\":\"rot\",\"image\":null,\"tags\":[],\"origin\":{\"X\":0},\"square\":\"x\"}"},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%+v is written as %s, want %s", tt.in, b, tt.want)
+		}
+		var out Shape
+		if err := json.Unmarshal(b, &out); err != nil || !reflect.DeepEqual(out, tt.in) {
+			t.Errorf("%s is read as %+v: %v", b, out, err)
+		}
+	}
+
+	for in, want := range map[string]Color{"\"green\"": COLOR_GREEN, "1": COLOR_GREEN, "2": COLOR_BLUE, "\"2\"": COLOR_BLUE} {
+		var c Color
+		if err := json.Unmarshal([]byte(in), &c); err != nil || c != want {
+			t.Errorf("%s is read as %d: %v", in, c, err)
+		}
+	}
+	var c Color
+	if err := json.Unmarshal([]byte("\"blue\""), &c); err == nil {
+		t.Errorf("enumerant without tag is read by name as %d", c)
+	}
+}
+`
+
+func TestJSONSchemaEncoding(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated package")
+	}
+	goTest(t, generate(t, nil, shapeFile, pointFile), jsonTest)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+// maskPath describes field path of the mask. Paths of groups are followed
+// by paths of their members.
+type maskPath struct {
+	path   string
+	name   string // Suffix of the constant name
+	expr   string // Go expression of the field relative to the struct
+	group  bool
+	goType string // Type name of struct field, otherwise ""
+}
+
+func maskPaths(n *node, path, name, expr string, l []maskPath) []maskPath {
+	for _, f := range n.codeOrderFields() {
+		fc := codecField(f)
+		if fc.Ignored {
+			continue
+		}
+
+		p := maskPath{
+			path: path + fc.Name,
+			name: name + strings.ToUpper(goFieldName(f)),
+			expr: expr + "." + goFieldName(f),
+		}
+
+		if f.Which() == caps.FIELD_GROUP {
+			p.group = true
+			l = append(l, p)
+			l = maskPaths(findNode(f.Group().TypeId()), p.path+".", p.name+"_", p.expr, l)
+			continue
+		}
+
+		t := f.Slot().Type()
+		switch t.Which() {
+		case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+			continue
+		case caps.TYPE_STRUCT:
+			p.goType = GoTypeName(n, f.Slot(), "")
+		}
+		l = append(l, p)
+	}
+	return l
+}
+
+func (n *node) defineMaskMethods(w io.Writer) {
+	g_imported[SELF_IMPORT] = true
+	paths := maskPaths(n, "", "", "", nil)
+
+	if len(paths) > 0 {
+		fmt.Fprintf(w, "// Field paths of %s for field masks\n", n.name)
+		fmt.Fprintf(w, "const (\n")
+		for _, p := range paths {
+			fmt.Fprintf(w, "%s_FIELD_%s = %q\n", strings.ToUpper(n.name), p.name, p.path)
+		}
+		fmt.Fprintf(w, ")\n\n")
+	}
+
+	fmt.Fprintf(w, "// ValidFieldPath reports whether path is a field path of %s\n", n.name)
+	fmt.Fprintf(w, "func (%s) ValidFieldPath(path string) bool {\n", n.name)
+	if len(paths) > 0 {
+		fmt.Fprintf(w, "switch path {\n")
+		var cases []string
+		for _, p := range paths {
+			cases = append(cases, fmt.Sprintf("%q", p.path))
+		}
+		fmt.Fprintf(w, "case %s:\n", strings.Join(cases, ", "))
+		fmt.Fprintf(w, "return true\n")
+		fmt.Fprintf(w, "}\n")
+	}
+	for _, p := range paths {
+		if p.goType != "" {
+			g_imported["strings"] = true
+			fmt.Fprintf(w, "if strings.HasPrefix(path, %q) {\n", p.path+".")
+			fmt.Fprintf(w, "return %s{}.ValidFieldPath(path[%d:])\n", p.goType, len(p.path)+1)
+			fmt.Fprintf(w, "}\n")
+		}
+	}
+	fmt.Fprintf(w, "return false\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "// New%sFieldMask returns mask of the paths or error if some path is not a field of %s\n", n.name, n.name)
+	fmt.Fprintf(w, "func New%sFieldMask(paths ...string) (caps.FieldMask, error) {\n", n.name)
+	fmt.Fprintf(w, "return caps.NewFieldMask(%s{}.ValidFieldPath, paths...)\n", n.name)
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "// MergeFrom sets fields of the mask to values of src. Lists and Data are shared with src.\n")
+	fmt.Fprintf(w, "func (z *%s) MergeFrom(src *%s, mask caps.FieldMask) {\n", n.name, n.name)
+	for _, p := range paths {
+		// Members of groups are covered by group paths
+		if p.group {
+			continue
+		}
+		fmt.Fprintf(w, "if mask.Has(%q) {\n", p.path)
+		fmt.Fprintf(w, "z%s = src%s\n", p.expr, p.expr)
+		if p.goType != "" {
+			fmt.Fprintf(w, "} else if sub := mask.Sub(%q); !sub.Empty() {\n", p.path)
+			fmt.Fprintf(w, "z%s.MergeFrom(&src%s, sub)\n", p.expr, p.expr)
+		}
+		fmt.Fprintf(w, "}\n")
+	}
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "// Project returns %s with only fields of the mask set\n", n.name)
+	fmt.Fprintf(w, "func (z *%s) Project(mask caps.FieldMask) %s {\n", n.name, n.name)
+	fmt.Fprintf(w, "var c %s\n", n.name)
+	fmt.Fprintf(w, "c.MergeFrom(z, mask)\n")
+	fmt.Fprintf(w, "return c\n")
+	fmt.Fprintf(w, "}\n\n")
+}
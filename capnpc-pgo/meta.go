@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+// defineTypeMeta writes methods linking generated type back to its schema
+// node.
+func (n *node) defineTypeMeta(w io.Writer) {
+	fmt.Fprintf(w, "// CapnpTypeID returns ID of the schema node of %s.\n", n.name)
+	fmt.Fprintf(w, "func (%s) CapnpTypeID() uint64 { return 0x%x }\n\n", n.name, n.Id())
+
+	fmt.Fprintf(w, "// CapnpSchemaName returns display name of the schema node of %s.\n", n.name)
+	fmt.Fprintf(w, "func (%s) CapnpSchemaName() string { return %q }\n\n", n.name, n.DisplayName())
+}
+
+// fieldOrdinals appends ordinals of the struct fields by Go field names.
+// Members of groups are qualified with the group name.
+func fieldOrdinals(n *node, prefix string, l []string) []string {
+	for _, f := range n.codeOrderFields() {
+		name := prefix + goFieldName(f)
+
+		if f.Which() == caps.FIELD_GROUP {
+			l = fieldOrdinals(findNode(f.Group().TypeId()), name+".", l)
+			continue
+		}
+
+		// Only these fields are declared in Go struct
+		switch f.Slot().Type().Which() {
+		case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+			continue
+		}
+		l = append(l, fmt.Sprintf("%q: %d,", name, f.Ordinal().Explicit()))
+	}
+	return l
+}
+
+// defineStructMeta writes type metadata methods and table of field ordinals
+func (n *node) defineStructMeta(w io.Writer) {
+	n.defineTypeMeta(w)
+
+	table := fmt.Sprintf("%s%sCapnpOrdinals", strings.ToLower(n.name[:1]), n.name[1:])
+
+	fmt.Fprintf(w, "var %s = map[string]uint16{\n", table)
+	for _, e := range fieldOrdinals(n, "", nil) {
+		fmt.Fprintf(w, "%s\n", e)
+	}
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "// CapnpFieldOrdinals returns schema ordinals of %s fields by Go field names.\n", n.name)
+	fmt.Fprintf(w, "// Fields of groups are qualified with group field name. The map must not be modified.\n")
+	fmt.Fprintf(w, "func (%s) CapnpFieldOrdinals() map[string]uint16 { return %s }\n\n", n.name, table)
+}
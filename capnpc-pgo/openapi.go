@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+const OPENAPI_VERSION = "3.1.0"
+
+type openAPI struct {
+	OpenAPI    string                  `json:"openapi"`
+	Info       openAPIInfo             `json:"info"`
+	Paths      map[string]*openAPIPath `json:"paths"`
+	Components openAPIComponents       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*jsonSchema `json:"schemas,omitempty"`
+}
+
+type openAPIPath struct {
+	Post *openAPIOperation `json:"post,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	RequestBody *openAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIBody struct {
+	Required bool                     `json:"required"`
+	Content  map[string]*openAPIMedia `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                   `json:"description"`
+	Content     map[string]*openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPIMedia struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+func jsonContent(s *jsonSchema) map[string]*openAPIMedia {
+	return map[string]*openAPIMedia{"application/json": {Schema: s}}
+}
+
+// methodStruct returns param or result struct of the method. Structs
+// implicitly declared by a method parameter list have no name of their own
+// and are named after the interface and the method.
+func methodStruct(iface *node, m caps.Method, id uint64, suffix string) *node {
+	n := findNode(id)
+	if n.name == "" {
+		n.name = iface.name + strings.Title(m.Name()) + suffix
+		n.pkg, n.imp = iface.pkg, iface.imp
+		n.codecs = iface.codecs
+	}
+	return n
+}
+
+// writeOpenAPI writes OpenAPI document for the file. Structs are defined in
+// components/schemas and every interface method becomes a POST operation
+// at /<Interface>/<method>.
+func writeOpenAPI(filename string, file *node) {
+	b := newSchemaBuilder("#/components/schemas/", file)
+
+	doc := &openAPI{
+		OpenAPI: OPENAPI_VERSION,
+		Info:    openAPIInfo{Title: file.DisplayName(), Version: "1.0.0"},
+		Paths:   make(map[string]*openAPIPath),
+	}
+
+	operations := make(map[string]bool)
+
+	for _, n := range file.nodes {
+		switch n.Which() {
+		case caps.NODE_STRUCT, caps.NODE_ENUM:
+			b.ref(n)
+
+		case caps.NODE_INTERFACE:
+			for _, m := range n.Interface().Methods().ToArray() {
+				params := methodStruct(n, m, m.ParamStructType(), "Params")
+				results := methodStruct(n, m, m.ResultStructType(), "Results")
+
+				id := m.Name()
+				if operations[id] {
+					id = n.name + "." + m.Name()
+				}
+				operations[id] = true
+
+				op := &openAPIOperation{
+					OperationID: id,
+					Description: docAnnotation(m.Annotations()),
+					Tags:        []string{n.name},
+					RequestBody: &openAPIBody{Required: true, Content: jsonContent(b.ref(params))},
+					Responses: map[string]*openAPIResponse{
+						"200": {Description: "Successful response", Content: jsonContent(b.ref(results))},
+					},
+				}
+
+				doc.Paths["/"+n.name+"/"+m.Name()] = &openAPIPath{Post: op}
+			}
+		}
+	}
+
+	doc.Components.Schemas = b.defs
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	assert(err == nil, "%v\n", err)
+
+	err = ioutil.WriteFile(filename, append(data, '\n'), 0644)
+	assert(err == nil, "%v\n", err)
+}
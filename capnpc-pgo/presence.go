@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/tpukep/caps"
+)
+
+// presenceScalar reports whether fields of type t can track presence
+func presenceScalar(t caps.Type) bool {
+	switch t.Which() {
+	case caps.TYPE_BOOL, caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_INT32, caps.TYPE_INT64,
+		caps.TYPE_UINT8, caps.TYPE_UINT16, caps.TYPE_UINT32, caps.TYPE_UINT64,
+		caps.TYPE_FLOAT32, caps.TYPE_FLOAT64:
+		return true
+	}
+	return false
+}
+
+// hasPresence reports whether the field is generated as pointer, so unset
+// field can be told apart from zero value. $Field.presence enables it for
+// optional scalar fields of the file or for single field.
+func (n *node) hasPresence(f caps.Field) bool {
+	if f.Which() != caps.FIELD_SLOT {
+		return false
+	}
+
+	onField := caps.HasAnnotation(f.Annotations(), caps.FieldPresence)
+	if _, found := findNode(fileId(n.Node)).codecs[caps.FieldPresence]; !found && !onField {
+		return false
+	}
+
+	ok := presenceScalar(f.Slot().Type()) && codecField(f).Optional
+	assert(ok || !onField, "%s.%s: $Field.presence applies to optional Bool, integer and float fields", n.DisplayName(), f.Name())
+	return ok
+}
+
+// presenceDefault returns Go literal of the capnp default of the field.
+// Translators map nil pointers to and from this value.
+func presenceDefault(s caps.FieldSlot) string {
+	def := s.DefaultValue()
+
+	var lit string
+	switch def.Which() {
+	case caps.VALUE_BOOL:
+		lit = strconv.FormatBool(def.Bool())
+	case caps.VALUE_INT8:
+		lit = strconv.Itoa(int(def.Int8()))
+	case caps.VALUE_INT16:
+		lit = strconv.Itoa(int(def.Int16()))
+	case caps.VALUE_INT32:
+		lit = strconv.Itoa(int(def.Int32()))
+	case caps.VALUE_INT64:
+		lit = strconv.FormatInt(def.Int64(), 10)
+	case caps.VALUE_UINT8:
+		lit = strconv.Itoa(int(def.Uint8()))
+	case caps.VALUE_UINT16:
+		lit = strconv.Itoa(int(def.Uint16()))
+	case caps.VALUE_UINT32:
+		lit = strconv.FormatUint(uint64(def.Uint32()), 10)
+	case caps.VALUE_UINT64:
+		lit = strconv.FormatUint(def.Uint64(), 10)
+	case caps.VALUE_FLOAT32:
+		f := float64(def.Float32())
+		assert(!math.IsNaN(f) && !math.IsInf(f, 0), "default of presence field must be finite")
+		lit = strconv.FormatFloat(f, 'g', -1, 32)
+	case caps.VALUE_FLOAT64:
+		f := def.Float64()
+		assert(!math.IsNaN(f) && !math.IsInf(f, 0), "default of presence field must be finite")
+		lit = strconv.FormatFloat(f, 'g', -1, 64)
+	case caps.VALUE_VOID:
+		// Zero value of the type
+		if s.Type().Which() == caps.TYPE_BOOL {
+			return "false"
+		}
+		return "0"
+	}
+	return lit
+}
+
+// definePresenceMethods writes Has, Set and Clear methods of presence
+// fields. Members of groups are prefixed with the group name.
+func (n *node) definePresenceMethods(w io.Writer, sn *node, name, expr string) {
+	for _, f := range n.codeOrderFields() {
+		fname := name + goFieldName(f)
+		fexpr := expr + "." + goFieldName(f)
+
+		if f.Which() == caps.FIELD_GROUP {
+			findNode(f.Group().TypeId()).definePresenceMethods(w, sn, fname, fexpr)
+			continue
+		}
+		if !n.hasPresence(f) {
+			continue
+		}
+
+		fmt.Fprintf(w, "// Has%s reports whether %s is set\n", fname, fname)
+		fmt.Fprintf(w, "func (z *%s) Has%s() bool { return z%s != nil }\n\n", sn.name, fname, fexpr)
+
+		fmt.Fprintf(w, "// Set%s sets %s to v\n", fname, fname)
+		fmt.Fprintf(w, "func (z *%s) Set%s(v %s) { z%s = &v }\n\n", sn.name, fname, GoTypeName(n, f.Slot(), ""), fexpr)
+
+		fmt.Fprintf(w, "// Clear%s unsets %s\n", fname, fname)
+		fmt.Fprintf(w, "func (z *%s) Clear%s() { z%s = nil }\n\n", sn.name, fname, fexpr)
+	}
+}
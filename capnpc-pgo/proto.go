@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/tpukep/caps"
+)
+
+// protoWriter translates schema of a single file to proto3 definitions.
+// Constructs without protobuf counterpart are collected in errs.
+type protoWriter struct {
+	buf     bytes.Buffer
+	file    *node
+	imports map[string]bool
+	errs    []string
+}
+
+func (p *protoWriter) printf(depth int, format string, a ...interface{}) {
+	p.buf.WriteString(strings.Repeat("  ", depth))
+	fmt.Fprintf(&p.buf, format, a...)
+}
+
+func (p *protoWriter) errorf(n *node, format string, a ...interface{}) {
+	p.errs = append(p.errs, n.DisplayName()+": "+fmt.Sprintf(format, a...))
+}
+
+// fileOf returns file node the node is declared in, or nil for nodes
+// detached from namespace like implicit method param structs.
+func fileOf(n *node) *node {
+	for n.Which() != caps.NODE_FILE {
+		if n.ScopeId() == 0 {
+			return nil
+		}
+		n = findNode(n.ScopeId())
+	}
+	return n
+}
+
+// protoName returns name of the type relative to the package scope. Types
+// of imported files are qualified with their packages.
+func (p *protoWriter) protoName(n *node) string {
+	pkg := ""
+	if f := fileOf(n); f != nil && f != p.file {
+		p.imports[strings.TrimSuffix(f.DisplayName(), ".capnp")+".proto"] = true
+		pkg = f.pkg + "."
+	}
+
+	name := n.DisplayName()
+	if i := strings.Index(name, ":"); i != -1 {
+		name = name[i+1:]
+	}
+	if strings.Contains(name, "$") {
+		return n.name
+	}
+	return pkg + name
+}
+
+func snakeCase(s string) string {
+	var b bytes.Buffer
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// fieldNumber returns protobuf field number of the field. Groups have no
+// ordinal on their own, they take one of their first member.
+func fieldNumber(f caps.Field) int {
+	if f.Which() == caps.FIELD_GROUP {
+		min := -1
+		for _, gf := range findNode(f.Group().TypeId()).Struct().Fields().ToArray() {
+			if num := fieldNumber(gf); min == -1 || num < min {
+				min = num
+			}
+		}
+		return min
+	}
+	return int(f.Ordinal().Explicit()) + 1
+}
+
+func (p *protoWriter) typeName(n *node, fname string, t caps.Type) string {
+	switch t.Which() {
+	case caps.TYPE_BOOL:
+		return "bool"
+	case caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_INT32:
+		return "int32"
+	case caps.TYPE_INT64:
+		return "int64"
+	case caps.TYPE_UINT8, caps.TYPE_UINT16, caps.TYPE_UINT32:
+		return "uint32"
+	case caps.TYPE_UINT64:
+		return "uint64"
+	case caps.TYPE_FLOAT32:
+		return "float"
+	case caps.TYPE_FLOAT64:
+		return "double"
+	case caps.TYPE_TEXT:
+		return "string"
+	case caps.TYPE_DATA:
+		return "bytes"
+	case caps.TYPE_VOID:
+		p.imports["google/protobuf/empty.proto"] = true
+		return "google.protobuf.Empty"
+	case caps.TYPE_ENUM:
+		return p.protoName(findNode(t.Enum().TypeId()))
+	case caps.TYPE_STRUCT:
+		sn := findNode(t.Struct().TypeId())
+		if sn.IsGeneric() || t.Struct().Brand().Scopes().Len() > 0 {
+			p.errorf(n, "%s: generic struct %s can't be mapped to protobuf", fname, sn.DisplayName())
+		}
+		return p.protoName(sn)
+	case caps.TYPE_LIST:
+		p.errorf(n, "%s: nested list can't be mapped to protobuf", fname)
+	case caps.TYPE_INTERFACE:
+		p.errorf(n, "%s: interface can't be mapped to protobuf", fname)
+	case caps.TYPE_ANYPOINTER:
+		p.errorf(n, "%s: AnyPointer can't be mapped to protobuf", fname)
+	}
+	return "bytes"
+}
+
+func (p *protoWriter) field(n *node, f caps.Field, depth int) {
+	name := snakeCase(f.Name())
+
+	if f.Which() == caps.FIELD_GROUP {
+		p.printf(depth, "%s %s = %d;\n", strings.Title(f.Name()), name, fieldNumber(f))
+		return
+	}
+
+	t := f.Slot().Type()
+	if t.Which() == caps.TYPE_VOID && f.DiscriminantValue() == caps.FieldNoDiscriminant {
+		// Carries no data
+		return
+	}
+
+	label := ""
+	if t.Which() == caps.TYPE_LIST {
+		label = "repeated "
+		t = t.List().ElementType()
+	}
+
+	if label != "" && f.DiscriminantValue() != caps.FieldNoDiscriminant {
+		p.errorf(n, "%s: list can't be a member of protobuf oneof", f.Name())
+	}
+
+	p.printf(depth, "%s%s %s = %d;\n", label, p.typeName(n, f.Name(), t), name, fieldNumber(f))
+}
+
+func (p *protoWriter) message(n *node, name string, depth int) {
+	if n.IsGeneric() {
+		p.errorf(n, "generic struct can't be mapped to protobuf")
+		return
+	}
+
+	if doc := docAnnotation(n.Annotations()); doc != "" {
+		p.printf(depth, "// %s\n", doc)
+	}
+	p.printf(depth, "message %s {\n", name)
+
+	p.nested(n, depth+1)
+
+	// Groups are declared as nested messages
+	for _, f := range n.codeOrderFields() {
+		if f.Which() == caps.FIELD_GROUP {
+			p.message(findNode(f.Group().TypeId()), strings.Title(f.Name()), depth+1)
+		}
+	}
+
+	var union []caps.Field
+	for _, f := range n.codeOrderFields() {
+		if f.DiscriminantValue() != caps.FieldNoDiscriminant {
+			union = append(union, f)
+			continue
+		}
+		p.field(n, f, depth+1)
+	}
+
+	if len(union) > 0 {
+		p.printf(depth+1, "oneof which {\n")
+		for _, f := range union {
+			p.field(n, f, depth+2)
+		}
+		p.printf(depth+1, "}\n")
+	}
+
+	p.printf(depth, "}\n\n")
+}
+
+func (p *protoWriter) enum(n *node, depth int) {
+	prefix := strings.ToUpper(snakeCase(strings.Replace(p.protoName(n), ".", "", -1)))
+
+	p.printf(depth, "enum %s {\n", n.DisplayName()[n.DisplayNamePrefixLength():])
+	for i, e := range n.Enum().Enumerants().ToArray() {
+		p.printf(depth+1, "%s_%s = %d;\n", prefix, strings.ToUpper(snakeCase(e.Name())), i)
+	}
+	p.printf(depth, "}\n\n")
+}
+
+func (p *protoWriter) service(n *node) {
+	name := n.DisplayName()[n.DisplayNamePrefixLength():]
+	var messages []*node
+
+	p.printf(0, "service %s {\n", name)
+	for _, m := range n.Interface().Methods().ToArray() {
+		params := methodStruct(n, m, m.ParamStructType(), "Params")
+		results := methodStruct(n, m, m.ResultStructType(), "Results")
+
+		for _, sn := range []*node{params, results} {
+			if sn.ScopeId() == 0 {
+				messages = append(messages, sn)
+			}
+		}
+
+		p.printf(1, "rpc %s(%s) returns (%s);\n", strings.Title(m.Name()), p.protoName(params), p.protoName(results))
+	}
+	p.printf(0, "}\n\n")
+
+	for _, sn := range messages {
+		p.message(sn, sn.name, 0)
+	}
+}
+
+func (p *protoWriter) nested(n *node, depth int) {
+	for _, nn := range n.NestedNodes().ToArray() {
+		ni := g_nodes[nn.Id()]
+		if ni == nil {
+			continue
+		}
+
+		switch ni.Which() {
+		case caps.NODE_STRUCT:
+			p.message(ni, nn.Name(), depth)
+		case caps.NODE_ENUM:
+			p.enum(ni, depth)
+		case caps.NODE_INTERFACE:
+			if depth > 0 {
+				p.errorf(ni, "nested interface can't be mapped to protobuf service")
+			} else {
+				p.service(ni)
+			}
+		}
+	}
+}
+
+// writeProto writes proto3 definitions of the file. Message field numbers
+// are capnp ordinals plus one, so they remain stable as schema evolves.
+func writeProto(filename string, file *node) {
+	p := &protoWriter{file: file, imports: make(map[string]bool)}
+	p.nested(file, 0)
+
+	assert(len(p.errs) == 0, "failed to generate %s:\n%s", filename, strings.Join(p.errs, "\n"))
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// AUTO GENERATED - DO NOT EDIT\n\n")
+	fmt.Fprintf(&out, "syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&out, "package %s;\n\n", file.pkg)
+
+	if len(p.imports) > 0 {
+		var imports []string
+		for imp := range p.imports {
+			imports = append(imports, imp)
+		}
+		sort.Strings(imports)
+
+		for _, imp := range imports {
+			fmt.Fprintf(&out, "import %q;\n", imp)
+		}
+		fmt.Fprintf(&out, "\n")
+	}
+
+	out.Write(bytes.TrimRight(p.buf.Bytes(), "\n"))
+	out.WriteByte('\n')
+
+	err := ioutil.WriteFile(filename, out.Bytes(), 0644)
+	assert(err == nil, "%v\n", err)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tpukep/caps"
+)
+
+const (
+	orderFileID = 0xe1a5c0ffee000200 + iota
+	orderID
+	orderStatusID
+	orderShippingID
+	itemFileID
+	itemID
+	itemKindID
+)
+
+// orderFile holds:
+//
+//	struct Order {
+//	  id     @0 :UInt64;
+//	  items  @1 :List(Item.Item);
+//	  kind   @2 :Item.Kind;
+//	  status @3 :Status;
+//	  union { pickup @4 :Void; address @5 :Text; }
+//	  shipping :group { carrier @6 :Text; }
+//	  enum Status { new @0; paidInFull @1; }
+//	}
+var orderFile = testFile{id: orderFileID, name: "order.capnp",
+	anns: []testAnnotation{goPackage("shop"), {id: caps.CodecProto}},
+	nodes: []testNode{
+		{id: orderID, name: "Order", data: 2, ptrs: 3, discCount: 2, discOffset: 3, fields: []testField{
+			{name: "id", typ: uint64Type, disc: -1},
+			{name: "items", typ: listTypeOf(structTypeOf(itemID)), disc: -1},
+			{name: "kind", typ: enumTypeOf(itemKindID), offset: 4, disc: -1},
+			{name: "status", typ: enumTypeOf(orderStatusID), offset: 5, disc: -1},
+			{name: "pickup", typ: voidType, disc: 0},
+			{name: "address", typ: textType, offset: 1, disc: 1},
+			{name: "shipping", group: orderShippingID, disc: -1},
+		}},
+		{id: orderStatusID, name: "Order.Status", enumerants: []string{"new", "paidInFull"}},
+		{id: orderShippingID, name: "Order.shipping", group: true, data: 2, ptrs: 3, firstOrdinal: 6, fields: []testField{
+			{name: "carrier", typ: textType, offset: 2, disc: -1},
+		}},
+	},
+}
+
+// itemFile holds:
+//
+//	struct Item { name @0 :Text; }
+//	enum Kind { book @0; }
+var itemFile = testFile{id: itemFileID, name: "item.capnp",
+	anns: []testAnnotation{goPackage("catalog")},
+	nodes: []testNode{
+		{id: itemID, name: "Item", ptrs: 1, fields: []testField{
+			{name: "name", typ: textType, disc: -1},
+		}},
+		{id: itemKindID, name: "Kind", enumerants: []string{"book"}},
+	},
+}
+
+func TestProto(t *testing.T) {
+	out := readOutput(t, generate(t, nil, orderFile, itemFile), "order.proto")
+
+	want := `// AUTO GENERATED - DO NOT EDIT
+
+syntax = "proto3";
+
+package shop;
+
+import "google/protobuf/empty.proto";
+import "item.proto";
+
+message Order {
+  enum Status {
+    ORDER_STATUS_NEW = 0;
+    ORDER_STATUS_PAID_IN_FULL = 1;
+  }
+
+  message Shipping {
+    string carrier = 7;
+  }
+
+  uint64 id = 1;
+  repeated catalog.Item items = 2;
+  catalog.Kind kind = 3;
+  Order.Status status = 4;
+  Shipping shipping = 7;
+  oneof which {
+    google.protobuf.Empty pickup = 5;
+    string address = 6;
+  }
+}
+`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestProtoErrors(t *testing.T) {
+	file := testFile{id: orderFileID, name: "order.capnp",
+		anns: []testAnnotation{goPackage("shop"), {id: caps.CodecProto}},
+		nodes: []testNode{
+			{id: orderID, name: "Order", ptrs: 2, discCount: 2, fields: []testField{
+				{name: "lines", typ: listTypeOf(listTypeOf(textType)), disc: -1},
+				{name: "tags", typ: listTypeOf(textType), offset: 1, disc: 0},
+				{name: "note", typ: textType, offset: 1, disc: 1},
+			}},
+		},
+	}
+
+	out := generateError(t, file)
+	for _, want := range []string{
+		"order.capnp:Order: lines: nested list can't be mapped to protobuf",
+		"order.capnp:Order: tags: list can't be a member of protobuf oneof",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+// randomCheck holds $Check constraints of the field that random values
+// have to satisfy. Bounds are lengths of Text, Data and lists and values of
+// numbers. Constraints of list elements follow "dive".
+type randomCheck struct {
+	min, max string
+	gt, lt   bool
+	oneof    []string
+	format   string
+	required bool
+	elem     *randomCheck
+}
+
+// fieldCheck returns constraints of the field from $Check.value
+// expressions and $Field.required.
+func fieldCheck(f caps.Field) *randomCheck {
+	c := &randomCheck{required: codecField(f).Required}
+	for _, a := range f.Annotations().ToArray() {
+		if a.Id() == caps.CheckValue {
+			c.parse(a.Value().Text())
+		}
+	}
+	return c
+}
+
+func (c *randomCheck) parse(exp string) {
+	for _, rule := range strings.Split(exp, ",") {
+		if strings.Contains(rule, "|") {
+			continue
+		}
+
+		key, param := rule, ""
+		if i := strings.Index(rule, "="); i != -1 {
+			key, param = rule[:i], rule[i+1:]
+		}
+
+		switch key {
+		case "dive":
+			if c.elem == nil {
+				c.elem = &randomCheck{}
+			}
+			c = c.elem
+		case "required":
+			c.required = true
+		case "min", "gte":
+			c.min = param
+		case "max", "lte":
+			c.max = param
+		case "len":
+			c.min, c.max = param, param
+		case "gt":
+			c.min, c.gt = param, true
+		case "lt":
+			c.max, c.lt = param, true
+		case "oneof":
+			c.oneof = strings.Fields(param)
+		default:
+			if _, found := checkFormats[key]; found {
+				c.format = key
+			} else if _, found := checkPatterns[key]; found {
+				c.format = key
+			}
+		}
+	}
+}
+
+// intBounds returns bounds of integers of the type satisfying the check
+func (c *randomCheck) intBounds(min, max int64) (int64, int64) {
+	if v, err := strconv.ParseInt(c.min, 10, 64); err == nil && v >= min {
+		min = v
+		if c.gt && min < max {
+			min++
+		}
+	}
+	if v, err := strconv.ParseInt(c.max, 10, 64); err == nil && v <= max {
+		max = v
+		if c.lt && max > min {
+			max--
+		}
+	}
+	if c.required && min <= 0 && max >= 1 {
+		min = 1
+	} else if c.required && max == 0 && min < 0 {
+		max = -1
+	}
+	return min, max
+}
+
+// uintBounds returns bounds of unsigned integers of the type satisfying the
+// check
+func (c *randomCheck) uintBounds(max uint64) (uint64, uint64) {
+	min := uint64(0)
+	if v, err := strconv.ParseUint(c.min, 10, 64); err == nil && v <= max {
+		min = v
+		if c.gt && min < max {
+			min++
+		}
+	}
+	if v, err := strconv.ParseUint(c.max, 10, 64); err == nil && v <= max {
+		max = v
+		if c.lt && max > min {
+			max--
+		}
+	}
+	if c.required && min == 0 && max >= 1 {
+		min = 1
+	}
+	return min, max
+}
+
+// lenBounds returns bounds of length of Text, Data and lists. Length is
+// up to def above the minimum unless the check limits it.
+func (c *randomCheck) lenBounds(def int64) (int64, int64) {
+	min, max := c.intBounds(0, math.MaxInt32)
+	if c.max == "" {
+		max = min + def
+	}
+	return min, max
+}
+
+// floatBounds returns bounds of floats satisfying the check, [-1e6, 1e6)
+// by default
+func (c *randomCheck) floatBounds() (float64, float64) {
+	min, max := -1e6, 1e6
+	if v, err := strconv.ParseFloat(c.min, 64); err == nil {
+		min = v
+		if c.gt {
+			min = math.Nextafter(min, math.Inf(1))
+		}
+		if c.max == "" {
+			max = min + 2e6
+		}
+	}
+	if v, err := strconv.ParseFloat(c.max, 64); err == nil {
+		max = v
+		if c.min == "" {
+			min = max - 2e6
+		}
+	}
+	return min, max
+}
+
+func floatLiteral(v float64) string {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// randomWriter writes functions making random values of structs
+type randomWriter struct {
+	codeWriter
+}
+
+// randomFunc returns call of function making random struct ni used in n.
+// Structs of other files are made by exported functions of their packages.
+func randomFunc(n, ni *node) string {
+	name := ni.remoteName(n)
+	if i := strings.LastIndex(name, "."); i != -1 {
+		return name[:i+1] + "Random" + name[i+1:] + "(r)"
+	}
+	return "random" + name + "(r, depth+1)"
+}
+
+// oneof returns expression picking one of the values of the check
+func (g *randomWriter) oneof(goType string, values []string) string {
+	lits := make([]string, len(values))
+	for i, v := range values {
+		if goType == "string" {
+			v = strconv.Quote(v)
+		}
+		lits[i] = v
+	}
+	return fmt.Sprintf("[]%s{%s}[r.Intn(%d)]", goType, strings.Join(lits, ", "), len(values))
+}
+
+// value writes statements assigning random value of type t to lv
+func (g *randomWriter) value(lv string, n *node, t caps.Type, c *randomCheck) {
+	c = orEmpty(c)
+
+	switch t.Which() {
+	case caps.TYPE_BOOL:
+		if c.required {
+			g.printf("%s = true\n", lv)
+		} else {
+			g.printf("%s = r.Intn(2) == 1\n", lv)
+		}
+
+	case caps.TYPE_INT8:
+		g.intValue(lv, 8, c)
+	case caps.TYPE_INT16:
+		g.intValue(lv, 16, c)
+	case caps.TYPE_INT32:
+		g.intValue(lv, 32, c)
+	case caps.TYPE_INT64:
+		g.intValue(lv, 64, c)
+
+	case caps.TYPE_UINT8:
+		g.uintValue(lv, 8, c)
+	case caps.TYPE_UINT16:
+		g.uintValue(lv, 16, c)
+	case caps.TYPE_UINT32:
+		g.uintValue(lv, 32, c)
+	case caps.TYPE_UINT64:
+		g.uintValue(lv, 64, c)
+
+	case caps.TYPE_FLOAT32, caps.TYPE_FLOAT64:
+		goType := "float64"
+		if t.Which() == caps.TYPE_FLOAT32 {
+			goType = "float32"
+		}
+		if len(c.oneof) > 0 {
+			g.printf("%s = %s\n", lv, g.oneof(goType, c.oneof))
+			break
+		}
+		min, max := c.floatBounds()
+		if goType == "float64" {
+			g.printf("%s = caps.RandomFloat(r, %s, %s)\n", lv, floatLiteral(min), floatLiteral(max))
+		} else {
+			g.printf("%s = float32(caps.RandomFloat(r, %s, %s))\n", lv, floatLiteral(min), floatLiteral(max))
+		}
+
+	case caps.TYPE_TEXT:
+		if len(c.oneof) > 0 {
+			g.printf("%s = %s\n", lv, g.oneof("string", c.oneof))
+			break
+		}
+		min, max := c.lenBounds(16)
+		g.printf("%s = caps.RandomText(r, %d, %d, %q)\n", lv, min, max, c.format)
+
+	case caps.TYPE_DATA:
+		min, max := c.lenBounds(16)
+		g.printf("%s = caps.RandomBytes(r, %d, %d)\n", lv, min, max)
+
+	case caps.TYPE_ENUM:
+		ni := findNode(t.Enum().TypeId())
+		count := len(ni.Enum().Enumerants().ToArray())
+		if len(c.oneof) > 0 {
+			g.printf("%s = %s\n", lv, g.oneof(ni.remoteName(n), c.oneof))
+			break
+		}
+		min, max := c.uintBounds(uint64(count - 1))
+		g.printf("%s = %s(caps.RandomUint(r, %d, %d))\n", lv, ni.remoteName(n), min, max)
+
+	case caps.TYPE_STRUCT:
+		g.printf("%s = %s\n", lv, randomFunc(n, findNode(t.Struct().TypeId())))
+	}
+
+	// Lists of lists are generated as []interface{}, their elements are
+	// left out
+}
+
+func (g *randomWriter) intValue(lv string, bits uint, c *randomCheck) {
+	goType := fmt.Sprintf("int%d", bits)
+	if len(c.oneof) > 0 {
+		g.printf("%s = %s\n", lv, g.oneof(goType, c.oneof))
+		return
+	}
+
+	min, max := c.intBounds(-1<<(bits-1), 1<<(bits-1)-1)
+	if bits == 64 {
+		g.printf("%s = caps.RandomInt(r, %d, %d)\n", lv, min, max)
+	} else {
+		g.printf("%s = %s(caps.RandomInt(r, %d, %d))\n", lv, goType, min, max)
+	}
+}
+
+func (g *randomWriter) uintValue(lv string, bits uint, c *randomCheck) {
+	goType := fmt.Sprintf("uint%d", bits)
+	if len(c.oneof) > 0 {
+		g.printf("%s = %s\n", lv, g.oneof(goType, c.oneof))
+		return
+	}
+
+	min, max := c.uintBounds(1<<bits - 1)
+	if bits == 64 {
+		g.printf("%s = caps.RandomUint(r, %d, %d)\n", lv, min, max)
+	} else {
+		g.printf("%s = %s(caps.RandomUint(r, %d, %d))\n", lv, goType, min, max)
+	}
+}
+
+// list writes statements assigning random list of the field to lv
+func (g *randomWriter) list(lv string, n *node, s caps.FieldSlot, c *randomCheck) {
+	et := s.Type().List().ElementType()
+	goType := GoTypeName(n, s, "")
+	min, max := c.lenBounds(4)
+
+	switch et.Which() {
+	case caps.TYPE_DATA:
+		// List(Data) is generated as []byte
+		g.printf("%s = caps.RandomBytes(r, %d, %d)\n", lv, min, max)
+		return
+	case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+		g.printf("%s = make([]struct{}, caps.RandomLen(r, %d, %d))\n", lv, min, max)
+		return
+	case caps.TYPE_LIST, caps.TYPE_ANYPOINTER:
+		// Elements of []interface{} are left out
+		return
+	case caps.TYPE_STRUCT:
+		g.printf("if depth < caps.RandomDepth {\n")
+		defer g.printf("}\n")
+	}
+
+	i := g.newVar("i")
+	elem := fmt.Sprintf("%s[%s]", lv, i)
+	g.printf("%s = make(%s, caps.RandomLen(r, %d, %d))\n", lv, goType, min, max)
+	g.printf("for %s := range %s {\n", i, lv)
+	if et.Which() == caps.TYPE_INT32 {
+		// List(Int32) is generated as []uint32
+		g.uintValue(elem, 32, orEmpty(c.elem))
+	} else {
+		g.value(elem, n, et, c.elem)
+	}
+	g.printf("}\n")
+}
+
+func orEmpty(c *randomCheck) *randomCheck {
+	if c == nil {
+		return &randomCheck{}
+	}
+	return c
+}
+
+// field writes statements assigning random value to the field
+func (g *randomWriter) field(n *node, f caps.Field, expr string) {
+	fexpr := expr + "." + goFieldName(f)
+
+	if f.Which() == caps.FIELD_GROUP {
+		g.fields(findNode(f.Group().TypeId()), fexpr)
+		return
+	}
+
+	t := f.Slot().Type()
+	c := fieldCheck(f)
+
+	if ct := fieldCustomType(n, f); ct != nil {
+		switch {
+		case caps.HasAnnotation(f.Annotations(), caps.TypeTimestamp):
+			for _, a := range f.Annotations().ToArray() {
+				if a.Id() == caps.TypeTimestamp {
+					g.printf("%s = caps.RandomTime(r, caps.TIMEUNIT_%s)\n", fexpr, strings.ToUpper(caps.TimeUnit(a.Value().Enum()).String()))
+				}
+			}
+		case caps.HasAnnotation(f.Annotations(), caps.TypeIp):
+			g.printf("%s = caps.RandomIP(r)\n", fexpr)
+		case caps.HasAnnotation(f.Annotations(), caps.TypeUuid):
+			g.printf("%s = %s\n", fexpr, ct.fromWire("caps.RandomBytes(r, 16, 16)"))
+		default:
+			v := g.newVar("v")
+			g.printf("var %s %s\n", v, ct.wire)
+			g.value(v, n, t, c)
+			g.printf("%s = %s\n", fexpr, ct.fromWire(v))
+		}
+		return
+	}
+
+	if t.Which() == caps.TYPE_LIST {
+		g.list(fexpr, n, f.Slot(), c)
+		return
+	}
+
+	if n.hasPresence(f) {
+		// Cap'n Proto reads the default as unset, so it is left nil
+		v := g.newVar("v")
+		g.printf("if r.Intn(2) == 0 {\n")
+		g.printf("var %s %s\n", v, GoTypeName(n, f.Slot(), ""))
+		g.value(v, n, t, c)
+		g.printf("if %s != %s { %s = &%s }\n", v, presenceDefault(f.Slot()), fexpr, v)
+		g.printf("}\n")
+		return
+	}
+
+	g.value(fexpr, n, t, c)
+}
+
+// fields writes statements assigning random values to fields of struct or
+// group n. One member of union is set, the others are left zero.
+func (g *randomWriter) fields(n *node, expr string) {
+	var members []caps.Field
+
+	for _, f := range n.codeOrderFields() {
+		if codecField(f).Ignored {
+			// Left zero as codecs don't keep them
+			continue
+		}
+		if f.Which() == caps.FIELD_SLOT {
+			switch f.Slot().Type().Which() {
+			case caps.TYPE_VOID, caps.TYPE_INTERFACE, caps.TYPE_ANYPOINTER:
+				continue
+			}
+		}
+
+		if f.DiscriminantValue() != caps.FieldNoDiscriminant {
+			members = append(members, f)
+			continue
+		}
+		g.field(n, f, expr)
+	}
+
+	if len(members) == 0 {
+		return
+	}
+
+	g.printf("switch r.Intn(%d) {\n", n.Struct().DiscriminantCount())
+	for _, f := range members {
+		g.printf("case %d:\n", f.DiscriminantValue())
+		g.field(n, f, expr)
+	}
+	g.printf("}\n")
+}
+
+// defineRandom writes RandomX function making struct with random field
+// values, which satisfy $Check constraints.
+func (n *node) defineRandom(w io.Writer) {
+	g_imported["math/rand"] = true
+	g_imported[SELF_IMPORT] = true
+
+	g := &randomWriter{codeWriter: codeWriter{w: w}}
+
+	g.printf("// Random%s returns %s with random field values satisfying $Check\n", n.name, n.name)
+	g.printf("// constraints. Values depend only on the state of r.\n")
+	g.printf("func Random%s(r *rand.Rand) %s {\n", n.name, n.name)
+	g.printf("return random%s(r, 0)\n", n.name)
+	g.printf("}\n\n")
+
+	g.printf("func random%s(r *rand.Rand, depth int) (z %s) {\n", n.name, n.name)
+	g.fields(n, "z")
+	g.printf("return z\n")
+	g.printf("}\n\n")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+// structType returns struct node of struct field or list of structs
+func structType(t caps.Type) *node {
+	switch t.Which() {
+	case caps.TYPE_STRUCT:
+		return findNode(t.Struct().TypeId())
+	case caps.TYPE_LIST:
+		return structType(t.List().ElementType())
+	}
+	return nil
+}
+
+// hasSensitive reports whether the struct or structs it holds have fields
+// annotated with $Field.sensitive.
+func (n *node) hasSensitive(seen map[uint64]bool) bool {
+	if seen[n.Id()] {
+		return false
+	}
+	seen[n.Id()] = true
+
+	for _, f := range n.codeOrderFields() {
+		if caps.HasAnnotation(f.Annotations(), caps.FieldSensitive) {
+			return true
+		}
+		if f.Which() == caps.FIELD_GROUP {
+			if findNode(f.Group().TypeId()).hasSensitive(seen) {
+				return true
+			}
+		} else if st := structType(f.Slot().Type()); st != nil && st.hasSensitive(seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactFields masks sensitive fields of the copy. All fields of sensitive
+// groups are masked.
+func redactFields(w io.Writer, n *node, expr string, mask bool) {
+	for _, f := range n.codeOrderFields() {
+		fexpr := expr + "." + goFieldName(f)
+		sensitive := mask || caps.HasAnnotation(f.Annotations(), caps.FieldSensitive)
+
+		if f.Which() == caps.FIELD_GROUP {
+			redactFields(w, findNode(f.Group().TypeId()), fexpr, sensitive)
+			continue
+		}
+
+		t := f.Slot().Type()
+		switch t.Which() {
+		case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+			continue
+		}
+
+		typeName := n.fieldGoType(f)
+		st := structType(t)
+		redactNested := st != nil && st.hasSensitive(make(map[uint64]bool))
+
+		switch {
+		case sensitive && fieldCustomType(n, f) != nil:
+			fmt.Fprintf(w, "%s = *new(%s)\n", fexpr, typeName)
+		case sensitive && t.Which() == caps.TYPE_TEXT:
+			fmt.Fprintf(w, "if %s != \"\" { %s = \"***\" }\n", fexpr, fexpr)
+		case sensitive && (strings.HasPrefix(typeName, "[]") || strings.HasPrefix(typeName, "*") || typeName == "interface{}"):
+			fmt.Fprintf(w, "%s = nil\n", fexpr)
+		case sensitive && t.Which() == caps.TYPE_STRUCT:
+			fmt.Fprintf(w, "%s = %s{}\n", fexpr, typeName)
+		case sensitive && t.Which() == caps.TYPE_BOOL:
+			fmt.Fprintf(w, "%s = false\n", fexpr)
+		case sensitive:
+			fmt.Fprintf(w, "%s = 0\n", fexpr)
+		case redactNested && t.Which() == caps.TYPE_STRUCT:
+			fmt.Fprintf(w, "%s = %s.Redacted()\n", fexpr, fexpr)
+		case redactNested && t.List().ElementType().Which() != caps.TYPE_STRUCT:
+			// Lists of lists are generated as []interface{}, they are dropped
+			fmt.Fprintf(w, "%s = nil\n", fexpr)
+		case redactNested:
+			// New slice keeps elements of the original intact
+			fmt.Fprintf(w, "if %s != nil {\n", fexpr)
+			fmt.Fprintf(w, "l := make(%s, len(%s))\n", typeName, fexpr)
+			fmt.Fprintf(w, "for i := range %s { l[i] = %s[i].Redacted() }\n", fexpr, fexpr)
+			fmt.Fprintf(w, "%s = l\n", fexpr)
+			fmt.Fprintf(w, "}\n")
+		}
+	}
+}
+
+// defineRedactMethods writes Redacted copy and fmt methods masking
+// sensitive fields of the struct.
+func (n *node) defineRedactMethods(w io.Writer) {
+	g_imported["fmt"] = true
+	g_imported["strings"] = true
+
+	fmt.Fprintf(w, "// Redacted returns copy of %s with sensitive fields masked\n", n.name)
+	fmt.Fprintf(w, "func (z %s) Redacted() %s {\n", n.name, n.name)
+	fmt.Fprintf(w, "c := z\n")
+	redactFields(w, n, "c", false)
+	fmt.Fprintf(w, "return c\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "// String formats %s like %%+v with sensitive fields masked\n", n.name)
+	fmt.Fprintf(w, "func (z %s) String() string {\n", n.name)
+	fmt.Fprintf(w, "type plain %s\n", n.name)
+	fmt.Fprintf(w, "return fmt.Sprintf(\"%%+v\", plain(z.Redacted()))\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "// GoString formats %s like %%#v with sensitive fields masked\n", n.name)
+	fmt.Fprintf(w, "func (z %s) GoString() string {\n", n.name)
+	fmt.Fprintf(w, "type plain %s\n", n.name)
+	fmt.Fprintf(w, "s := fmt.Sprintf(\"%%#v\", plain(z.Redacted()))\n")
+	fmt.Fprintf(w, "return \"%s.%s\" + s[strings.Index(s, \"{\"):]\n", n.pkg, n.name)
+	fmt.Fprintf(w, "}\n\n")
+
+	// Without Format verbs like %d or %x would print fields of z
+	g_imported[SELF_IMPORT] = true
+	fmt.Fprintf(w, "// Format formats %s with sensitive fields masked for every verb\n", n.name)
+	fmt.Fprintf(w, "func (z %s) Format(f fmt.State, verb rune) {\n", n.name)
+	fmt.Fprintf(w, "if verb == 'v' && f.Flag('#') {\n")
+	fmt.Fprintf(w, "fmt.Fprint(f, z.GoString())\n")
+	fmt.Fprintf(w, "return\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "type plain %s\n", n.name)
+	fmt.Fprintf(w, "fmt.Fprintf(f, caps.FormatString(f, verb), plain(z.Redacted()))\n")
+	fmt.Fprintf(w, "}\n\n")
+}
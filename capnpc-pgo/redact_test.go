@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tpukep/caps"
+)
+
+const (
+	redactFileID = 0xe1a5c0ffee000000 + iota
+	accountID
+	cardID
+)
+
+// redactFile holds:
+//
+//	struct Account {
+//	  name     @0 :Text;
+//	  password @1 :Text $Field.sensitive;
+//	  pin      @2 :UInt64 $Field.sensitive;
+//	  cards    @3 :List(Card);
+//	}
+//	struct Card { number @0 :Text $Field.sensitive; }
+var redactFile = testFile{id: redactFileID, name: "account.capnp", anns: []testAnnotation{goPackage("gentest")},
+	nodes: []testNode{
+		{id: accountID, name: "Account", data: 1, ptrs: 3, fields: []testField{
+			{name: "name", typ: textType, disc: -1},
+			{name: "password", typ: textType, offset: 1, disc: -1, anns: []testAnnotation{{id: caps.FieldSensitive}}},
+			{name: "pin", typ: uint64Type, disc: -1, anns: []testAnnotation{{id: caps.FieldSensitive}}},
+			{name: "cards", typ: listTypeOf(structTypeOf(cardID)), offset: 2, disc: -1},
+		}},
+		{id: cardID, name: "Card", ptrs: 1, fields: []testField{
+			{name: "number", typ: textType, disc: -1, anns: []testAnnotation{{id: caps.FieldSensitive}}},
+		}},
+	},
+}
+
+const redactTest = `package gentest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRedactedFormat(t *testing.T) {
+	a := Account{Name: "alice", Password: "hunter2", Pin: 987654321, Cards: []Card{{Number: "4111111111111111"}}}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%d", "%x", "%10v"} {
+		out := fmt.Sprintf(format, a) + fmt.Sprintf(format, &a)
+		for _, secret := range []string{"hunter2", "987654321", "4111111111111111", fmt.Sprintf("%x", "hunter2"), fmt.Sprintf("%x", 987654321)} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s prints %s: %s", format, secret, out)
+			}
+		}
+	}
+
+	if s := fmt.Sprintf("%+v", a); !strings.Contains(s, "Name:alice") || !strings.Contains(s, "Password:***") {
+		t.Errorf("%%+v: %s", s)
+	}
+	if s := fmt.Sprintf("%#v", a); !strings.HasPrefix(s, "gentest.Account{") {
+		t.Errorf("%%#v: %s", s)
+	}
+	if a.Password != "hunter2" || a.Cards[0].Number != "4111111111111111" {
+		t.Errorf("printing changed the value: %#v", a.Cards)
+	}
+}
+`
+
+func TestRedact(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated package")
+	}
+	goTest(t, generate(t, nil, redactFile), redactTest)
+}
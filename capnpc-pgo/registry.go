@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+const REGISTRY_IMPORT = "github.com/tpukep/caps/registry"
+
+// fileId returns id of the file node declaring n
+func fileId(n caps.Node) uint64 {
+	for n.Which() != caps.NODE_FILE {
+		p, found := g_nodes[n.ScopeId()]
+		if !found {
+			return 0
+		}
+		n = p.Node
+	}
+	return n.Id()
+}
+
+// writeRegistry embeds nodes of the file into generated code as packed
+// CodeGeneratorRequest and registers them with generated types at init.
+func writeRegistry(w io.Writer, f *node, req caps.CodeGeneratorRequest) {
+	var fnodes []caps.Node
+	for _, n := range req.Nodes().ToArray() {
+		if fileId(n) == f.Id() {
+			fnodes = append(fnodes, n)
+		}
+	}
+
+	seg := C.NewBuffer(nil)
+	r := caps.NewRootCodeGeneratorRequest(seg)
+	nl := caps.NewNodeList(seg, len(fnodes))
+	for i, n := range fnodes {
+		err := C.PointerList(nl).Set(i, C.Object(n))
+		assert(err == nil, "%v\n", err)
+	}
+	r.SetNodes(nl)
+
+	var data bytes.Buffer
+	_, err := seg.WriteToPacked(&data)
+	assert(err == nil, "%v\n", err)
+
+	schemaName := fmt.Sprintf("schema_%x", f.Id())
+
+	fmt.Fprintf(w, "const %s = \"\" +\n", schemaName)
+	b := data.Bytes()
+	for len(b) > 0 {
+		n := 32
+		if n > len(b) {
+			n = len(b)
+		}
+		fmt.Fprintf(w, "\t\"")
+		for _, c := range b[:n] {
+			fmt.Fprintf(w, "\\x%02x", c)
+		}
+		b = b[n:]
+		if len(b) > 0 {
+			fmt.Fprintf(w, "\" +\n")
+		} else {
+			fmt.Fprintf(w, "\"\n\n")
+		}
+	}
+
+	fmt.Fprintf(w, "func init() {\n")
+	fmt.Fprintf(w, "registry.Register(%s)\n", schemaName)
+	for _, n := range f.nodes {
+		switch n.Which() {
+		case caps.NODE_STRUCT:
+			fmt.Fprintf(w, "registry.RegisterType(0x%x, %s{})\n", n.Id(), n.name)
+		case caps.NODE_ENUM:
+			fmt.Fprintf(w, "registry.RegisterType(0x%x, %s(0))\n", n.Id(), n.name)
+		}
+	}
+	fmt.Fprintf(w, "}\n\n")
+
+	g_imported[REGISTRY_IMPORT] = true
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+// Requests of the tests are built the way capnp compile writes them, so the
+// tests don't need the compiler. The generator is built once and run on
+// them in temporary directories.
+
+type testAnnotation struct {
+	id   uint64
+	text string
+}
+
+type testField struct {
+	name   string
+	typ    func(*C.Segment) caps.Type
+	offset uint32
+	disc   int // -1 if the field is not union member
+	group  uint64
+	anns   []testAnnotation
+}
+
+type testNode struct {
+	id                    uint64
+	name                  string // Relative to the file, e.g. Person.Address
+	anns                  []testAnnotation
+	group                 bool
+	data, ptrs            uint16
+	discCount, discOffset uint16
+	firstOrdinal          uint16 // Members of groups continue ordinals of their parents
+	fields                []testField
+	enumerants            []string
+	enumAnns              [][]testAnnotation // Annotations of enumerants
+}
+
+type testFile struct {
+	id    uint64
+	name  string
+	anns  []testAnnotation
+	nodes []testNode
+}
+
+func primType(set func(caps.Type)) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		set(t)
+		return t
+	}
+}
+
+var (
+	voidType   = primType(caps.Type.SetVoid)
+	boolType   = primType(caps.Type.SetBool)
+	int32Type  = primType(caps.Type.SetInt32)
+	int64Type  = primType(caps.Type.SetInt64)
+	uint8Type  = primType(caps.Type.SetUint8)
+	uint64Type = primType(caps.Type.SetUint64)
+	textType   = primType(caps.Type.SetText)
+	dataType   = primType(caps.Type.SetData)
+)
+
+func structTypeOf(id uint64) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetStruct()
+		t.Struct().SetTypeId(id)
+		return t
+	}
+}
+
+func enumTypeOf(id uint64) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetEnum()
+		t.Enum().SetTypeId(id)
+		return t
+	}
+}
+
+func listTypeOf(et func(*C.Segment) caps.Type) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetList()
+		t.List().SetElementType(et(seg))
+		return t
+	}
+}
+
+func testAnnotations(seg *C.Segment, as []testAnnotation) caps.Annotation_List {
+	l := caps.NewAnnotationList(seg, len(as))
+	for i, a := range as {
+		v := caps.NewValue(seg)
+		if a.text != "" {
+			v.SetText(a.text)
+		} else {
+			v.SetVoid()
+		}
+		l.At(i).SetId(a.id)
+		l.At(i).SetValue(v)
+	}
+	return l
+}
+
+// goPackage is $Go.package annotation of test files
+func goPackage(name string) testAnnotation {
+	return testAnnotation{id: C.Package, text: name}
+}
+
+// testRequest returns code generator request of the files, all of them are
+// requested.
+func testRequest(files ...testFile) []byte {
+	seg := C.NewBuffer(nil)
+	req := caps.NewRootCodeGeneratorRequest(seg)
+
+	count := 0
+	for _, tf := range files {
+		count += len(tf.nodes) + 1
+	}
+	nl := caps.NewNodeList(seg, count)
+	rl := caps.NewCodeGeneratorRequestRequestedFileList(seg, len(files))
+
+	i := 0
+	for fi, tf := range files {
+		ids := map[string]uint64{}
+		nested := map[uint64][]testNode{}
+		for _, d := range tf.nodes {
+			ids[d.name] = d.id
+		}
+		parent := func(name string) uint64 {
+			if i := strings.LastIndex(name, "."); i != -1 {
+				return ids[name[:i]]
+			}
+			return tf.id
+		}
+		for _, d := range tf.nodes {
+			if !d.group {
+				nested[parent(d.name)] = append(nested[parent(d.name)], d)
+			}
+		}
+		nestedNodes := func(id uint64) caps.NodeNestedNode_List {
+			l := caps.NewNodeNestedNodeList(seg, len(nested[id]))
+			for j, d := range nested[id] {
+				l.At(j).SetId(d.id)
+				l.At(j).SetName(d.name[strings.LastIndex(d.name, ".")+1:])
+			}
+			return l
+		}
+
+		file := nl.At(i)
+		i++
+		file.SetId(tf.id)
+		file.SetDisplayName(tf.name)
+		file.SetAnnotations(testAnnotations(seg, tf.anns))
+		file.SetNestedNodes(nestedNodes(tf.id))
+		file.SetFile()
+		rl.At(fi).SetId(tf.id)
+		rl.At(fi).SetFilename(tf.name)
+
+		for _, d := range tf.nodes {
+			n := nl.At(i)
+			i++
+			name := tf.name + ":" + d.name
+			n.SetId(d.id)
+			n.SetScopeId(parent(d.name))
+			n.SetDisplayName(name)
+			n.SetDisplayNamePrefixLength(uint32(strings.LastIndexAny(name, ":.") + 1))
+			n.SetAnnotations(testAnnotations(seg, d.anns))
+			n.SetNestedNodes(nestedNodes(d.id))
+
+			if d.enumerants != nil {
+				n.SetEnum()
+				el := caps.NewEnumerantList(seg, len(d.enumerants))
+				for j, name := range d.enumerants {
+					el.At(j).SetName(name)
+					el.At(j).SetCodeOrder(uint16(j))
+					var anns []testAnnotation
+					if j < len(d.enumAnns) {
+						anns = d.enumAnns[j]
+					}
+					el.At(j).SetAnnotations(testAnnotations(seg, anns))
+				}
+				n.Enum().SetEnumerants(el)
+				continue
+			}
+
+			n.SetStruct()
+			st := n.Struct()
+			st.SetIsGroup(d.group)
+			st.SetDataWordCount(d.data)
+			st.SetPointerCount(d.ptrs)
+			st.SetDiscriminantCount(d.discCount)
+			st.SetDiscriminantOffset(uint32(d.discOffset))
+
+			fl := caps.NewFieldList(seg, len(d.fields))
+			for j, tf := range d.fields {
+				f := fl.At(j)
+				f.SetName(tf.name)
+				f.SetCodeOrder(uint16(j))
+				f.SetAnnotations(testAnnotations(seg, tf.anns))
+				f.Ordinal().SetExplicit(d.firstOrdinal + uint16(j))
+				if tf.disc >= 0 {
+					f.SetDiscriminantValue(uint16(tf.disc))
+				} else {
+					f.SetDiscriminantValue(caps.FieldNoDiscriminant)
+				}
+
+				if tf.group != 0 {
+					f.SetGroup()
+					f.Group().SetTypeId(tf.group)
+					continue
+				}
+				f.SetSlot()
+				f.Slot().SetOffset(tf.offset)
+				f.Slot().SetType(tf.typ(seg))
+				def := caps.NewValue(seg)
+				def.SetVoid()
+				f.Slot().SetDefaultValue(def)
+			}
+			st.SetFields(fl)
+		}
+	}
+	req.SetNodes(nl)
+	req.SetRequestedFiles(rl)
+
+	var buf bytes.Buffer
+	seg.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+var generator string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "capnpc-pgo")
+	if err != nil {
+		panic(err)
+	}
+	generator = filepath.Join(dir, "capnpc-pgo")
+
+	code := 1
+	if out, err := exec.Command("go", "build", "-o", generator, ".").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "build capnpc-pgo: %v\n%s", err, out)
+	} else {
+		code = m.Run()
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// generate runs the generator on the files and returns directory of its
+// output. env is added to the environment of the generator.
+func generate(t *testing.T, env []string, files ...testFile) string {
+	dir := t.TempDir()
+	cmd := exec.Command(generator)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(testRequest(files...))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("capnpc-pgo: %v\n%s", err, out)
+	}
+	return dir
+}
+
+// generateError runs the generator on the files, which must fail, and
+// returns its output.
+func generateError(t *testing.T, files ...testFile) string {
+	cmd := exec.Command(generator)
+	cmd.Dir = t.TempDir()
+	cmd.Stdin = bytes.NewReader(testRequest(files...))
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("capnpc-pgo succeeded")
+	}
+	return string(out)
+}
+
+func readOutput(t *testing.T, dir, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// goTest runs test with generated Go files of dir in a package inside of
+// temporary GOPATH, so it sees vendored packages of the repository. The
+// test is skipped if some of the imports aren't in GOPATH.
+func goTest(t *testing.T, dir, test string, imports ...string) {
+	gopath := t.TempDir()
+	root := filepath.Join(gopath, "src", "github.com", "tpukep", "caps")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := os.Symlink(filepath.Join(repo, e.Name()), filepath.Join(root, e.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pkg := filepath.Join(root, "gentest")
+	if err := os.Mkdir(pkg, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(pkg, filepath.Base(f)), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkg, "gen_test.go"), []byte(test), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := append(os.Environ(), "GOPATH="+gopath+string(os.PathListSeparator)+build.Default.GOPATH, "GO111MODULE=off", "GOFLAGS=")
+	for _, imp := range imports {
+		cmd := exec.Command("go", "list", imp)
+		cmd.Dir = pkg
+		cmd.Env = env
+		if err := cmd.Run(); err != nil {
+			t.Skipf("%s is not in GOPATH", imp)
+		}
+	}
+
+	cmd := exec.Command("go", "test", ".")
+	cmd.Dir = pkg
+	cmd.Env = env
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test: %v\n%s", err, out)
+	}
+}
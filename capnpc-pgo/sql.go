@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+// sqlColumn describes table column mapped to a field of the struct.
+type sqlColumn struct {
+	name    string
+	sqlType string
+	expr    string // Go expression of the field
+	json    bool   // stored as JSON document
+	null    bool   // nullable: JSON, Data and presence fields
+	numeric bool   // UInt64 sent and scanned as decimal text
+	custom  *customType
+	primary bool
+	indexed bool
+}
+
+func textAnnotation(annotations caps.Annotation_List, id uint64) string {
+	for _, a := range annotations.ToArray() {
+		if a.Id() == id {
+			return a.Value().Text()
+		}
+	}
+	return ""
+}
+
+func sqlTable(n *node) string {
+	if name := textAnnotation(n.Annotations(), caps.SqlTable); name != "" {
+		return name
+	}
+	return snakeCase(n.name)
+}
+
+// sqlType returns PostgreSQL column type of the field. Types without SQL
+// counterpart (structs, lists and AnyPointer) are stored as JSON. UInt64
+// doesn't fit BIGINT, database/sql rejects values with high bit set too,
+// so it is stored as NUMERIC(20) and sent as text.
+func sqlType(t caps.Type) (string, bool) {
+	switch t.Which() {
+	case caps.TYPE_BOOL:
+		return "BOOLEAN", false
+	case caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_UINT8:
+		return "SMALLINT", false
+	case caps.TYPE_INT32, caps.TYPE_UINT16:
+		return "INTEGER", false
+	case caps.TYPE_INT64, caps.TYPE_UINT32:
+		return "BIGINT", false
+	case caps.TYPE_UINT64:
+		return "NUMERIC(20)", false
+	case caps.TYPE_FLOAT32:
+		return "REAL", false
+	case caps.TYPE_FLOAT64:
+		return "DOUBLE PRECISION", false
+	case caps.TYPE_TEXT:
+		return "TEXT", false
+	case caps.TYPE_DATA:
+		return "BYTEA", false
+	case caps.TYPE_ENUM:
+		// Ordinal of the enumerant
+		return "SMALLINT", false
+	case caps.TYPE_LIST:
+		if t.List().ElementType().Which() == caps.TYPE_UINT8 {
+			return "BYTEA", false
+		}
+	}
+	return "JSON", true
+}
+
+// sqlColumns returns columns of the struct. Groups are flattened into
+// columns prefixed with group name.
+func sqlColumns(n *node, prefix, expr string) []sqlColumn {
+	var cols []sqlColumn
+
+	for _, f := range n.codeOrderFields() {
+		if codecField(f).Ignored {
+			continue
+		}
+
+		ans := f.Annotations()
+		name := textAnnotation(ans, caps.SqlColumn)
+		if name == "" {
+			name = snakeCase(f.Name())
+		}
+		name = prefix + name
+		fexpr := expr + "." + goFieldName(f)
+
+		if f.Which() == caps.FIELD_GROUP {
+			cols = append(cols, sqlColumns(findNode(f.Group().TypeId()), name+"_", fexpr)...)
+			continue
+		}
+
+		t := f.Slot().Type()
+		if t.Which() == caps.TYPE_VOID || t.Which() == caps.TYPE_INTERFACE {
+			continue
+		}
+
+		c := sqlColumn{name: name, expr: fexpr}
+		c.sqlType, c.json = sqlType(t)
+		presence := n.hasPresence(f)
+		// nil Data is sent as NULL
+		c.null = c.json || c.sqlType == "BYTEA" || presence
+		c.numeric = t.Which() == caps.TYPE_UINT64
+		c.custom = fieldCustomType(n, f)
+		c.primary = caps.HasAnnotation(ans, caps.SqlPrimaryKey)
+		c.indexed = caps.HasAnnotation(ans, caps.SqlIndexed)
+
+		assert(!(c.primary && c.json), "%s.%s: primary key must be of scalar type", n.DisplayName(), f.Name())
+		assert(!(c.primary && presence), "%s.%s: primary key can't track presence", n.DisplayName(), f.Name())
+
+		cols = append(cols, c)
+	}
+
+	return cols
+}
+
+// defineSQL writes PostgreSQL table definition, column list, Scan helper
+// and statement builders of the struct. Placeholders are $1, $2, ...
+func (n *node) defineSQL(w io.Writer) {
+	table := sqlTable(n)
+	cols := sqlColumns(n, "", "z")
+	if len(cols) == 0 {
+		return
+	}
+
+	var names, keys, values []string
+	var ddl bytes.Buffer
+
+	fmt.Fprintf(&ddl, "CREATE TABLE %s (\n", table)
+	for i, c := range cols {
+		names = append(names, c.name)
+		if c.primary {
+			keys = append(keys, c.name)
+		}
+
+		null := " NOT NULL"
+		if c.null {
+			null = ""
+		}
+		sep := ","
+		if i == len(cols)-1 && len(keys) == 0 {
+			sep = ""
+		}
+		fmt.Fprintf(&ddl, "\t%s %s%s%s\n", c.name, c.sqlType, null, sep)
+	}
+	if len(keys) > 0 {
+		fmt.Fprintf(&ddl, "\tPRIMARY KEY (%s)\n", strings.Join(keys, ", "))
+	}
+	fmt.Fprintf(&ddl, ");\n")
+	for _, c := range cols {
+		if c.indexed {
+			fmt.Fprintf(&ddl, "CREATE INDEX %s_%s_idx ON %s (%s);\n", table, c.name, table, c.name)
+		}
+	}
+
+	fmt.Fprintf(w, "const %sTable = %q\n\n", n.name, table)
+	fmt.Fprintf(w, "// %sColumns lists columns of %sTable in Scan order\n", n.name, n.name)
+	fmt.Fprintf(w, "const %sColumns = %q\n\n", n.name, strings.Join(names, ", "))
+	fmt.Fprintf(w, "const %sSchemaSQL = `%s`\n\n", n.name, ddl.String())
+
+	// Scan
+	fmt.Fprintf(w, "// Scan reads row of %sColumns into the struct\n", n.name)
+	fmt.Fprintf(w, "func (z *%s) Scan(rows interface{ Scan(...interface{}) error }) error {\n", n.name)
+	var dest []string
+	for i, c := range cols {
+		if c.json {
+			g_imported["encoding/json"] = true
+			fmt.Fprintf(w, "var j%d []byte\n", i)
+			dest = append(dest, fmt.Sprintf("&j%d", i))
+		} else if c.numeric {
+			g_imported["database/sql"] = true
+			fmt.Fprintf(w, "var n%d sql.NullString\n", i)
+			dest = append(dest, fmt.Sprintf("&n%d", i))
+		} else if c.custom != nil {
+			// Custom values are scanned as values of the slot type
+			fmt.Fprintf(w, "var s%d %s\n", i, c.custom.wire)
+			dest = append(dest, fmt.Sprintf("&s%d", i))
+		} else {
+			dest = append(dest, "&"+c.expr)
+		}
+	}
+	fmt.Fprintf(w, "if err := rows.Scan(%s); err != nil { return err }\n", strings.Join(dest, ", "))
+	for i, c := range cols {
+		if c.json {
+			fmt.Fprintf(w, "if len(j%d) > 0 {\n", i)
+			fmt.Fprintf(w, "if err := json.Unmarshal(j%d, &%s); err != nil { return err }\n", i, c.expr)
+			fmt.Fprintf(w, "}\n")
+		} else if c.numeric {
+			g_imported["strconv"] = true
+			fmt.Fprintf(w, "if n%d.Valid {\n", i)
+			fmt.Fprintf(w, "u, err := strconv.ParseUint(n%d.String, 10, 64)\n", i)
+			fmt.Fprintf(w, "if err != nil { return err }\n")
+			switch {
+			case c.custom != nil:
+				fmt.Fprintf(w, "%s = %s\n", c.expr, c.custom.fromWire("u"))
+			case c.null:
+				fmt.Fprintf(w, "%s = &u\n", c.expr)
+			default:
+				fmt.Fprintf(w, "%s = u\n", c.expr)
+			}
+			fmt.Fprintf(w, "}\n")
+		} else if c.custom != nil {
+			fmt.Fprintf(w, "%s = %s\n", c.expr, c.custom.fromWire(fmt.Sprintf("s%d", i)))
+		}
+	}
+	fmt.Fprintf(w, "return nil\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	// Statement arguments in column order
+	fmt.Fprintf(w, "func (z *%s) sqlValues() ([]interface{}, error) {\n", n.name)
+	for i, c := range cols {
+		if c.json {
+			fmt.Fprintf(w, "j%d, err := json.Marshal(%s)\n", i, c.expr)
+			fmt.Fprintf(w, "if err != nil { return nil, err }\n")
+			values = append(values, fmt.Sprintf("string(j%d)", i))
+		} else if c.numeric && c.custom != nil {
+			values = append(values, fmt.Sprintf("strconv.FormatUint(%s, 10)", c.custom.toWire(c.expr)))
+		} else if c.numeric && c.null {
+			fmt.Fprintf(w, "var n%d interface{}\n", i)
+			fmt.Fprintf(w, "if %s != nil { n%d = strconv.FormatUint(*%s, 10) }\n", c.expr, i, c.expr)
+			values = append(values, fmt.Sprintf("n%d", i))
+		} else if c.numeric {
+			values = append(values, fmt.Sprintf("strconv.FormatUint(%s, 10)", c.expr))
+		} else if c.custom != nil {
+			values = append(values, c.custom.toWire(c.expr))
+		} else {
+			values = append(values, c.expr)
+		}
+	}
+	fmt.Fprintf(w, "return []interface{}{%s}, nil\n", strings.Join(values, ", "))
+	fmt.Fprintf(w, "}\n\n")
+
+	// Insert
+	var params []string
+	for i := range cols {
+		params = append(params, fmt.Sprintf("$%d", i+1))
+	}
+	fmt.Fprintf(w, "// InsertSQL returns statement inserting the struct into %sTable\n", n.name)
+	fmt.Fprintf(w, "func (z *%s) InsertSQL() (string, []interface{}, error) {\n", n.name)
+	fmt.Fprintf(w, "args, err := z.sqlValues()\n")
+	fmt.Fprintf(w, "if err != nil { return \"\", nil, err }\n")
+	fmt.Fprintf(w, "return %q, args, nil\n", fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), strings.Join(params, ", ")))
+	fmt.Fprintf(w, "}\n\n")
+
+	if len(keys) == 0 || len(keys) == len(cols) {
+		return
+	}
+
+	// Update: non key columns are set, key columns go to WHERE clause
+	var set, where, order []string
+	for i, c := range cols {
+		if !c.primary {
+			order = append(order, fmt.Sprintf("v[%d]", i))
+			set = append(set, fmt.Sprintf("%s = $%d", c.name, len(order)))
+		}
+	}
+	for i, c := range cols {
+		if c.primary {
+			order = append(order, fmt.Sprintf("v[%d]", i))
+			where = append(where, fmt.Sprintf("%s = $%d", c.name, len(order)))
+		}
+	}
+	fmt.Fprintf(w, "// UpdateSQL returns statement updating row of %sTable with the same primary key\n", n.name)
+	fmt.Fprintf(w, "func (z *%s) UpdateSQL() (string, []interface{}, error) {\n", n.name)
+	fmt.Fprintf(w, "v, err := z.sqlValues()\n")
+	fmt.Fprintf(w, "if err != nil { return \"\", nil, err }\n")
+	fmt.Fprintf(w, "return %q, []interface{}{%s}, nil\n",
+		fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(set, ", "), strings.Join(where, " AND ")),
+		strings.Join(order, ", "))
+	fmt.Fprintf(w, "}\n\n")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tpukep/caps"
+)
+
+const (
+	sqlFileID = 0xe1a5c0ffee000100 + iota
+	messageID
+	messageMetaID
+	levelID
+)
+
+// sqlFile holds:
+//
+//	struct Message $Sql.table("messages") {
+//	  id      @0 :UInt64 $Sql.primaryKey;
+//	  body    @1 :Text $Sql.indexed;
+//	  payload @2 :Data;
+//	  level   @3 :Level;
+//	  tags    @4 :List(Text);
+//	  count   @5 :UInt64 $Field.optional("count") $Field.presence;
+//	  meta :group { author @6 :Text $Sql.column("by"); }
+//	}
+//	enum Level { low @0; high @1; }
+var sqlFile = testFile{id: sqlFileID, name: "message.capnp",
+	anns: []testAnnotation{goPackage("gentest"), {id: caps.CodecSql}},
+	nodes: []testNode{
+		{id: messageID, name: "Message", anns: []testAnnotation{{id: caps.SqlTable, text: "messages"}}, data: 3, ptrs: 4, fields: []testField{
+			{name: "id", typ: uint64Type, disc: -1, anns: []testAnnotation{{id: caps.SqlPrimaryKey}}},
+			{name: "body", typ: textType, disc: -1, anns: []testAnnotation{{id: caps.SqlIndexed}}},
+			{name: "payload", typ: dataType, offset: 1, disc: -1},
+			{name: "level", typ: enumTypeOf(levelID), offset: 4, disc: -1},
+			{name: "tags", typ: listTypeOf(textType), offset: 2, disc: -1},
+			{name: "count", typ: uint64Type, offset: 2, disc: -1, anns: []testAnnotation{{id: caps.FieldOptional, text: "count"}, {id: caps.FieldPresence}}},
+			{name: "meta", group: messageMetaID, disc: -1},
+		}},
+		{id: messageMetaID, name: "Message.meta", group: true, data: 3, ptrs: 4, firstOrdinal: 6, fields: []testField{
+			{name: "author", typ: textType, offset: 3, disc: -1, anns: []testAnnotation{{id: caps.SqlColumn, text: "by"}}},
+		}},
+		{id: levelID, name: "Level", enumerants: []string{"low", "high"}},
+	},
+}
+
+func TestSQLSchema(t *testing.T) {
+	out := readOutput(t, generate(t, nil, sqlFile), "message.go")
+
+	ddl := "const MessageSchemaSQL = `CREATE TABLE messages (\n" +
+		"\tid NUMERIC(20) NOT NULL,\n" +
+		"\tbody TEXT NOT NULL,\n" +
+		"\tpayload BYTEA,\n" +
+		"\tlevel SMALLINT NOT NULL,\n" +
+		"\ttags JSON,\n" +
+		"\tcount NUMERIC(20),\n" +
+		"\tmeta_by TEXT NOT NULL,\n" +
+		"\tPRIMARY KEY (id)\n" +
+		");\n" +
+		"CREATE INDEX messages_body_idx ON messages (body);\n`"
+	if !strings.Contains(out, ddl) {
+		t.Errorf("no table definition\n%s\nin\n%s", ddl, out)
+	}
+}
+
+const sqlTest = `package gentest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeDriver keeps arguments of the last statement and returns them as the
+// only row of queries, after conversions of database/sql.
+type fakeDriver struct{}
+
+var lastArgs []driver.Value
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(q string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                          { return nil }
+func (fakeConn) Begin() (driver.Tx, error)             { return nil, errors.New("no transactions") }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	lastArgs = args
+	return driver.RowsAffected(1), nil
+}
+
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{row: lastArgs}, nil
+}
+
+type fakeRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return strings.Split(MessageColumns, ", ") }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+func TestInsertScan(t *testing.T) {
+	db, err := sql.Open("fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := uint64(math.MaxUint64)
+	for _, in := range []Message{
+		{Id: math.MaxUint64, Body: "hi", Level: LEVEL_HIGH, Tags: []string{"a", "b"}},
+		{Id: 1, Payload: []byte{}, Count: &count},
+		{Payload: []byte{0, 1}, Meta: struct{ Author string }{"bob"}},
+	} {
+		q, args, err := in.InsertSQL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "INSERT INTO messages (id, body, payload, level, tags, count, meta_by) VALUES ($1, $2, $3, $4, $5, $6, $7)"; q != want {
+			t.Errorf("got %s, want %s", q, want)
+		}
+		if _, err := db.Exec(q, args...); err != nil {
+			t.Fatalf("%+v: %v", in, err)
+		}
+
+		var out Message
+		if err := out.Scan(db.QueryRow("SELECT " + MessageColumns + " FROM " + MessageTable)); err != nil {
+			t.Fatalf("%+v: %v", in, err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("got %+v, want %+v", out, in)
+		}
+	}
+}
+
+func TestUpdateSQL(t *testing.T) {
+	m := Message{Id: math.MaxUint64, Body: "hi", Meta: struct{ Author string }{"bob"}}
+	q, args, err := m.UpdateSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "UPDATE messages SET body = $1, payload = $2, level = $3, tags = $4, count = $5, meta_by = $6 WHERE id = $7"; q != want {
+		t.Errorf("got %s, want %s", q, want)
+	}
+	want := []interface{}{"hi", []byte(nil), LEVEL_LOW, "null", nil, "bob", "18446744073709551615"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("got %#v, want %#v", args, want)
+	}
+}
+`
+
+func TestSQL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated package")
+	}
+	goTest(t, generate(t, nil, sqlFile), sqlTest)
+}
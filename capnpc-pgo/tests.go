@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+// testCodec is a codec of generated tests with expressions of its marshal
+// and unmarshal function literals. Round trip test of the codec is skipped
+// with reason skip, if set.
+type testCodec struct {
+	name      string
+	imports   []string
+	marshal   string
+	unmarshal string
+	skip      string
+}
+
+// capnpRoundTrips reports whether Cap'n Proto translation keeps values of
+// struct n. Translators set every member of unions, so structs holding
+// unions lose them.
+func capnpRoundTrips(n *node, seen map[uint64]bool) bool {
+	if seen[n.Id()] {
+		return true
+	}
+	seen[n.Id()] = true
+
+	for _, f := range n.Struct().Fields().ToArray() {
+		if f.DiscriminantValue() != caps.FieldNoDiscriminant {
+			return false
+		}
+		if f.Which() == caps.FIELD_GROUP {
+			if !capnpRoundTrips(findNode(f.Group().TypeId()), seen) {
+				return false
+			}
+			continue
+		}
+
+		t := f.Slot().Type()
+		for t.Which() == caps.TYPE_LIST {
+			t = t.List().ElementType()
+		}
+		if t.Which() == caps.TYPE_STRUCT && !capnpRoundTrips(findNode(t.Struct().TypeId()), seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// testCodecs returns codecs of struct n checked by generated tests
+func (n *node) testCodecs() []testCodec {
+	var codecs []testCodec
+
+	if _, found := n.codecs[caps.CodecJson]; found {
+		codecs = append(codecs, testCodec{"json", []string{"encoding/json"},
+			fmt.Sprintf("func(z *%s) ([]byte, error) { return json.Marshal(z) }", n.name),
+			fmt.Sprintf("func(b []byte, z *%s) error { return json.Unmarshal(b, z) }", n.name), ""})
+	}
+	if _, found := n.codecs[caps.CodecMsgp]; found {
+		codecs = append(codecs, testCodec{"msgp", nil,
+			fmt.Sprintf("func(z *%s) ([]byte, error) { return z.MarshalMsg(nil) }", n.name),
+			fmt.Sprintf("func(b []byte, z *%s) error { _, err := z.UnmarshalMsg(b); return err }", n.name), ""})
+	}
+	if _, found := n.codecs[caps.CodecCapnp]; found {
+		c := testCodec{"capnp", []string{"bytes"},
+			fmt.Sprintf("func(z *%s) ([]byte, error) { var buf bytes.Buffer; err := z.Save(&buf); return buf.Bytes(), err }", n.name),
+			fmt.Sprintf("func(b []byte, z *%s) error { return z.Load(bytes.NewReader(b)) }", n.name), ""}
+		if !capnpRoundTrips(n, make(map[uint64]bool)) {
+			c.skip = "Cap'n Proto translators set every member of unions, values holding unions change"
+		}
+		codecs = append(codecs, c)
+	}
+	if _, found := n.codecs[caps.CodecCbor]; found {
+		codecs = append(codecs, testCodec{"cbor", nil,
+			fmt.Sprintf("func(z *%s) ([]byte, error) { return z.MarshalCBOR() }", n.name),
+			fmt.Sprintf("func(b []byte, z *%s) error { return z.UnmarshalCBOR(b) }", n.name), ""})
+	}
+	if _, found := n.codecs[caps.CodecCaplit]; found {
+		codecs = append(codecs, testCodec{"caplit", nil,
+			fmt.Sprintf("func(z *%s) ([]byte, error) { return z.MarshalCapLit() }", n.name),
+			fmt.Sprintf("func(b []byte, z *%s) error { return z.UnmarshalCapLit(b) }", n.name), ""})
+	}
+	return codecs
+}
+
+// codecTitle returns codec name used in names of fuzz targets
+func codecTitle(name string) string {
+	switch name {
+	case "json", "cbor":
+		return strings.ToUpper(name)
+	case "caplit":
+		return "CapLit"
+	}
+	return strings.Title(name)
+}
+
+// defineTests writes round trip test, fuzz targets and benchmarks of
+// struct n. Values come from RandomX with fixed seed, so failures repeat.
+func (n *node) defineTests(w *bytes.Buffer, imports map[string]bool) {
+	codecs := n.testCodecs()
+	if len(codecs) == 0 {
+		return
+	}
+
+	low := strings.ToLower(n.name[:1]) + n.name[1:]
+	codecType := low + "Codec"
+	codecsVar := low + "Codecs"
+
+	fmt.Fprintf(w, "type %s struct {\n", codecType)
+	fmt.Fprintf(w, "name string\n")
+	fmt.Fprintf(w, "marshal func(*%s) ([]byte, error)\n", n.name)
+	fmt.Fprintf(w, "unmarshal func([]byte, *%s) error\n", n.name)
+	fmt.Fprintf(w, "skip string // Reason to skip round trip\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "var %s = []%s{\n", codecsVar, codecType)
+	for _, c := range codecs {
+		for _, imp := range c.imports {
+			imports[imp] = true
+		}
+		fmt.Fprintf(w, "{%q, %s, %s, %q},\n", c.name, c.marshal, c.unmarshal, c.skip)
+	}
+	fmt.Fprintf(w, "}\n\n")
+
+	// Round trip
+	fmt.Fprintf(w, "func Test%sRoundTrip(t *testing.T) {\n", n.name)
+	fmt.Fprintf(w, "for _, c := range %s {\n", codecsVar)
+	fmt.Fprintf(w, "c := c\n")
+	fmt.Fprintf(w, "t.Run(c.name, func(t *testing.T) {\n")
+	fmt.Fprintf(w, "if c.skip != \"\" {\n")
+	fmt.Fprintf(w, "t.Skip(c.skip)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "r := rand.New(rand.NewSource(1))\n")
+	fmt.Fprintf(w, "for i := 0; i < 100; i++ {\n")
+	fmt.Fprintf(w, "want := Random%s(r)\n", n.name)
+	fmt.Fprintf(w, "data, err := c.marshal(&want)\n")
+	fmt.Fprintf(w, "if err != nil {\n")
+	fmt.Fprintf(w, "t.Fatalf(\"value %%d: marshal: %%v\", i, err)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "var got %s\n", n.name)
+	fmt.Fprintf(w, "if err := c.unmarshal(data, &got); err != nil {\n")
+	fmt.Fprintf(w, "t.Fatalf(\"value %%d: unmarshal: %%v\", i, err)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "if d := want.Diff(&got); len(d) != 0 {\n")
+	fmt.Fprintf(w, "t.Fatalf(\"value %%d: changed %%v\", i, d)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "})\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	// Fuzz targets
+	for i, c := range codecs {
+		fmt.Fprintf(w, "func Fuzz%s%s(f *testing.F) {\n", n.name, codecTitle(c.name))
+		fmt.Fprintf(w, "fuzz%s(f, %s[%d])\n", n.name, codecsVar, i)
+		fmt.Fprintf(w, "}\n\n")
+	}
+
+	fmt.Fprintf(w, "// fuzz%s feeds the decoder with mutated encodings of random values,\n", n.name)
+	fmt.Fprintf(w, "// it must return errors for malformed input instead of panicking.\n")
+	fmt.Fprintf(w, "func fuzz%s(f *testing.F, c %s) {\n", n.name, codecType)
+	fmt.Fprintf(w, "r := rand.New(rand.NewSource(1))\n")
+	fmt.Fprintf(w, "for i := 0; i < 10; i++ {\n")
+	fmt.Fprintf(w, "v := Random%s(r)\n", n.name)
+	fmt.Fprintf(w, "data, err := c.marshal(&v)\n")
+	fmt.Fprintf(w, "if err != nil {\n")
+	fmt.Fprintf(w, "f.Fatalf(\"value %%d: marshal: %%v\", i, err)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "f.Add(data)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "f.Fuzz(func(t *testing.T, data []byte) {\n")
+	fmt.Fprintf(w, "var v %s\n", n.name)
+	fmt.Fprintf(w, "c.unmarshal(data, &v)\n")
+	fmt.Fprintf(w, "})\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	// Benchmarks
+	fmt.Fprintf(w, "func Benchmark%sMarshal(b *testing.B) {\n", n.name)
+	fmt.Fprintf(w, "v := Random%s(rand.New(rand.NewSource(1)))\n", n.name)
+	fmt.Fprintf(w, "for _, c := range %s {\n", codecsVar)
+	fmt.Fprintf(w, "c := c\n")
+	fmt.Fprintf(w, "data, err := c.marshal(&v)\n")
+	fmt.Fprintf(w, "if err != nil {\n")
+	fmt.Fprintf(w, "b.Fatal(err)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "b.Run(c.name, func(b *testing.B) {\n")
+	fmt.Fprintf(w, "b.ReportAllocs()\n")
+	fmt.Fprintf(w, "b.SetBytes(int64(len(data)))\n")
+	fmt.Fprintf(w, "for i := 0; i < b.N; i++ {\n")
+	fmt.Fprintf(w, "if _, err := c.marshal(&v); err != nil {\n")
+	fmt.Fprintf(w, "b.Fatal(err)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "})\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "func Benchmark%sUnmarshal(b *testing.B) {\n", n.name)
+	fmt.Fprintf(w, "v := Random%s(rand.New(rand.NewSource(1)))\n", n.name)
+	fmt.Fprintf(w, "for _, c := range %s {\n", codecsVar)
+	fmt.Fprintf(w, "c := c\n")
+	fmt.Fprintf(w, "data, err := c.marshal(&v)\n")
+	fmt.Fprintf(w, "if err != nil {\n")
+	fmt.Fprintf(w, "b.Fatal(err)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "b.Run(c.name, func(b *testing.B) {\n")
+	fmt.Fprintf(w, "b.ReportAllocs()\n")
+	fmt.Fprintf(w, "b.SetBytes(int64(len(data)))\n")
+	fmt.Fprintf(w, "for i := 0; i < b.N; i++ {\n")
+	fmt.Fprintf(w, "var z %s\n", n.name)
+	fmt.Fprintf(w, "if err := c.unmarshal(data, &z); err != nil {\n")
+	fmt.Fprintf(w, "b.Fatal(err)\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "})\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "}\n\n")
+}
+
+// writeTests writes <model>_test.go with tests of structs of file f. The
+// file is left out if none of the structs has a codec to test.
+func writeTests(filename string, f *node) {
+	var buf bytes.Buffer
+	imports := map[string]bool{"math/rand": true, "testing": true}
+
+	for _, n := range f.nodes {
+		if n.Which() == caps.NODE_STRUCT && !n.Struct().IsGroup() {
+			n.defineTests(&buf, imports)
+		}
+	}
+	if buf.Len() == 0 {
+		return
+	}
+
+	var imps []string
+	for imp := range imports {
+		imps = append(imps, imp)
+	}
+	sort.Strings(imps)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "package %s\n\n", f.pkg)
+	fmt.Fprintf(&out, "// AUTO GENERATED - DO NOT EDIT\n\n")
+	fmt.Fprintf(&out, "import (\n")
+	for _, imp := range imps {
+		fmt.Fprintf(&out, "%q\n", imp)
+	}
+	fmt.Fprintf(&out, ")\n\n")
+	out.Write(buf.Bytes())
+
+	clean, err := format.Source(out.Bytes())
+	assert(err == nil, "%v\n", err)
+	err = ioutil.WriteFile(filename, clean, 0644)
+	assert(err == nil, "%v\n", err)
+}
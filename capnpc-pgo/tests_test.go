@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tpukep/caps"
+)
+
+const (
+	noteFileID = 0xe1a5c0ffee000600 + iota
+	noteID
+)
+
+// noteFile holds:
+//
+//	struct Note {
+//	  text @0 :Text;
+//	  union { draft @1 :Void; pages @2 :Int64; }
+//	}
+func noteFile(codecs ...uint64) testFile {
+	anns := []testAnnotation{goPackage("gentest")}
+	for _, c := range codecs {
+		anns = append(anns, testAnnotation{id: c})
+	}
+	return testFile{id: noteFileID, name: "note.capnp", anns: anns,
+		nodes: []testNode{
+			{id: noteID, name: "Note", data: 2, ptrs: 1, discCount: 2, fields: []testField{
+				{name: "text", typ: textType, disc: -1},
+				{name: "draft", typ: voidType, disc: 0},
+				{name: "pages", typ: int64Type, offset: 1, disc: 1},
+			}},
+		},
+	}
+}
+
+func TestGeneratedTestsSkipUnions(t *testing.T) {
+	out := readOutput(t, generate(t, []string{caps.TestsEnv + "=1"}, noteFile(caps.CodecCapnp, caps.CodecCaplit)), "note_test.go")
+
+	for _, want := range []string{
+		`{"capnp", func(z *Note) ([]byte, error) {`,
+		`"Cap'n Proto translators set every member of unions, values holding unions change"},`,
+		`t.Skip(c.skip)`,
+		`func FuzzNoteCapnp(f *testing.F) {`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %s in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `z.UnmarshalCapLit(b) }, "Cap'n Proto`) {
+		t.Errorf("round trip of caplit is skipped")
+	}
+}
+
+func TestGeneratedTests(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated package")
+	}
+	// Generated tests are the tests of the package
+	goTest(t, generate(t, []string{caps.TestsEnv + "=1"}, noteFile(caps.CodecCaplit, caps.CodecDiff)), "package gentest\n")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tpukep/bambam/bam"
+	"github.com/tpukep/caps"
+)
+
+// writeTranslators writes capnp translators of the structs. bambam skips
+// presence and custom type fields, their conversions are added to the
+// translator functions here.
+func writeTranslators(w io.Writer, x *bam.Extractor, nodes []*node) {
+	var buf bytes.Buffer
+	_, err := x.WriteToTranslators(&buf)
+	assert(err == nil, "%v\n", err)
+
+	code := buf.String()
+	for _, n := range nodes {
+		if n.Which() != caps.NODE_STRUCT || n.Struct().IsGroup() {
+			continue
+		}
+
+		var toGo, toCapn bytes.Buffer
+		n.defineFieldTranslators(&toGo, &toCapn, "", "")
+		code = insertBeforeReturn(code, fmt.Sprintf("func %sCapnToGo(", n.name), toGo.String())
+		code = insertBeforeReturn(code, fmt.Sprintf("func %sGoToCapn(", n.name), toCapn.String())
+	}
+
+	io.WriteString(w, code)
+}
+
+// insertBeforeReturn inserts stmts before the return statement of function
+// starting with header. Union structs have no translators, the code is left
+// as is.
+func insertBeforeReturn(code, header, stmts string) string {
+	i := strings.Index(code, header)
+	if stmts == "" || i == -1 {
+		return code
+	}
+
+	j := strings.Index(code[i:], "\n  return dest\n}")
+	assert(j != -1, "missing return of %s", header)
+	j += i + 1
+
+	return code[:j] + stmts + code[j:]
+}
+
+// defineFieldTranslators writes statements converting presence and custom
+// type fields. Members of groups are reached through the group path.
+func (n *node) defineFieldTranslators(toGo, toCapn io.Writer, goPath, capnPath string) {
+	for _, f := range n.codeOrderFields() {
+		name := goFieldName(f)
+
+		if f.Which() == caps.FIELD_GROUP {
+			findNode(f.Group().TypeId()).defineFieldTranslators(toGo, toCapn, goPath+name+".", capnPath+name+"().")
+			continue
+		}
+
+		if c := fieldCustomType(n, f); c != nil {
+			fmt.Fprintf(toGo, "  dest.%s%s = %s\n", goPath, name, c.fromWire("src."+capnPath+name+"()"))
+			fmt.Fprintf(toCapn, "  dest.%sSet%s(%s)\n", capnPath, name, c.toWire("src."+goPath+name))
+		} else if n.hasPresence(f) {
+			fmt.Fprintf(toGo, "  if v := src.%s%s(); v != %s {\n    dest.%s%s = &v\n  }\n",
+				capnPath, name, presenceDefault(f.Slot()), goPath, name)
+			fmt.Fprintf(toCapn, "  if src.%s%s != nil {\n    dest.%sSet%s(*src.%s%s)\n  }\n",
+				goPath, name, capnPath, name, goPath, name)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tpukep/caps"
+)
+
+// tsWriter writes TypeScript definitions matching JSON encoding of the
+// generated Go structs.
+type tsWriter struct {
+	buf     bytes.Buffer
+	file    *node
+	imports map[string]map[string]bool
+}
+
+func (t *tsWriter) typeRef(n *node) string {
+	if f := fileOf(n); f != nil && f != t.file {
+		path := strings.TrimSuffix(f.DisplayName(), ".capnp")
+		rel, err := filepath.Rel(filepath.Dir(t.file.DisplayName()), path)
+		assert(err == nil, "%v\n", err)
+		if !strings.HasPrefix(rel, ".") {
+			rel = "./" + rel
+		}
+
+		if t.imports[rel] == nil {
+			t.imports[rel] = make(map[string]bool)
+		}
+		t.imports[rel][n.name] = true
+	}
+	return n.name
+}
+
+func (t *tsWriter) typeName(tp caps.Type) string {
+	switch tp.Which() {
+	case caps.TYPE_BOOL:
+		return "boolean"
+	case caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_INT32, caps.TYPE_INT64,
+		caps.TYPE_UINT8, caps.TYPE_UINT16, caps.TYPE_UINT32, caps.TYPE_UINT64,
+		caps.TYPE_FLOAT32, caps.TYPE_FLOAT64:
+		return "number"
+	case caps.TYPE_TEXT:
+		return "string"
+	case caps.TYPE_DATA:
+		// base64 encoded, nil slices are written as null
+		return "string | null"
+	case caps.TYPE_ENUM:
+		return t.typeRef(findNode(tp.Enum().TypeId()))
+	case caps.TYPE_STRUCT:
+		return t.typeRef(findNode(tp.Struct().TypeId()))
+	case caps.TYPE_LIST:
+		et := tp.List().ElementType()
+		if et.Which() == caps.TYPE_UINT8 {
+			// []uint8 is marshalled by encoding/json the same way as []byte
+			return "string | null"
+		}
+		if en := t.typeName(et); strings.ContainsAny(en, " |&") {
+			return "Array<" + en + "> | null"
+		} else {
+			return en + "[] | null"
+		}
+	}
+	return "unknown"
+}
+
+func (t *tsWriter) member(n *node, f caps.Field, indent string) string {
+	name := n.jsonName(f)
+
+	var tp string
+	if f.Which() == caps.FIELD_GROUP {
+		tp = t.structType(findNode(f.Group().TypeId()), indent)
+	} else if semanticSchema(f) != nil {
+		tp = "string"
+	} else {
+		tp = t.typeName(f.Slot().Type())
+	}
+
+	opt := ""
+	if codecField(f).Optional {
+		opt = "?"
+	}
+
+	var b bytes.Buffer
+	if doc := docAnnotation(f.Annotations()); doc != "" {
+		fmt.Fprintf(&b, "%s/** %s */\n", indent, doc)
+	}
+	fmt.Fprintf(&b, "%s%s%s: %s;\n", indent, tsKey(name), opt, tp)
+	return b.String()
+}
+
+func tsKey(name string) string {
+	for i, r := range name {
+		if !(r == '_' || r == '$' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || i > 0 && '0' <= r && r <= '9') {
+			return strconv.Quote(name)
+		}
+	}
+	return name
+}
+
+// structType returns TypeScript type expression of the struct. Members of
+// union of jsonDescribed struct are left to unionTypes, other structs and
+// groups hold every union member, so all of them are written.
+func (t *tsWriter) structType(n *node, indent string) string {
+	var b bytes.Buffer
+
+	union := n.describedUnion()
+
+	fmt.Fprintf(&b, "{\n")
+	for _, f := range n.schemaFields() {
+		if n.jsonName(f) == "" || union && f.DiscriminantValue() != caps.FieldNoDiscriminant {
+			continue
+		}
+		b.WriteString(t.member(n, f, indent+"  "))
+	}
+	fmt.Fprintf(&b, "%s}", indent)
+
+	return b.String()
+}
+
+// unionTypes returns alternatives of union of jsonDescribed struct n, one
+// for each member in which it is present and the others are never present.
+// Void members have no Go field, so they share an alternative without any
+// member.
+func (t *tsWriter) unionTypes(n *node, indent string) []string {
+	if !n.describedUnion() {
+		return nil
+	}
+
+	var members []caps.Field
+	void := false
+	for _, f := range n.unionMembers() {
+		if !hasGoField(f) {
+			void = true
+		} else if n.jsonName(f) != "" {
+			members = append(members, f)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	alt := func(present int) string {
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "{\n")
+		for i, f := range members {
+			if i == present {
+				b.WriteString(t.member(n, f, indent+"  "))
+			} else {
+				fmt.Fprintf(&b, "%s  %s?: never;\n", indent, tsKey(n.jsonName(f)))
+			}
+		}
+		fmt.Fprintf(&b, "%s}", indent)
+		return b.String()
+	}
+
+	var alts []string
+	for i := range members {
+		alts = append(alts, alt(i))
+	}
+	if void {
+		alts = append(alts, alt(-1))
+	}
+	return alts
+}
+
+func (t *tsWriter) define(n *node) {
+	if doc := docAnnotation(n.Annotations()); doc != "" {
+		fmt.Fprintf(&t.buf, "/** %s */\n", doc)
+	}
+
+	switch n.Which() {
+	case caps.NODE_ENUM:
+		var values []string
+		for _, v := range n.enumValues() {
+			if s, ok := v.(string); ok {
+				values = append(values, strconv.Quote(s))
+			} else {
+				values = append(values, strconv.Itoa(v.(int)))
+			}
+		}
+		if len(values) == 0 {
+			values = append(values, "never")
+		}
+		fmt.Fprintf(&t.buf, "export type %s = %s;\n\n", n.name, strings.Join(values, " | "))
+
+	case caps.NODE_STRUCT:
+		if alts := t.unionTypes(n, ""); len(alts) > 0 {
+			// Interfaces can't extend unions
+			fmt.Fprintf(&t.buf, "export type %s = %s & (%s);\n\n", n.name, t.structType(n, ""), strings.Join(alts, " | "))
+		} else {
+			fmt.Fprintf(&t.buf, "export interface %s %s\n\n", n.name, t.structType(n, ""))
+		}
+	}
+}
+
+// writeTypeScript writes TypeScript types for structs and enums of the file.
+func writeTypeScript(filename string, file *node) {
+	t := &tsWriter{file: file, imports: make(map[string]map[string]bool)}
+
+	for _, n := range file.nodes {
+		t.define(n)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// AUTO GENERATED - DO NOT EDIT\n\n")
+
+	if len(t.imports) > 0 {
+		var paths []string
+		for path := range t.imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			var names []string
+			for name := range t.imports[path] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintf(&out, "import type { %s } from %q;\n", strings.Join(names, ", "), path)
+		}
+		fmt.Fprintf(&out, "\n")
+	}
+
+	out.Write(bytes.TrimRight(t.buf.Bytes(), "\n"))
+	out.WriteByte('\n')
+
+	err := ioutil.WriteFile(filename, out.Bytes(), 0644)
+	assert(err == nil, "%v\n", err)
+}
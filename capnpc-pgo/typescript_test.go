@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tpukep/caps"
+)
+
+func TestTypeScript(t *testing.T) {
+	file := shapeFile
+	file.anns = []testAnnotation{goPackage("gentest"), {id: caps.CodecJson}, {id: caps.CodecTypescript}}
+	out := readOutput(t, generate(t, nil, file, pointFile), "shape.ts")
+
+	want := `// AUTO GENERATED - DO NOT EDIT
+
+import type { Point } from "./point";
+
+export type Shape = {
+  color: Color;
+  image: string | null;
+  tags: string[] | null;
+  origin: Point;
+} & ({
+  circle: number;
+  square?: never;
+} | {
+  circle?: never;
+  square: string;
+} | {
+  circle?: never;
+  square?: never;
+});
+
+export type Color = "rot" | "green" | 2;
+`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
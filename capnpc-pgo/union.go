@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tpukep/caps"
+)
+
+// Plain structs don't track discriminant of unions, so the member that is
+// set is the first one with non zero value. If every member is zero, it is
+// the member with discriminant 0, as in a new Cap'n Proto struct.
+
+// unionMembers returns members of the union of struct n in discriminant
+// order.
+func (n *node) unionMembers() []caps.Field {
+	var members []caps.Field
+	for _, f := range n.codeOrderFields() {
+		if f.DiscriminantValue() != caps.FieldNoDiscriminant {
+			members = append(members, f)
+		}
+	}
+	sort.Sort(byDiscriminant(members))
+	return members
+}
+
+type byDiscriminant []caps.Field
+
+func (s byDiscriminant) Len() int { return len(s) }
+func (s byDiscriminant) Less(i, j int) bool {
+	return s[i].DiscriminantValue() < s[j].DiscriminantValue()
+}
+func (s byDiscriminant) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// hasGoField reports whether the field is present in generated struct.
+func hasGoField(f caps.Field) bool {
+	if f.Which() == caps.FIELD_SLOT {
+		switch f.Slot().Type().Which() {
+		case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+			return false
+		}
+	}
+	return true
+}
+
+// nonZeroCond returns condition of expr, value of union member f, not being
+// zero.
+func nonZeroCond(expr string, f caps.Field) string {
+	if f.Which() == caps.FIELD_GROUP {
+		g_imported[SELF_IMPORT] = true
+		return fmt.Sprintf("!caps.IsZero(%s)", expr)
+	}
+
+	switch t := f.Slot().Type(); t.Which() {
+	case caps.TYPE_BOOL:
+		return expr
+	case caps.TYPE_TEXT:
+		return expr + " != \"\""
+	case caps.TYPE_DATA, caps.TYPE_LIST:
+		return "len(" + expr + ") > 0"
+	case caps.TYPE_ANYPOINTER:
+		return expr + " != nil"
+	case caps.TYPE_STRUCT:
+		g_imported[SELF_IMPORT] = true
+		return fmt.Sprintf("!caps.IsZero(%s)", expr)
+	}
+	return expr + " != 0"
+}
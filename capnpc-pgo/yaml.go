@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tpukep/caps"
+)
+
+// defineEnumSetString writes the method decoders of the enum share. It
+// accepts tags and numbers, so values without tags round trip.
+func (n *node) defineEnumSetString(w io.Writer, ev []enumval) {
+	g_imported["fmt"] = true
+	g_imported["strconv"] = true
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "func (c *%s) setString(s string) error {\n", n.name)
+	fmt.Fprintf(w, "switch s {\n")
+	for _, e := range ev {
+		if e.tag != "" {
+			fmt.Fprintf(w, "case \"%s\": *c = %s\n", e.tag, e.fullName())
+		}
+	}
+	fmt.Fprintf(w, "default:\n")
+	fmt.Fprintf(w, "v, err := strconv.ParseUint(s, 10, 16)\n")
+	fmt.Fprintf(w, "if err != nil { return fmt.Errorf(\"invalid %s value %%q\", s) }\n", n.name)
+	fmt.Fprintf(w, "*c = %s(v)\n", n.name)
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "return nil\n")
+	fmt.Fprintf(w, "}\n")
+}
+
+// defineEnumYAML makes enum encode as its tag in YAML. Values without tags
+// are written as numbers.
+func (n *node) defineEnumYAML(w io.Writer) {
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "func (c %s) MarshalYAML() (interface{}, error) {\n", n.name)
+	fmt.Fprintf(w, "if s := c.String(); s != \"\" { return s, nil }\n")
+	fmt.Fprintf(w, "return uint16(c), nil\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "func (c *%s) UnmarshalYAML(unmarshal func(interface{}) error) error {\n", n.name)
+	fmt.Fprintf(w, "var s string\n")
+	fmt.Fprintf(w, "if err := unmarshal(&s); err != nil { return err }\n")
+	fmt.Fprintf(w, "return c.setString(s)\n")
+	fmt.Fprintf(w, "}\n")
+}
+
+// defineEnumTOML makes enum encode as its tag with github.com/BurntSushi/toml.
+// Values without tags are written as numbers.
+func (n *node) defineEnumTOML(w io.Writer) {
+	g_imported["fmt"] = true
+	g_imported["strconv"] = true
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "func (c %s) MarshalTOML() ([]byte, error) {\n", n.name)
+	fmt.Fprintf(w, "if s := c.String(); s != \"\" { return []byte(strconv.Quote(s)), nil }\n")
+	fmt.Fprintf(w, "return []byte(strconv.FormatUint(uint64(c), 10)), nil\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "func (c *%s) UnmarshalTOML(v interface{}) error {\n", n.name)
+	fmt.Fprintf(w, "switch v := v.(type) {\n")
+	fmt.Fprintf(w, "case string: return c.setString(v)\n")
+	fmt.Fprintf(w, "case int64: return c.setString(strconv.FormatInt(v, 10))\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "return fmt.Errorf(\"invalid %s value %%v\", v)\n", n.name)
+	fmt.Fprintf(w, "}\n")
+}
+
+// defineYAMLMethods writes YAML (un)marshalers encoding Data fields of the
+// struct as base64 text. The fields themselves are tagged with `yaml:"-"`.
+func (n *node) defineYAMLMethods(w io.Writer) {
+	type dataField struct {
+		goName string
+		fc     caps.FieldCodec
+	}
+
+	var fields []dataField
+	for _, f := range n.codeOrderFields() {
+		if f.Which() != caps.FIELD_SLOT || f.Slot().Type().Which() != caps.TYPE_DATA {
+			continue
+		}
+		if fieldCustomType(n, f) != nil {
+			continue
+		}
+		if fc := codecField(f); !fc.Ignored {
+			fields = append(fields, dataField{goFieldName(f), fc})
+		}
+	}
+
+	if len(fields) == 0 {
+		return
+	}
+
+	g_imported["encoding/base64"] = true
+
+	fmt.Fprintf(w, "func (s %s) MarshalYAML() (interface{}, error) {\n", n.name)
+	fmt.Fprintf(w, "type plain %s\n", n.name)
+	fmt.Fprintf(w, "return struct {\n")
+	fmt.Fprintf(w, "plain `yaml:\",inline\"`\n")
+	for _, f := range fields {
+		if f.fc.Optional {
+			fmt.Fprintf(w, "%s string `yaml:\"%s,omitempty\"`\n", f.goName, f.fc.Name)
+		} else {
+			fmt.Fprintf(w, "%s string `yaml:\"%s\"`\n", f.goName, f.fc.Name)
+		}
+	}
+	fmt.Fprintf(w, "}{\n")
+	fmt.Fprintf(w, "plain(s),\n")
+	for _, f := range fields {
+		fmt.Fprintf(w, "base64.StdEncoding.EncodeToString(s.%s),\n", f.goName)
+	}
+	fmt.Fprintf(w, "}, nil\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "func (s *%s) UnmarshalYAML(unmarshal func(interface{}) error) error {\n", n.name)
+	fmt.Fprintf(w, "type plain %s\n", n.name)
+	fmt.Fprintf(w, "var v struct {\n")
+	fmt.Fprintf(w, "plain `yaml:\",inline\"`\n")
+	for _, f := range fields {
+		fmt.Fprintf(w, "%s string `yaml:\"%s\"`\n", f.goName, f.fc.Name)
+	}
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "if err := unmarshal(&v); err != nil { return err }\n")
+	fmt.Fprintf(w, "*s = %s(v.plain)\n", n.name)
+	for _, f := range fields {
+		fmt.Fprintf(w, "if v.%s != \"\" {\n", f.goName)
+		fmt.Fprintf(w, "data, err := base64.StdEncoding.DecodeString(v.%s)\n", f.goName)
+		fmt.Fprintf(w, "if err != nil { return err }\n")
+		fmt.Fprintf(w, "s.%s = data\n", f.goName)
+		fmt.Fprintf(w, "}\n")
+	}
+	fmt.Fprintf(w, "return nil\n")
+	fmt.Fprintf(w, "}\n\n")
+}
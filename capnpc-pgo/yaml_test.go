@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+const (
+	configFileID = 0xe1a5c0ffee000300 + iota
+	configID
+	configModeID
+	configBlobID
+)
+
+// configFile holds:
+//
+//	struct Config {
+//	  name  @0 :Text;
+//	  mode  @1 :Mode;
+//	  key   @2 :Data;
+//	  blobs @3 :List(Blob);
+//	}
+//	enum Mode { fast @0 $Go.tag("quick"); safe @1; debug @2 $Go.notag; }
+//	struct Blob { data @0 :Data; }
+var configFile = testFile{id: configFileID, name: "config.capnp",
+	anns: []testAnnotation{goPackage("gentest"), {id: caps.CodecYaml}, {id: caps.CodecToml}},
+	nodes: []testNode{
+		{id: configID, name: "Config", data: 1, ptrs: 3, fields: []testField{
+			{name: "name", typ: textType, disc: -1},
+			{name: "mode", typ: enumTypeOf(configModeID), disc: -1},
+			{name: "key", typ: dataType, offset: 1, disc: -1},
+			{name: "blobs", typ: listTypeOf(structTypeOf(configBlobID)), offset: 2, disc: -1},
+		}},
+		{id: configModeID, name: "Mode", enumerants: []string{"fast", "safe", "debug"},
+			enumAnns: [][]testAnnotation{{{id: C.Tag, text: "quick"}}, nil, {{id: C.Notag}}}},
+		{id: configBlobID, name: "Blob", ptrs: 1, fields: []testField{
+			{name: "data", typ: dataType, disc: -1},
+		}},
+	},
+}
+
+func TestEnumTextCodecs(t *testing.T) {
+	out := readOutput(t, generate(t, nil, configFile), "config.go")
+
+	for _, method := range []string{"MarshalYAML", "UnmarshalYAML", "MarshalTOML", "UnmarshalTOML"} {
+		if !strings.Contains(out, "func (c Mode) "+method) && !strings.Contains(out, "func (c *Mode) "+method) {
+			t.Errorf("no Mode.%s", method)
+		}
+	}
+	// encoding/json keeps writing enums as numbers
+	for _, method := range []string{"MarshalText", "UnmarshalText", "MarshalJSON", "UnmarshalJSON"} {
+		if strings.Contains(out, method) {
+			t.Errorf("%s is generated", method)
+		}
+	}
+}
+
+const yamlTest = `package gentest
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestYAML(t *testing.T) {
+	in := Config{Name: "a", Mode: MODE_FAST, Key: []byte{0, 1, 2}, Blobs: []Blob{{Data: []byte("xyz")}, {}}}
+	b, err := yaml.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"mode: quick", "key: AAEC", "data: eHl6"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("no %q in\n%s", want, b)
+		}
+	}
+	var out Config
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("%+v != %+v", out, in)
+	}
+
+	// values without tags are numbers
+	for mode, text := range map[Mode]string{MODE_SAFE: "safe", MODE_DEBUG: "2", Mode(7): "7"} {
+		b, err := yaml.Marshal(Config{Mode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), "mode: "+text+"\n") {
+			t.Errorf("%d is not written as %s:\n%s", mode, text, b)
+		}
+		var c Config
+		if err := yaml.Unmarshal(b, &c); err != nil || c.Mode != mode {
+			t.Errorf("%s is read as %d: %v", text, c.Mode, err)
+		}
+	}
+	var c Config
+	if err := yaml.Unmarshal([]byte("mode: slow"), &c); err == nil {
+		t.Errorf("unknown mode is read as %d", c.Mode)
+	}
+
+	if b, _ := json.Marshal(in); !strings.Contains(string(b), "\"Mode\":0") {
+		t.Errorf("JSON changed: %s", b)
+	}
+}
+`
+
+func TestYAML(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated package")
+	}
+	goTest(t, generate(t, nil, configFile), yamlTest, "gopkg.in/yaml.v2")
+}
+
+const tomlTest = `package gentest
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestTOML(t *testing.T) {
+	in := Config{Name: "a", Mode: MODE_FAST, Key: []byte{0, 1, 2}, Blobs: []Blob{{Data: []byte("xyz")}, {}}}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"mode = \"quick\"", "key = [0, 1, 2]", "data = [120, 121, 122]"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("no %q in\n%s", want, buf.String())
+		}
+	}
+	var out Config
+	if _, err := toml.Decode(buf.String(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("%+v != %+v", out, in)
+	}
+
+	// values without tags are numbers
+	for mode, text := range map[Mode]string{MODE_SAFE: "\"safe\"", MODE_DEBUG: "2", Mode(7): "7"} {
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(Config{Mode: mode}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "mode = "+text+"\n") {
+			t.Errorf("%d is not written as %s:\n%s", mode, text, buf.String())
+		}
+		var c Config
+		if _, err := toml.Decode(buf.String(), &c); err != nil || c.Mode != mode {
+			t.Errorf("%s is read as %d: %v", text, c.Mode, err)
+		}
+	}
+	var c Config
+	if _, err := toml.Decode("mode = \"slow\"", &c); err == nil {
+		t.Errorf("unknown mode is read as %d", c.Mode)
+	}
+}
+`
+
+func TestTOML(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated package")
+	}
+	goTest(t, generate(t, nil, configFile), tomlTest, "github.com/BurntSushi/toml")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/tpukep/caps/compat"
+	"github.com/tpukep/caps/dynamic"
+)
+
+// compileGitSchema compiles the schema file as of git revision rev. The
+// old version is written next to the file, so relative imports resolve.
+func compileGitSchema(source, rev string, verbose bool) (*dynamic.Schema, error) {
+	dir, base := filepath.Split(source)
+
+	cmd := exec.Command("git", "show", rev+":./"+base)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s of %s: %v", base, rev, err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".caps-compat-*.capnp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return compileSchema(tmp.Name(), verbose)
+}
+
+func compatCmd(args []string) {
+	fs := flag.NewFlagSet("compat", flag.ExitOnError)
+	rev := fs.String("git", "", "compare with the schema file as of git revision")
+	verbose := fs.Bool("verbose", false, "verbose mode")
+	fs.Parse(args)
+
+	if (*rev == "" && fs.NArg() != 2) || (*rev != "" && fs.NArg() != 1) {
+		fmt.Fprintf(os.Stderr, "\nuse: caps compat <old.capnp> <new.capnp>\n")
+		fmt.Fprintf(os.Stderr, "     caps compat -git=<revision> <model.capnp>\n")
+		fmt.Fprintf(os.Stderr, "     # Reports changes of the schema breaking Cap'n Proto, JSON or msgp compatibility.\n")
+		fmt.Fprintf(os.Stderr, "     # Exits with status 1 if there are breaking changes.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		os.Exit(1)
+	}
+
+	var old *dynamic.Schema
+	var err error
+	if *rev != "" {
+		old, err = compileGitSchema(fs.Arg(0), *rev, *verbose)
+	} else {
+		old, err = compileSchema(fs.Arg(0), *verbose)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	new, err := compileSchema(fs.Arg(fs.NArg()-1), *verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	changes := compat.Compare(old, new)
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+
+	if compat.Breaking(changes) {
+		os.Exit(1)
+	}
+}
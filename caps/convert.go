@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+	"github.com/tpukep/caps/dynamic"
+)
+
+// Message formats of convert command
+var convertFormats = map[string]bool{"capnp": true, "packed": true, "msgp": true, "json": true}
+
+// messageReader returns function reading next message of the format. It
+// returns io.EOF at the end of input.
+func messageReader(schema *dynamic.Schema, root caps.Node, format string, r io.Reader) func() (*dynamic.Struct, error) {
+	switch format {
+	case "capnp", "packed":
+		if format == "packed" {
+			r = C.NewDecompressor(r)
+		}
+		return func() (*dynamic.Struct, error) {
+			return schema.ReadMessage(r, root, false)
+		}
+
+	case "msgp":
+		var data []byte
+		var err error
+		read := false
+		return func() (*dynamic.Struct, error) {
+			if !read {
+				data, err = ioutil.ReadAll(r)
+				read = true
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, io.EOF
+			}
+
+			var msg *dynamic.Struct
+			msg, data, err = schema.ReadMsgp(root, data)
+			return msg, err
+		}
+	}
+
+	dec := json.NewDecoder(r)
+	return func() (*dynamic.Struct, error) {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		return schema.ParseJSON(root, raw)
+	}
+}
+
+func writeMessage(schema *dynamic.Schema, msg *dynamic.Struct, format string, w io.Writer) error {
+	switch format {
+	case "capnp", "packed":
+		return schema.WriteMessage(w, msg, format == "packed")
+	case "msgp":
+		_, err := w.Write(schema.AppendMsgp(nil, msg))
+		return err
+	}
+
+	data, _ := msg.MarshalJSON()
+	_, err := w.Write(append(data, '\n'))
+	return err
+}
+
+func convert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	source := fs.String("source", "", "specify schema file")
+	typ := fs.String("type", "", "specify root struct name")
+	from := fs.String("from", "", "input format: capnp, packed, msgp or json")
+	to := fs.String("to", "", "output format: capnp, packed, msgp or json")
+	stream := fs.Bool("stream", false, "convert all messages until end of input")
+	verbose := fs.Bool("verbose", false, "verbose mode")
+	fs.Parse(args)
+
+	if *source == "" || *typ == "" || *from == "" || *to == "" {
+		fmt.Fprintf(os.Stderr, "\nuse: caps convert -source=<model.capnp> -type=<Struct> -from=<format> -to=<format> [-stream] < input\n")
+		fmt.Fprintf(os.Stderr, "     # Converts messages read from stdin between formats: capnp, packed, msgp and json.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		os.Exit(1)
+	}
+
+	for _, format := range []string{*from, *to} {
+		if !convertFormats[format] {
+			fmt.Fprintln(os.Stderr, "Unknown message format:", format)
+			os.Exit(1)
+		}
+	}
+
+	schema, err := compileSchema(*source, *verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	root, err := schema.Struct(*typ)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	next := messageReader(schema, root, *from, bufio.NewReader(os.Stdin))
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	for count := 0; ; count++ {
+		msg, err := next()
+		// Stream may be empty, single message is required
+		if err == io.EOF && *stream {
+			break
+		}
+		if err != nil {
+			w.Flush()
+			fmt.Fprintf(os.Stderr, "Failed to read message %d: %v\n", count, err)
+			os.Exit(1)
+		}
+
+		if err := writeMessage(schema, msg, *to, w); err != nil {
+			w.Flush()
+			fmt.Fprintln(os.Stderr, "Failed to write message:", err)
+			os.Exit(1)
+		}
+
+		if !*stream {
+			break
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/tpukep/caps/dynamic"
+)
+
+// compileSchema compiles the schema file with capnp tool and loads the
+// resulting CodeGeneratorRequest.
+func compileSchema(source string, verbose bool) (*dynamic.Schema, error) {
+	includes, err := capnpIncludes()
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command("capnp", append(includes, "compile", "-o-", source)...)
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = &out
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Executing: %q\n", strings.Join(cmd.Args, " "))
+	}
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Failed to compile schema: %v", err)
+	}
+
+	return dynamic.Load(&out)
+}
+
+func decode(args []string) {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	source := fs.String("source", "", "specify schema file")
+	typ := fs.String("type", "", "specify root struct name")
+	packed := fs.Bool("packed", false, "read packed message")
+	format := fs.String("format", "json", "output format: json or caplit")
+	verbose := fs.Bool("verbose", false, "verbose mode")
+	fs.Parse(args)
+
+	if *source == "" || *typ == "" {
+		fmt.Fprintf(os.Stderr, "\nuse: caps decode -source=<model.capnp> -type=<Struct> [-packed] [-format=json|caplit] < message\n")
+		fmt.Fprintf(os.Stderr, "     # Decodes Cap'n Proto message read from stdin using the schema.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		os.Exit(1)
+	}
+
+	if *format != "json" && *format != "caplit" {
+		fmt.Println("Unknown output format:", *format)
+		os.Exit(1)
+	}
+
+	schema, err := compileSchema(*source, *verbose)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	root, err := schema.Struct(*typ)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	msg, err := schema.ReadMessage(os.Stdin, root, *packed)
+	if err != nil {
+		fmt.Println("Failed to decode message:", err)
+		os.Exit(1)
+	}
+
+	var out []byte
+	if *format == "json" {
+		out = msg.IndentJSON()
+	} else {
+		out, _ = msg.AppendCapLit(nil)
+	}
+	os.Stdout.Write(append(out, '\n'))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+func encode(args []string) {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	source := fs.String("source", "", "specify schema file")
+	typ := fs.String("type", "", "specify root struct name")
+	packed := fs.Bool("packed", false, "write packed message")
+	format := fs.String("format", "capnp", "output format: capnp or msgp")
+	verbose := fs.Bool("verbose", false, "verbose mode")
+	fs.Parse(args)
+
+	if *source == "" || *typ == "" {
+		fmt.Fprintf(os.Stderr, "\nuse: caps encode -source=<model.capnp> -type=<Struct> [-packed] [-format=capnp|msgp] < message.json\n")
+		fmt.Fprintf(os.Stderr, "     # Encodes JSON read from stdin as Cap'n Proto or MessagePack message using the schema.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		os.Exit(1)
+	}
+
+	if *format != "capnp" && *format != "msgp" {
+		fmt.Fprintln(os.Stderr, "Unknown output format:", *format)
+		os.Exit(1)
+	}
+
+	schema, err := compileSchema(*source, *verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	root, err := schema.Struct(*typ)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read input:", err)
+		os.Exit(1)
+	}
+
+	msg, err := schema.ParseJSON(root, data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid message:", err)
+		os.Exit(1)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if *format == "msgp" {
+		_, err = w.Write(schema.AppendMsgp(nil, msg))
+	} else {
+		err = schema.WriteMessage(w, msg, *packed)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to encode message:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/tpukep/caps/lint"
+)
+
+func lintCmd(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	rules := fs.String("rules", "", "override severities of rules, e.g. missing-doc=off,naming=error")
+	format := fs.String("format", "text", "output format: text or sarif")
+	fail := fs.String("fail", "error", "exit with status 1 on findings of this severity or higher: note, warning or error")
+	list := fs.Bool("list", false, "list rules and their default severities")
+	verbose := fs.Bool("verbose", false, "verbose mode")
+	fs.Parse(args)
+
+	if *list {
+		for _, r := range lint.Rules {
+			fmt.Printf("%-18s %-8s %s\n", r.ID, r.Severity, r.Description)
+		}
+		return
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "\nuse: caps lint [-rules=<rule>=<severity>,...] [-format=text|sarif] [-fail=note|warning|error] <model.capnp>...\n")
+		fmt.Fprintf(os.Stderr, "     caps lint -list\n")
+		fmt.Fprintf(os.Stderr, "     # Checks schemas for problems of code generation. Severities: off, note, warning, error.\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		os.Exit(1)
+	}
+
+	if *format != "text" && *format != "sarif" {
+		fmt.Fprintln(os.Stderr, "Unknown output format:", *format)
+		os.Exit(1)
+	}
+
+	cfg, err := lint.ParseConfig(*rules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid rules:", err)
+		os.Exit(1)
+	}
+
+	threshold, err := lint.ParseSeverity(*fail)
+	if err != nil || threshold == lint.Off {
+		fmt.Fprintln(os.Stderr, "Invalid fail severity:", *fail)
+		os.Exit(1)
+	}
+
+	var findings []lint.Finding
+	for _, source := range fs.Args() {
+		schema, err := compileSchema(source, *verbose)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		found := lint.Run(schema, cfg)
+		sources := make(map[string][]byte)
+		for i := range found {
+			src, read := sources[found[i].File]
+			if !read {
+				src, _ = ioutil.ReadFile(found[i].File)
+				sources[found[i].File] = src
+			}
+			found[i].Locate(src)
+		}
+		sort.SliceStable(found, func(i, j int) bool {
+			return found[i].File < found[j].File || found[i].File == found[j].File && found[i].Line < found[j].Line
+		})
+		findings = append(findings, found...)
+	}
+
+	if *format == "sarif" {
+		if err := lint.WriteSARIF(os.Stdout, findings); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		for _, f := range findings {
+			fmt.Println(f)
+		}
+	}
+
+	if lint.Max(findings) >= threshold {
+		os.Exit(1)
+	}
+}
@@ -9,15 +9,18 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/tpukep/bambam/bam"
+	"github.com/tpukep/caps"
 )
 
 var (
 	outdir  = flag.String("o", ".", "specify output directory")
 	source  = flag.String("source", "", "specify input schema file")
 	verbose = flag.Bool("verbose", false, "verbose mode")
+	tests   = flag.Bool("tests", false, "generate round trip tests, fuzz targets and benchmarks")
 	capnpRe = regexp.MustCompile("(?m)[\r\n]+^.*" + regexp.QuoteMeta(CAPNP_CODEC_SHORT) + "|" + regexp.QuoteMeta(CAPNP_CODEC) + ".*$")
 	msgpRe  = regexp.MustCompile("(?m)[\r\n]+^.*" + regexp.QuoteMeta(MSGP_CODEC_SHORT) + "|" + regexp.QuoteMeta(MSGP_CODEC) + ".*$")
 )
@@ -31,11 +34,17 @@ const (
 )
 
 func use() {
-	fmt.Fprintf(os.Stderr, "\nuse: caps -o <outdir> -source=<model.capnp>\n")
+	fmt.Fprintf(os.Stderr, "\nuse: caps -o <outdir> [-tests] -source=<model.capnp>\n")
+	fmt.Fprintf(os.Stderr, "     caps decode -source=<model.capnp> -type=<Struct> [-packed] [-format=json|caplit] < message\n")
+	fmt.Fprintf(os.Stderr, "     caps encode -source=<model.capnp> -type=<Struct> [-packed] [-format=capnp|msgp] < message.json\n")
+	fmt.Fprintf(os.Stderr, "     caps convert -source=<model.capnp> -type=<Struct> -from=<format> -to=<format> [-stream] < input\n")
+	fmt.Fprintf(os.Stderr, "     caps compat <old.capnp> <new.capnp> | -git=<revision> <model.capnp>\n")
+	fmt.Fprintf(os.Stderr, "     caps lint [-rules=<rule>=<severity>,...] [-format=text|sarif] [-fail=<severity>] <model.capnp>...\n")
 	fmt.Fprintf(os.Stderr, "     # Tool reads .capnp files and writes: go structs with json tags, capn'proto code, translation code, msgp code.\n")
 	fmt.Fprintf(os.Stderr, "     # options:\n")
 	fmt.Fprintf(os.Stderr, "     #   -o=\"outdir\" specifies the directory to write to (created if need be).\n")
 	fmt.Fprintf(os.Stderr, "     #   -verbose=true enables verbose mode \n")
+	fmt.Fprintf(os.Stderr, "     #   -tests=true writes <model>_test.go with round trip tests, fuzz targets and benchmarks.\n")
 	fmt.Fprintf(os.Stderr, "     # required:\n")
 	fmt.Fprintf(os.Stderr, "     #   -source=model.capnp specifies input schema file\n")
 	fmt.Fprintf(os.Stderr, "     #\n")
@@ -43,7 +52,41 @@ func use() {
 	os.Exit(1)
 }
 
+// capnpIncludes returns include path options of capnp tool to find
+// caps and go-capnproto schemas.
+func capnpIncludes() ([]string, error) {
+	pkg, err := build.Import(SELF_PKG_NAME, "./", build.FindOnly)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to detect self package location: %v", err)
+	}
+
+	capsSchemaPath := fmt.Sprintf("-I%s/..", pkg.Dir)
+	goSchemaPath := fmt.Sprintf("-I%s/vendor/github.com/glycerine/go-capnproto", pkg.Dir)
+
+	return []string{capsSchemaPath, goSchemaPath}, nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "decode":
+			decode(os.Args[2:])
+			return
+		case "encode":
+			encode(os.Args[2:])
+			return
+		case "convert":
+			convert(os.Args[2:])
+			return
+		case "compat":
+			compatCmd(os.Args[2:])
+			return
+		case "lint":
+			lintCmd(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
 	flag.Usage = use
@@ -54,16 +97,13 @@ func main() {
 	source := *source
 	sourceName := strings.TrimSuffix(source, ".capnp")
 
-	pkg, err := build.Import(SELF_PKG_NAME, "./", build.FindOnly)
+	includes, err := capnpIncludes()
 	if err != nil {
-		fmt.Println("Failed to detect self package location:", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	capsSchemaPath := fmt.Sprintf("-I%s/..", pkg.Dir)
-	goSchemaPath := fmt.Sprintf("-I%s/vendor/github.com/glycerine/go-capnproto", pkg.Dir)
-
-	capnpArgs := []string{capsSchemaPath, goSchemaPath, "compile", "-opgo"}
+	capnpArgs := append(includes, "compile", "-opgo")
 
 	sourceData, err := ioutil.ReadFile(source)
 	if err != nil {
@@ -103,6 +143,9 @@ func main() {
 	cmd := exec.Command("capnp", capnpArgs...)
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
+	if *tests {
+		cmd.Env = append(os.Environ(), caps.TestsEnv+"=1")
+	}
 
 	if *verbose {
 		fmt.Printf("Executing: %q\n", strings.Join(cmd.Args, " "))
@@ -118,7 +161,7 @@ func main() {
 	if msgpGen {
 		inFilename := filepath.Join(*outdir, sourceName+".go")
 		outFilename := filepath.Join(*outdir, sourceName+".msgp.go")
-		cmd = exec.Command("msgp", "-o="+outFilename, "-tests=false", "-file="+inFilename)
+		cmd = exec.Command("msgp", "-o="+outFilename, "-tests="+strconv.FormatBool(*tests), "-file="+inFilename)
 		cmd.Stderr = os.Stderr
 
 		if *verbose {
@@ -0,0 +1,217 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Examples of RFC 8949 appendix A
+func TestWrite(t *testing.T) {
+	for _, tt := range []struct {
+		b    []byte
+		want string
+	}{
+		{AppendUint64(nil, 0), "00"},
+		{AppendUint64(nil, 23), "17"},
+		{AppendUint64(nil, 24), "1818"},
+		{AppendUint64(nil, 1000), "1903e8"},
+		{AppendUint64(nil, 1000000), "1a000f4240"},
+		{AppendUint64(nil, 18446744073709551615), "1bffffffffffffffff"},
+		{AppendInt64(nil, -1), "20"},
+		{AppendInt64(nil, -1000), "3903e7"},
+		{AppendInt64(nil, math.MinInt64), "3b7fffffffffffffff"},
+		{AppendBool(nil, false), "f4"},
+		{AppendBool(nil, true), "f5"},
+		{AppendNil(nil), "f6"},
+		{AppendFloat32(nil, 100000), "fa47c35000"},
+		{AppendFloat64(nil, 1.1), "fb3ff199999999999a"},
+		{AppendString(nil, ""), "60"},
+		{AppendString(nil, "IETF"), "6449455446"},
+		{AppendString(nil, "ü"), "62c3bc"},
+		{AppendBytes(nil, []byte{1, 2, 3, 4}), "4401020304"},
+		{AppendArrayHeader(nil, 25), "9819"},
+		{AppendMapHeader(nil, 2), "a2"},
+	} {
+		if got := hex.EncodeToString(tt.b); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestReadRoundTrip(t *testing.T) {
+	for _, u := range []uint64{0, 23, 24, 255, 256, 65535, 65536, math.MaxUint32, math.MaxUint32 + 1, math.MaxUint64} {
+		v, rest, err := ReadUint64Bytes(AppendUint64(nil, u))
+		if err != nil || v != u || len(rest) != 0 {
+			t.Errorf("uint %d: got %d, %d bytes left, %v", u, v, len(rest), err)
+		}
+	}
+
+	for _, i := range []int64{0, -1, -24, -25, 1000, -1000, math.MaxInt64, math.MinInt64} {
+		v, rest, err := ReadInt64Bytes(AppendInt64(nil, i))
+		if err != nil || v != i || len(rest) != 0 {
+			t.Errorf("int %d: got %d, %d bytes left, %v", i, v, len(rest), err)
+		}
+	}
+
+	for _, f := range []float64{0, -0.5, 1.1, math.MaxFloat64, math.Inf(-1)} {
+		v, _, err := ReadFloat64Bytes(AppendFloat64(nil, f))
+		if err != nil || v != f {
+			t.Errorf("float64 %g: got %g, %v", f, v, err)
+		}
+	}
+	if v, _, err := ReadFloat32Bytes(AppendFloat32(nil, 3.25)); err != nil || v != 3.25 {
+		t.Errorf("float32: got %g, %v", v, err)
+	}
+
+	if s, _, err := ReadStringBytes(AppendString(nil, "caps")); err != nil || s != "caps" {
+		t.Errorf("string: got %q, %v", s, err)
+	}
+	if b, _, err := ReadBytesBytes(AppendBytes(nil, []byte{0, 1})); err != nil || !bytes.Equal(b, []byte{0, 1}) {
+		t.Errorf("bytes: got %x, %v", b, err)
+	}
+	if v, _, err := ReadBoolBytes(AppendBool(nil, true)); err != nil || !v {
+		t.Errorf("bool: got %v, %v", v, err)
+	}
+	if !IsNil(AppendNil(nil)) {
+		t.Errorf("null is not nil")
+	}
+}
+
+func TestIntfRoundTrip(t *testing.T) {
+	in := map[string]interface{}{
+		"n":    nil,
+		"b":    true,
+		"u":    uint64(7),
+		"i":    int64(-7),
+		"f":    2.5,
+		"s":    "text",
+		"d":    []byte("data"),
+		"list": []interface{}{uint64(1), "two", []interface{}{}},
+		"map":  map[string]interface{}{"k": "v"},
+	}
+
+	b, err := AppendIntf(nil, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, rest, err := ReadIntfBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("%d bytes left", len(rest))
+	}
+
+	// Empty arrays are read as nil slices
+	in["list"].([]interface{})[2] = []interface{}(nil)
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("got %#v, want %#v", out, in)
+	}
+
+	if rest, err := Skip(append(b, 0x01)); err != nil || !bytes.Equal(rest, []byte{0x01}) {
+		t.Errorf("Skip left %x, %v", rest, err)
+	}
+}
+
+func TestReadIndefinite(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want interface{}
+	}{
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9f018202039f0405ffff", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+		{"bf61610161629f0203ffff", map[string]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+		{"a201020304", map[interface{}]interface{}{uint64(1): uint64(2), uint64(3): uint64(4)}},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+	} {
+		b := mustHex(tt.in)
+		v, rest, err := ReadIntfBytes(b)
+		if err != nil || len(rest) != 0 || !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("%s: got %#v, %d bytes left, %v", tt.in, v, len(rest), err)
+		}
+		if rest, err := Skip(b); err != nil || len(rest) != 0 {
+			t.Errorf("%s: Skip left %d bytes, %v", tt.in, len(rest), err)
+		}
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   string
+		read func([]byte) error
+		want error
+	}{
+		{"empty", "", readUint64, ErrShortBytes},
+		{"short argument", "19ff", readUint64, ErrShortBytes},
+		{"short string", "6449", readString, ErrShortBytes},
+		{"short bytes", "4401", readBytes, ErrShortBytes},
+		{"short float", "fb3ff1", readFloat64, ErrShortBytes},
+		{"short array", "9a0000ffff00", readArray, ErrShortBytes},
+		{"wrong type", "6449455446", readUint64, TypeError{Want: MajorUint, Got: MajorText}},
+		{"negative uint", "20", readUint64, TypeError{Want: MajorUint, Got: MajorNegInt}},
+		{"uint8 overflow", "190100", readUint8, OverflowError{Value: "256", Bits: 8}},
+		{"int8 overflow", "3880", readInt8, OverflowError{Value: "-129", Bits: 8}},
+		{"int64 overflow", "1b8000000000000000", readInt64, OverflowError{Value: "9223372036854775808", Bits: 64}},
+		{"indefinite map header", "bfff", readMap, ErrIndefinite},
+		{"unterminated chunks", "5f4101", readAny, ErrShortBytes},
+	} {
+		if err := tt.read(mustHex(tt.in)); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		in   string
+		skip bool // Skip rejects it too, it doesn't check contents
+	}{
+		{"1c", true},        // reserved additional info
+		{"1f", true},        // indefinite integer
+		{"f8", true},        // short simple value
+		{"9f01", true},      // unterminated array
+		{"ff", true},        // break outside of indefinite item
+		{"a1810101", false}, // array as map key
+		{"5f6161ff", false}, // text chunk in byte string
+	} {
+		b := mustHex(tt.in)
+		if _, _, err := ReadIntfBytes(b); err == nil {
+			t.Errorf("ReadIntfBytes accepts %s", tt.in)
+		}
+		if _, err := Skip(b); tt.skip && err == nil {
+			t.Errorf("Skip accepts %s", tt.in)
+		}
+	}
+
+	deep := bytes.Repeat([]byte{0x81}, maxDepth+2)
+	if _, _, err := ReadIntfBytes(append(deep, 0x00)); err != errMaxDepth {
+		t.Errorf("ReadIntfBytes of deep array: got %v", err)
+	}
+	if _, err := Skip(append(deep, 0x00)); err != errMaxDepth {
+		t.Errorf("Skip of deep array: got %v", err)
+	}
+}
+
+func readUint64(b []byte) error  { _, _, err := ReadUint64Bytes(b); return err }
+func readUint8(b []byte) error   { _, _, err := ReadUint8Bytes(b); return err }
+func readInt64(b []byte) error   { _, _, err := ReadInt64Bytes(b); return err }
+func readInt8(b []byte) error    { _, _, err := ReadInt8Bytes(b); return err }
+func readFloat64(b []byte) error { _, _, err := ReadFloat64Bytes(b); return err }
+func readString(b []byte) error  { _, _, err := ReadStringBytes(b); return err }
+func readBytes(b []byte) error   { _, _, err := ReadBytesBytes(b); return err }
+func readArray(b []byte) error   { _, _, err := ReadArrayHeaderBytes(b); return err }
+func readMap(b []byte) error     { _, _, err := ReadMapHeaderBytes(b); return err }
+func readAny(b []byte) error     { _, _, err := ReadIntfBytes(b); return err }
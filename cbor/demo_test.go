@@ -0,0 +1,128 @@
+package cbor
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var demo = flag.Bool("demo", false, "fail demo round trips if capnp, capnpc-go or msgp are missing instead of skipping them")
+
+// Demo schemas the generator can't compile, with reasons
+var demoSkipped = map[string]string{
+	// capnpc-pgo requires $Go.package on every file
+	"person.capnp": "person.capnp has no $Go.package annotation",
+	// check.capnp declares only $Check.value, validator expressions
+	// replaced the older annotations
+	"books.capnp": "books.capnp imports /check.capnp and uses $Check.maxlen, minlen, format, pattern and max, which check.capnp doesn't declare",
+}
+
+// demoGopath returns temporary GOPATH where github.com/tpukep/caps mirrors
+// the repository with symbolic links. Generated packages written into the
+// mirror import caps and its vendored packages the way they are imported by
+// the repository, and their schemas find ../codec.capnp. caps passes the
+// mirror to capnp with -I, so /caps/codec.capnp resolves to the same file.
+func demoGopath(t *testing.T, root string) string {
+	repo, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := filepath.Join(root, "src", "github.com", "tpukep", "caps")
+	if err := os.MkdirAll(mirror, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ioutil.ReadDir(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := os.Symlink(filepath.Join(repo, e.Name()), filepath.Join(mirror, e.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mirror
+}
+
+// TestDemoRoundTrip generates every demo schema with $Codec.cbor and runs
+// the round trip tests written by caps -tests. It needs capnp, capnpc-go and
+// msgp in PATH and skips if they are missing, unless run with -demo.
+func TestDemoRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates and tests Go packages")
+	}
+	for _, tool := range []string{"capnp", "capnpc-go", "msgp"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			if *demo {
+				t.Fatalf("%s is not installed", tool)
+			}
+			t.Skipf("%s is not installed, run with -demo to require it", tool)
+		}
+	}
+
+	root, err := ioutil.TempDir("", "caps-gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	// Import paths of packages are found by prefix of GOPATH
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		t.Fatal(err)
+	}
+
+	mirror := demoGopath(t, root)
+	bin := filepath.Join(root, "bin")
+	env := append(os.Environ(), "GOPATH="+root, "GO111MODULE=off", "GOFLAGS=",
+		"PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, cmd := range []string{"caps", "capnpc-pgo"} {
+		build := exec.Command("go", "build", "-o", filepath.Join(bin, cmd), "github.com/tpukep/caps/"+cmd)
+		build.Env = env
+		if out, err := build.CombinedOutput(); err != nil {
+			t.Fatalf("build %s: %v\n%s", cmd, err, out)
+		}
+	}
+
+	schemas, _ := filepath.Glob("../demo/*.capnp")
+	more, _ := filepath.Glob("../demo/*/*.capnp")
+	for _, schema := range append(schemas, more...) {
+		name := filepath.Base(schema)
+
+		t.Run(strings.TrimSuffix(name, ".capnp"), func(t *testing.T) {
+			if reason, found := demoSkipped[name]; found {
+				t.Skip(reason)
+			}
+
+			src, err := ioutil.ReadFile(schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src = append(src, "\n$import \"/caps/codec.capnp\".cbor(void);\n"...)
+
+			// Next to demo, so relative imports of the schema resolve
+			dir := filepath.Join(mirror, "demo-cbor-"+strings.TrimSuffix(name, ".capnp"))
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, name), src, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, args := range [][]string{
+				{filepath.Join(bin, "caps"), "-tests", "-source", name},
+				{"go", "test", "."},
+			} {
+				cmd := exec.Command(args[0], args[1:]...)
+				cmd.Dir = dir
+				cmd.Env = env
+				if out, err := cmd.CombinedOutput(); err != nil {
+					t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,497 @@
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrShortBytes is returned when the slice is too short to contain
+	// the encoded item.
+	ErrShortBytes = errors.New("cbor: too few bytes left to read object")
+
+	// ErrTrailingBytes is returned when data remains after the decoded item.
+	ErrTrailingBytes = errors.New("cbor: trailing bytes after object")
+
+	// ErrIndefinite is returned when an indefinite length item is found
+	// where only definite length is supported.
+	ErrIndefinite = errors.New("cbor: indefinite length is not supported")
+
+	errMaxDepth = errors.New("cbor: max nesting depth exceeded")
+)
+
+const maxDepth = 1024
+
+// Marshaler is implemented by types generated with $Codec.cbor.
+type Marshaler interface {
+	MarshalCBOR() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types generated with $Codec.cbor.
+type Unmarshaler interface {
+	UnmarshalCBOR([]byte) error
+}
+
+// TypeError is returned when the item has unexpected major type.
+type TypeError struct {
+	Want byte
+	Got  byte
+}
+
+func (e TypeError) Error() string {
+	return fmt.Sprintf("cbor: attempted to decode major type %d as %d", e.Got, e.Want)
+}
+
+// OverflowError is returned when the value doesn't fit into the target type.
+type OverflowError struct {
+	Value string
+	Bits  int
+}
+
+func (e OverflowError) Error() string {
+	return fmt.Sprintf("cbor: %s overflows %d-bit integer", e.Value, e.Bits)
+}
+
+// readHead reads the initial byte and its argument. Indefinite length is
+// reported with info 31 and zero argument.
+func readHead(b []byte) (major, info byte, arg uint64, o []byte, err error) {
+	if len(b) < 1 {
+		return 0, 0, 0, b, ErrShortBytes
+	}
+
+	major, info = b[0]>>5, b[0]&0x1f
+	o = b[1:]
+
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24:
+		if len(o) < 1 {
+			return 0, 0, 0, b, ErrShortBytes
+		}
+		arg, o = uint64(o[0]), o[1:]
+	case info == 25:
+		if len(o) < 2 {
+			return 0, 0, 0, b, ErrShortBytes
+		}
+		arg, o = uint64(binary.BigEndian.Uint16(o)), o[2:]
+	case info == 26:
+		if len(o) < 4 {
+			return 0, 0, 0, b, ErrShortBytes
+		}
+		arg, o = uint64(binary.BigEndian.Uint32(o)), o[4:]
+	case info == 27:
+		if len(o) < 8 {
+			return 0, 0, 0, b, ErrShortBytes
+		}
+		arg, o = binary.BigEndian.Uint64(o), o[8:]
+	case info == indefinite:
+		if major == MajorUint || major == MajorNegInt || major == MajorTag {
+			return 0, 0, 0, b, fmt.Errorf("cbor: invalid additional info %d", info)
+		}
+	default:
+		return 0, 0, 0, b, fmt.Errorf("cbor: invalid additional info %d", info)
+	}
+	return
+}
+
+func readLength(b []byte, want byte) (uint64, []byte, error) {
+	major, info, arg, o, err := readHead(b)
+	if err != nil {
+		return 0, b, err
+	}
+	if major != want {
+		return 0, b, TypeError{Want: want, Got: major}
+	}
+	if info == indefinite {
+		return 0, b, ErrIndefinite
+	}
+	return arg, o, nil
+}
+
+// IsNil reports whether the next item is null.
+func IsNil(b []byte) bool {
+	return len(b) > 0 && b[0] == MajorSimple<<5|simpleNull
+}
+
+// ReadMapHeaderBytes reads a header of definite length map.
+func ReadMapHeaderBytes(b []byte) (uint64, []byte, error) {
+	return readLength(b, MajorMap)
+}
+
+// ReadArrayHeaderBytes reads a header of definite length array. The length
+// is checked against the remaining data, every element takes at least one byte.
+func ReadArrayHeaderBytes(b []byte) (uint64, []byte, error) {
+	sz, o, err := readLength(b, MajorArray)
+	if err == nil && sz > uint64(len(o)) {
+		return 0, b, ErrShortBytes
+	}
+	return sz, o, err
+}
+
+// ReadBytesBytes reads a byte string. The result is a copy of the data.
+func ReadBytesBytes(b []byte) ([]byte, []byte, error) {
+	sz, o, err := readLength(b, MajorBytes)
+	if err != nil {
+		return nil, b, err
+	}
+	if sz > uint64(len(o)) {
+		return nil, b, ErrShortBytes
+	}
+	v := make([]byte, sz)
+	copy(v, o)
+	return v, o[sz:], nil
+}
+
+// ReadStringBytes reads a text string.
+func ReadStringBytes(b []byte) (string, []byte, error) {
+	sz, o, err := readLength(b, MajorText)
+	if err != nil {
+		return "", b, err
+	}
+	if sz > uint64(len(o)) {
+		return "", b, ErrShortBytes
+	}
+	return string(o[:sz]), o[sz:], nil
+}
+
+// ReadBoolBytes reads a boolean.
+func ReadBoolBytes(b []byte) (bool, []byte, error) {
+	if len(b) < 1 {
+		return false, b, ErrShortBytes
+	}
+	switch b[0] {
+	case MajorSimple<<5 | simpleTrue:
+		return true, b[1:], nil
+	case MajorSimple<<5 | simpleFalse:
+		return false, b[1:], nil
+	}
+	return false, b, fmt.Errorf("cbor: attempted to decode 0x%x as bool", b[0])
+}
+
+// ReadUint64Bytes reads an unsigned integer.
+func ReadUint64Bytes(b []byte) (uint64, []byte, error) {
+	major, _, arg, o, err := readHead(b)
+	if err != nil {
+		return 0, b, err
+	}
+	if major != MajorUint {
+		return 0, b, TypeError{Want: MajorUint, Got: major}
+	}
+	return arg, o, nil
+}
+
+func readUint(b []byte, bits int) (uint64, []byte, error) {
+	u, o, err := ReadUint64Bytes(b)
+	if err == nil && bits < 64 && u>>uint(bits) != 0 {
+		return 0, b, OverflowError{Value: fmt.Sprint(u), Bits: bits}
+	}
+	return u, o, err
+}
+
+// ReadUint32Bytes reads an unsigned integer that fits into 32 bits.
+func ReadUint32Bytes(b []byte) (uint32, []byte, error) {
+	u, o, err := readUint(b, 32)
+	return uint32(u), o, err
+}
+
+// ReadUint16Bytes reads an unsigned integer that fits into 16 bits.
+func ReadUint16Bytes(b []byte) (uint16, []byte, error) {
+	u, o, err := readUint(b, 16)
+	return uint16(u), o, err
+}
+
+// ReadUint8Bytes reads an unsigned integer that fits into 8 bits.
+func ReadUint8Bytes(b []byte) (uint8, []byte, error) {
+	u, o, err := readUint(b, 8)
+	return uint8(u), o, err
+}
+
+// ReadInt64Bytes reads a signed integer of major type 0 or 1.
+func ReadInt64Bytes(b []byte) (int64, []byte, error) {
+	major, _, arg, o, err := readHead(b)
+	if err != nil {
+		return 0, b, err
+	}
+
+	switch major {
+	case MajorUint:
+		if arg > math.MaxInt64 {
+			return 0, b, OverflowError{Value: fmt.Sprint(arg), Bits: 64}
+		}
+		return int64(arg), o, nil
+	case MajorNegInt:
+		if arg > math.MaxInt64 {
+			return 0, b, OverflowError{Value: fmt.Sprintf("-1-%d", arg), Bits: 64}
+		}
+		return -1 - int64(arg), o, nil
+	}
+	return 0, b, TypeError{Want: MajorUint, Got: major}
+}
+
+func readInt(b []byte, bits uint) (int64, []byte, error) {
+	i, o, err := ReadInt64Bytes(b)
+	if err == nil && (i < -1<<(bits-1) || i > 1<<(bits-1)-1) {
+		return 0, b, OverflowError{Value: fmt.Sprint(i), Bits: int(bits)}
+	}
+	return i, o, err
+}
+
+// ReadInt32Bytes reads a signed integer that fits into 32 bits.
+func ReadInt32Bytes(b []byte) (int32, []byte, error) {
+	i, o, err := readInt(b, 32)
+	return int32(i), o, err
+}
+
+// ReadInt16Bytes reads a signed integer that fits into 16 bits.
+func ReadInt16Bytes(b []byte) (int16, []byte, error) {
+	i, o, err := readInt(b, 16)
+	return int16(i), o, err
+}
+
+// ReadInt8Bytes reads a signed integer that fits into 8 bits.
+func ReadInt8Bytes(b []byte) (int8, []byte, error) {
+	i, o, err := readInt(b, 8)
+	return int8(i), o, err
+}
+
+// ReadFloat64Bytes reads a float of any precision.
+func ReadFloat64Bytes(b []byte) (float64, []byte, error) {
+	if len(b) < 1 {
+		return 0, b, ErrShortBytes
+	}
+
+	switch b[0] {
+	case MajorSimple<<5 | simpleFloat16:
+		if len(b) < 3 {
+			return 0, b, ErrShortBytes
+		}
+		return float16to64(binary.BigEndian.Uint16(b[1:])), b[3:], nil
+	case MajorSimple<<5 | simpleFloat32:
+		if len(b) < 5 {
+			return 0, b, ErrShortBytes
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b[1:]))), b[5:], nil
+	case MajorSimple<<5 | simpleFloat64:
+		if len(b) < 9 {
+			return 0, b, ErrShortBytes
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b[1:])), b[9:], nil
+	}
+	return 0, b, fmt.Errorf("cbor: attempted to decode 0x%x as float", b[0])
+}
+
+// ReadFloat32Bytes reads a float of any precision as float32.
+func ReadFloat32Bytes(b []byte) (float32, []byte, error) {
+	f, o, err := ReadFloat64Bytes(b)
+	return float32(f), o, err
+}
+
+func float16to64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// ReadIntfBytes reads an item of any type. Maps with text keys are returned
+// as map[string]interface{}, other maps as map[interface{}]interface{}.
+func ReadIntfBytes(b []byte) (interface{}, []byte, error) {
+	return readIntf(b, 0)
+}
+
+func readIntf(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxDepth {
+		return nil, b, errMaxDepth
+	}
+
+	major, info, arg, o, err := readHead(b)
+	if err != nil {
+		return nil, b, err
+	}
+
+	switch major {
+	case MajorUint:
+		return arg, o, nil
+
+	case MajorNegInt:
+		return ReadInt64Bytes(b)
+
+	case MajorBytes:
+		if info == indefinite {
+			return readChunks(o, MajorBytes)
+		}
+		return ReadBytesBytes(b)
+
+	case MajorText:
+		if info == indefinite {
+			v, o, err := readChunks(o, MajorText)
+			return string(v), o, err
+		}
+		return ReadStringBytes(b)
+
+	case MajorArray:
+		var a []interface{}
+		for i := uint64(0); info == indefinite || i < arg; i++ {
+			if info == indefinite && len(o) > 0 && o[0] == breakCode {
+				return a, o[1:], nil
+			}
+			var v interface{}
+			if v, o, err = readIntf(o, depth+1); err != nil {
+				return nil, b, err
+			}
+			a = append(a, v)
+		}
+		return a, o, nil
+
+	case MajorMap:
+		m := make(map[string]interface{})
+		var other map[interface{}]interface{}
+		for i := uint64(0); info == indefinite || i < arg; i++ {
+			if info == indefinite && len(o) > 0 && o[0] == breakCode {
+				o = o[1:]
+				break
+			}
+			var k, v interface{}
+			if k, o, err = readIntf(o, depth+1); err != nil {
+				return nil, b, err
+			}
+			if v, o, err = readIntf(o, depth+1); err != nil {
+				return nil, b, err
+			}
+			if s, ok := k.(string); ok && other == nil {
+				m[s] = v
+				continue
+			}
+			if other == nil {
+				other = make(map[interface{}]interface{})
+				for mk, mv := range m {
+					other[mk] = mv
+				}
+			}
+			switch k.(type) {
+			case []interface{}, map[string]interface{}, map[interface{}]interface{}, []byte:
+				return nil, b, fmt.Errorf("cbor: unsupported map key of type %T", k)
+			}
+			other[k] = v
+		}
+		if other != nil {
+			return other, o, nil
+		}
+		return m, o, nil
+
+	case MajorTag:
+		// Tags are informative only
+		return readIntf(o, depth+1)
+	}
+
+	switch info {
+	case simpleFalse, simpleTrue:
+		return ReadBoolBytes(b)
+	case simpleNull, 23:
+		return nil, o, nil
+	case simpleFloat16, simpleFloat32, simpleFloat64:
+		return ReadFloat64Bytes(b)
+	}
+	return nil, b, fmt.Errorf("cbor: unsupported simple value %d", info)
+}
+
+func readChunks(b []byte, major byte) ([]byte, []byte, error) {
+	var v []byte
+	for {
+		if len(b) < 1 {
+			return nil, b, ErrShortBytes
+		}
+		if b[0] == breakCode {
+			return v, b[1:], nil
+		}
+
+		var chunk []byte
+		var err error
+		if major == MajorText {
+			var s string
+			s, b, err = ReadStringBytes(b)
+			chunk = []byte(s)
+		} else {
+			chunk, b, err = ReadBytesBytes(b)
+		}
+		if err != nil {
+			return nil, b, err
+		}
+		v = append(v, chunk...)
+	}
+}
+
+// Skip skips over the next item.
+func Skip(b []byte) ([]byte, error) {
+	return skip(b, 0)
+}
+
+func skip(b []byte, depth int) ([]byte, error) {
+	if depth > maxDepth {
+		return b, errMaxDepth
+	}
+
+	major, info, arg, o, err := readHead(b)
+	if err != nil {
+		return b, err
+	}
+
+	if info == indefinite {
+		if major == MajorSimple {
+			return b, fmt.Errorf("cbor: unexpected break")
+		}
+		for {
+			if len(o) < 1 {
+				return b, ErrShortBytes
+			}
+			if o[0] == breakCode {
+				return o[1:], nil
+			}
+			if o, err = skip(o, depth+1); err != nil {
+				return b, err
+			}
+		}
+	}
+
+	switch major {
+	case MajorBytes, MajorText:
+		if arg > uint64(len(o)) {
+			return b, ErrShortBytes
+		}
+		return o[arg:], nil
+	case MajorArray, MajorMap:
+		n := arg
+		if major == MajorMap {
+			n *= 2
+		}
+		for i := uint64(0); i < n; i++ {
+			if o, err = skip(o, depth+1); err != nil {
+				return b, err
+			}
+		}
+		return o, nil
+	case MajorTag:
+		return skip(o, depth+1)
+	}
+	return o, nil
+}
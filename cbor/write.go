@@ -0,0 +1,165 @@
+// Package cbor implements CBOR (RFC 8949) primitives used by code generated
+// with $Codec.cbor annotation.
+package cbor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Major types
+const (
+	MajorUint   byte = 0
+	MajorNegInt byte = 1
+	MajorBytes  byte = 2
+	MajorText   byte = 3
+	MajorArray  byte = 4
+	MajorMap    byte = 5
+	MajorTag    byte = 6
+	MajorSimple byte = 7
+)
+
+const (
+	simpleFalse   byte = 20
+	simpleTrue    byte = 21
+	simpleNull    byte = 22
+	simpleFloat16 byte = 25
+	simpleFloat32 byte = 26
+	simpleFloat64 byte = 27
+	indefinite    byte = 31
+	breakCode     byte = 0xff
+)
+
+func appendHead(b []byte, major byte, v uint64) []byte {
+	m := major << 5
+	switch {
+	case v < 24:
+		return append(b, m|byte(v))
+	case v <= math.MaxUint8:
+		return append(b, m|24, byte(v))
+	case v <= math.MaxUint16:
+		return append(b, m|25, byte(v>>8), byte(v))
+	case v <= math.MaxUint32:
+		return append(b, m|26, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], v)
+		return append(append(b, m|27), buf[:]...)
+	}
+}
+
+// AppendMapHeader appends a header of the map with sz pairs.
+func AppendMapHeader(b []byte, sz uint64) []byte { return appendHead(b, MajorMap, sz) }
+
+// AppendArrayHeader appends a header of the array with sz elements.
+func AppendArrayHeader(b []byte, sz uint64) []byte { return appendHead(b, MajorArray, sz) }
+
+// AppendUint64 appends an unsigned integer.
+func AppendUint64(b []byte, u uint64) []byte { return appendHead(b, MajorUint, u) }
+
+// AppendInt64 appends an integer, negative values use major type 1.
+func AppendInt64(b []byte, i int64) []byte {
+	if i < 0 {
+		return appendHead(b, MajorNegInt, uint64(-(i + 1)))
+	}
+	return appendHead(b, MajorUint, uint64(i))
+}
+
+// AppendBool appends a boolean.
+func AppendBool(b []byte, t bool) []byte {
+	if t {
+		return append(b, MajorSimple<<5|simpleTrue)
+	}
+	return append(b, MajorSimple<<5|simpleFalse)
+}
+
+// AppendNil appends null.
+func AppendNil(b []byte) []byte { return append(b, MajorSimple<<5|simpleNull) }
+
+// AppendFloat32 appends a single precision float.
+func AppendFloat32(b []byte, f float32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], math.Float32bits(f))
+	return append(append(b, MajorSimple<<5|simpleFloat32), buf[:]...)
+}
+
+// AppendFloat64 appends a double precision float.
+func AppendFloat64(b []byte, f float64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], math.Float64bits(f))
+	return append(append(b, MajorSimple<<5|simpleFloat64), buf[:]...)
+}
+
+// AppendString appends a text string.
+func AppendString(b []byte, s string) []byte {
+	return append(appendHead(b, MajorText, uint64(len(s))), s...)
+}
+
+// AppendBytes appends a byte string.
+func AppendBytes(b []byte, bts []byte) []byte {
+	return append(appendHead(b, MajorBytes, uint64(len(bts))), bts...)
+}
+
+// AppendIntf appends a value of dynamic type. Supported are nil, booleans,
+// numbers, strings, byte slices and []interface{} or map[string]interface{}
+// of them.
+func AppendIntf(b []byte, i interface{}) ([]byte, error) {
+	switch v := i.(type) {
+	case nil:
+		return AppendNil(b), nil
+	case bool:
+		return AppendBool(b, v), nil
+	case int:
+		return AppendInt64(b, int64(v)), nil
+	case int8:
+		return AppendInt64(b, int64(v)), nil
+	case int16:
+		return AppendInt64(b, int64(v)), nil
+	case int32:
+		return AppendInt64(b, int64(v)), nil
+	case int64:
+		return AppendInt64(b, v), nil
+	case uint:
+		return AppendUint64(b, uint64(v)), nil
+	case uint8:
+		return AppendUint64(b, uint64(v)), nil
+	case uint16:
+		return AppendUint64(b, uint64(v)), nil
+	case uint32:
+		return AppendUint64(b, uint64(v)), nil
+	case uint64:
+		return AppendUint64(b, v), nil
+	case float32:
+		return AppendFloat32(b, v), nil
+	case float64:
+		return AppendFloat64(b, v), nil
+	case string:
+		return AppendString(b, v), nil
+	case []byte:
+		return AppendBytes(b, v), nil
+	case []interface{}:
+		var err error
+		b = AppendArrayHeader(b, uint64(len(v)))
+		for _, e := range v {
+			if b, err = AppendIntf(b, e); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		var err error
+		b = AppendMapHeader(b, uint64(len(v)))
+		for k, e := range v {
+			b = AppendString(b, k)
+			if b, err = AppendIntf(b, e); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case Marshaler:
+		data, err := v.MarshalCBOR()
+		return append(b, data...), err
+	}
+	return b, fmt.Errorf("cbor: type %T not supported", i)
+}
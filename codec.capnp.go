@@ -3,3 +3,19 @@ package caps
 const CodecMsgp = uint64(0xdd7630a67673d856)
 const CodecJson = uint64(0xd44ac70dbe18c0dc)
 const CodecCapnp = uint64(0x97e981fc97bd1f9e)
+const CodecJsonSchema = uint64(0x8fcf63be9320b6a1)
+const CodecOpenApi = uint64(0xbab884dd935a4e93)
+const CodecProto = uint64(0x94f0665da56962c4)
+const CodecTypescript = uint64(0x8b6c57e986e05ddf)
+const CodecYaml = uint64(0xfacbb9e981aded4b)
+const CodecToml = uint64(0xea5b555c6a5ea9e3)
+const CodecCbor = uint64(0x9cadcbe7ad352868)
+const CodecCborOrdinalKeys = uint64(0xa945d96bc033b1a4)
+const CodecSql = uint64(0xe6f8ad9f651e95aa)
+const CodecMsgpTuple = uint64(0xc812ac2cd0df4007)
+const CodecCaplit = uint64(0x8e42914ec6594cc2)
+const CodecRegistry = uint64(0xf75be8dbf626c316)
+const CodecNoClone = uint64(0xd042dac7783110f0)
+const CodecRandom = uint64(0xd01ee61f797903f3)
+const CodecDiff = uint64(0x8cb0d41a18001199)
+const CodecMask = uint64(0xcfbb9358db2b31db)
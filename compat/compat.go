@@ -0,0 +1,471 @@
+// Package compat compares two versions of a schema and reports changes
+// breaking Cap'n Proto evolution rules or the JSON and msgp encodings of
+// generated code.
+package compat
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/tpukep/caps"
+	"github.com/tpukep/caps/dynamic"
+)
+
+// Change is a difference between versions of the schema. Breaking changes
+// make old and new code misread messages of each other.
+type Change struct {
+	Breaking bool
+	Path     string // Name relative to the file like "Book.description.genre"
+	Message  string
+}
+
+func (c Change) String() string {
+	if c.Breaking {
+		return fmt.Sprintf("breaking: %s: %s", c.Path, c.Message)
+	}
+	return fmt.Sprintf("compatible: %s: %s", c.Path, c.Message)
+}
+
+// Breaking reports whether any of the changes is breaking.
+func Breaking(changes []Change) bool {
+	for _, c := range changes {
+		if c.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+type comparer struct {
+	old, new *dynamic.Schema
+	changes  []Change
+}
+
+func (c *comparer) breaking(path, format string, a ...interface{}) {
+	c.changes = append(c.changes, Change{true, path, fmt.Sprintf(format, a...)})
+}
+
+func (c *comparer) compatible(path, format string, a ...interface{}) {
+	c.changes = append(c.changes, Change{false, path, fmt.Sprintf(format, a...)})
+}
+
+// Compare returns changes of requested files from old to new schema.
+// Nodes are matched by ID, fields by ordinal, enumerants and methods by
+// code order. Groups are matched by ordinals of their fields, so renamed
+// groups are still found.
+func Compare(old, new *dynamic.Schema) []Change {
+	c := &comparer{old: old, new: new}
+
+	for _, on := range old.Nodes() {
+		if !compared(on) {
+			continue
+		}
+		nn, err := new.Node(on.Id())
+		if err != nil {
+			c.breaking(nodeName(on), "%s removed", kindName(on))
+			continue
+		}
+		c.node(on, nn)
+	}
+
+	for _, nn := range new.Nodes() {
+		if !compared(nn) {
+			continue
+		}
+		if _, err := old.Node(nn.Id()); err != nil {
+			c.compatible(nodeName(nn), "%s added", kindName(nn))
+		}
+	}
+	return c.changes
+}
+
+// compared reports whether the node is compared on its own. Groups are
+// compared with fields of their structs.
+func compared(n caps.Node) bool {
+	switch n.Which() {
+	case caps.NODE_STRUCT:
+		return !n.Struct().IsGroup()
+	case caps.NODE_ENUM, caps.NODE_INTERFACE:
+		return true
+	}
+	return false
+}
+
+// nodeName returns name of the node relative to the file
+func nodeName(n caps.Node) string {
+	name := n.DisplayName()
+	if i := strings.Index(name, ":"); i != -1 {
+		return name[i+1:]
+	}
+	return name
+}
+
+func kindName(n caps.Node) string {
+	switch n.Which() {
+	case caps.NODE_STRUCT:
+		return "struct"
+	case caps.NODE_ENUM:
+		return "enum"
+	case caps.NODE_INTERFACE:
+		return "interface"
+	}
+	return "node"
+}
+
+func (c *comparer) node(on, nn caps.Node) {
+	path := nodeName(nn)
+
+	if on.Which() != nn.Which() {
+		c.breaking(path, "changed from %s to %s", kindName(on), kindName(nn))
+		return
+	}
+	if nodeName(on) != path {
+		c.compatible(path, "renamed from %s", nodeName(on))
+	}
+
+	switch nn.Which() {
+	case caps.NODE_STRUCT:
+		c.fields(path, on, nn)
+	case caps.NODE_ENUM:
+		c.enumerants(path, on, nn)
+	case caps.NODE_INTERFACE:
+		c.methods(path, on, nn)
+	}
+}
+
+// fieldKey returns key matching the field across versions: ordinal of
+// slots and the least ordinal within groups.
+func fieldKey(s *dynamic.Schema, f caps.Field) string {
+	return fmt.Sprintf("@%d", fieldOrdinal(s, f))
+}
+
+func fieldOrdinal(s *dynamic.Schema, f caps.Field) int {
+	if f.Which() == caps.FIELD_SLOT {
+		return int(f.Ordinal().Explicit())
+	}
+
+	min := -1
+	if g, err := s.Node(f.Group().TypeId()); err == nil {
+		for _, gf := range g.Struct().Fields().ToArray() {
+			if o := fieldOrdinal(s, gf); o != -1 && (min == -1 || o < min) {
+				min = o
+			}
+		}
+	}
+	return min
+}
+
+// fields compares fields of structs or groups on and nn
+func (c *comparer) fields(path string, on, nn caps.Node) {
+	so, sn := on.Struct(), nn.Struct()
+
+	if so.DiscriminantCount() > 0 && sn.DiscriminantCount() > 0 && so.DiscriminantOffset() != sn.DiscriminantOffset() {
+		c.breaking(path, "union discriminant moved from offset %d to %d", so.DiscriminantOffset(), sn.DiscriminantOffset())
+	}
+
+	newFields := make(map[string]caps.Field)
+	for _, f := range dynamic.Fields(nn) {
+		newFields[fieldKey(c.new, f)] = f
+	}
+
+	matched := make(map[string]bool)
+	for _, of := range dynamic.Fields(on) {
+		key := fieldKey(c.old, of)
+		nf, found := newFields[key]
+		if !found {
+			c.breaking(path+"."+of.Name(), "field %s removed", key)
+			continue
+		}
+		matched[key] = true
+		c.field(path+"."+nf.Name(), of, nf)
+	}
+
+	for _, nf := range dynamic.Fields(nn) {
+		key := fieldKey(c.new, nf)
+		if matched[key] {
+			continue
+		}
+		if caps.CodecOf(nf).Required {
+			c.breaking(path+"."+nf.Name(), "required field %s added, old messages lack it", key)
+		} else {
+			c.compatible(path+"."+nf.Name(), "field %s added", key)
+		}
+	}
+}
+
+// field compares fields of and nf matched by ordinal
+func (c *comparer) field(path string, of, nf caps.Field) {
+	if of.Name() != nf.Name() {
+		c.compatible(path, "renamed from %s", of.Name())
+	}
+
+	oc, nc := caps.CodecOf(of), caps.CodecOf(nf)
+	switch {
+	case !oc.Ignored && nc.Ignored:
+		c.breaking(path, "ignored by codecs, JSON and msgp leave it out")
+	case oc.Ignored && !nc.Ignored:
+		c.compatible(path, "no longer ignored by codecs")
+	case !nc.Ignored && oc.Name != nc.Name:
+		c.breaking(path, "JSON and msgp key renamed from %q to %q", oc.Name, nc.Name)
+	}
+	if !oc.Required && nc.Required {
+		c.breaking(path, "became required, old messages may lack it")
+	}
+
+	od, nd := of.DiscriminantValue(), nf.DiscriminantValue()
+	switch {
+	case od == nd:
+	case od == caps.FieldNoDiscriminant:
+		c.breaking(path, "moved into union")
+	case nd == caps.FieldNoDiscriminant:
+		c.breaking(path, "moved out of union")
+	default:
+		c.breaking(path, "union discriminant changed from %d to %d", od, nd)
+	}
+
+	if of.Which() != nf.Which() {
+		c.breaking(path, "changed between group and slot")
+		return
+	}
+
+	if nf.Which() == caps.FIELD_GROUP {
+		og, err := c.old.Node(of.Group().TypeId())
+		if err != nil {
+			return
+		}
+		ng, err := c.new.Node(nf.Group().TypeId())
+		if err != nil {
+			return
+		}
+		c.fields(path, og, ng)
+		return
+	}
+
+	so, sn := of.Slot(), nf.Slot()
+	if !c.sameType(so.Type(), sn.Type()) {
+		c.breaking(path, "type changed from %s to %s", typeName(c.old, so.Type()), typeName(c.new, sn.Type()))
+		return
+	}
+	if so.Offset() != sn.Offset() {
+		c.breaking(path, "moved from offset %d to %d", so.Offset(), sn.Offset())
+	}
+	c.defaults(path, so, sn)
+
+	if ot, nt := semanticType(of), semanticType(nf); ot != nt {
+		c.breaking(path, "$Type changed from %s to %s, JSON and msgp encoding changes", ot, nt)
+	}
+}
+
+// defaults compares default values of slots of the same type. Struct and
+// list defaults are compared only if set explicitly, otherwise they are
+// zero values of their types, which may grow between versions.
+func (c *comparer) defaults(path string, so, sn caps.FieldSlot) {
+	switch sn.Type().Which() {
+	case caps.TYPE_STRUCT, caps.TYPE_LIST, caps.TYPE_ANYPOINTER, caps.TYPE_INTERFACE:
+		if so.HadExplicitDefault() != sn.HadExplicitDefault() {
+			c.breaking(path, "default changed")
+			return
+		}
+		if !sn.HadExplicitDefault() {
+			return
+		}
+	}
+
+	if ov, nv := c.old.DefaultValue(so), c.new.DefaultValue(sn); !equalValue(ov, nv) {
+		c.breaking(path, "default changed from %s to %s", valueString(ov), valueString(nv))
+	}
+}
+
+// enumerants compares enumerants of enums matched by code order. JSON
+// writes names of enumerants, msgp and Cap'n Proto their numbers.
+func (c *comparer) enumerants(path string, on, nn caps.Node) {
+	oes, nes := on.Enum().Enumerants().ToArray(), nn.Enum().Enumerants().ToArray()
+
+	for i, oe := range oes {
+		if i >= len(nes) {
+			c.breaking(path+"."+oe.Name(), "enumerant %d removed", i)
+			continue
+		}
+		if ne := nes[i]; oe.Name() != ne.Name() {
+			c.breaking(path+"."+ne.Name(), "enumerant %d renamed from %s, JSON names change", i, oe.Name())
+		}
+	}
+	for i := len(oes); i < len(nes); i++ {
+		c.compatible(path+"."+nes[i].Name(), "enumerant %d added", i)
+	}
+}
+
+// methods compares methods of interfaces matched by code order.
+// Parameters and results declared in place are compared as structs.
+func (c *comparer) methods(path string, on, nn caps.Node) {
+	oms, nms := on.Interface().Methods().ToArray(), nn.Interface().Methods().ToArray()
+
+	for i, om := range oms {
+		if i >= len(nms) {
+			c.breaking(path+"."+om.Name(), "method @%d removed", i)
+			continue
+		}
+
+		nm := nms[i]
+		mpath := path + "." + nm.Name()
+		if om.Name() != nm.Name() {
+			c.compatible(mpath, "renamed from %s", om.Name())
+		}
+		c.params(mpath+"$Params", "parameters", om.ParamStructType(), nm.ParamStructType())
+		c.params(mpath+"$Results", "results", om.ResultStructType(), nm.ResultStructType())
+	}
+	for i := len(oms); i < len(nms); i++ {
+		c.compatible(path+"."+nms[i].Name(), "method @%d added", i)
+	}
+}
+
+func (c *comparer) params(path, what string, oid, nid uint64) {
+	if oid != nid {
+		c.breaking(path, "%s changed type", what)
+		return
+	}
+
+	// Named structs are compared on their own
+	on, err := c.old.Node(oid)
+	if err != nil || on.ScopeId() != 0 {
+		return
+	}
+	nn, err := c.new.Node(nid)
+	if err != nil {
+		return
+	}
+	c.fields(path, on, nn)
+}
+
+// sameType reports whether types have the same encoding. Struct, enum and
+// interface types are matched by ID.
+func (c *comparer) sameType(ot, nt caps.Type) bool {
+	if ot.Which() != nt.Which() {
+		return false
+	}
+
+	switch nt.Which() {
+	case caps.TYPE_LIST:
+		return c.sameType(ot.List().ElementType(), nt.List().ElementType())
+	case caps.TYPE_STRUCT:
+		return ot.Struct().TypeId() == nt.Struct().TypeId()
+	case caps.TYPE_ENUM:
+		return ot.Enum().TypeId() == nt.Enum().TypeId()
+	case caps.TYPE_INTERFACE:
+		return ot.Interface().TypeId() == nt.Interface().TypeId()
+	}
+	return true
+}
+
+var typeNames = map[caps.Type_Which]string{
+	caps.TYPE_VOID:       "Void",
+	caps.TYPE_BOOL:       "Bool",
+	caps.TYPE_INT8:       "Int8",
+	caps.TYPE_INT16:      "Int16",
+	caps.TYPE_INT32:      "Int32",
+	caps.TYPE_INT64:      "Int64",
+	caps.TYPE_UINT8:      "UInt8",
+	caps.TYPE_UINT16:     "UInt16",
+	caps.TYPE_UINT32:     "UInt32",
+	caps.TYPE_UINT64:     "UInt64",
+	caps.TYPE_FLOAT32:    "Float32",
+	caps.TYPE_FLOAT64:    "Float64",
+	caps.TYPE_TEXT:       "Text",
+	caps.TYPE_DATA:       "Data",
+	caps.TYPE_ANYPOINTER: "AnyPointer",
+}
+
+// typeName returns name of the type as written in schemas
+func typeName(s *dynamic.Schema, t caps.Type) string {
+	var id uint64
+	switch t.Which() {
+	case caps.TYPE_LIST:
+		return "List(" + typeName(s, t.List().ElementType()) + ")"
+	case caps.TYPE_STRUCT:
+		id = t.Struct().TypeId()
+	case caps.TYPE_ENUM:
+		id = t.Enum().TypeId()
+	case caps.TYPE_INTERFACE:
+		id = t.Interface().TypeId()
+	default:
+		return typeNames[t.Which()]
+	}
+
+	if n, err := s.Node(id); err == nil {
+		return nodeName(n)
+	}
+	return fmt.Sprintf("0x%x", id)
+}
+
+// semanticType returns $Type annotation of the field, which changes JSON
+// and msgp encoding of generated code.
+func semanticType(f caps.Field) string {
+	for _, a := range f.Annotations().ToArray() {
+		switch a.Id() {
+		case caps.TypeTimestamp:
+			return "timestamp(" + caps.TimeUnit(a.Value().Enum()).String() + ")"
+		case caps.TypeDuration:
+			return "duration"
+		case caps.TypeUuid:
+			return "uuid"
+		case caps.TypeIp:
+			return "ip"
+		}
+	}
+	return "none"
+}
+
+// equalValue compares default values. Enums are compared by numbers and
+// structs by fields in code order.
+func equalValue(a, b dynamic.Value) bool {
+	switch a := a.(type) {
+	case float64:
+		bb, ok := b.(float64)
+		return ok && (a == bb || a != a && bb != bb)
+	case []byte:
+		bb, ok := b.([]byte)
+		return ok && bytes.Equal(a, bb)
+	case dynamic.Enum:
+		bb, ok := b.(dynamic.Enum)
+		return ok && a.Value == bb.Value
+	case *dynamic.Struct:
+		bb, ok := b.(*dynamic.Struct)
+		if !ok || len(a.Fields) != len(bb.Fields) {
+			return false
+		}
+		for i, f := range a.Fields {
+			if f.Active != bb.Fields[i].Active || !equalValue(f.Value, bb.Fields[i].Value) {
+				return false
+			}
+		}
+		return true
+	case []dynamic.Value:
+		bb, ok := b.([]dynamic.Value)
+		if !ok || len(a) != len(bb) {
+			return false
+		}
+		for i := range a {
+			if !equalValue(a[i], bb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func valueString(v dynamic.Value) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case dynamic.Enum:
+		if name := v.Name(); name != "" {
+			return name
+		}
+		return fmt.Sprint(v.Value)
+	case *dynamic.Struct, []dynamic.Value:
+		return "(...)"
+	}
+	return fmt.Sprint(v)
+}
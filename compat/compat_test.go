@@ -0,0 +1,423 @@
+package compat
+
+import (
+	"strings"
+	"testing"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+	"github.com/tpukep/caps/dynamic"
+)
+
+// Schemas of the tests are built the way capnp compile writes them, so the
+// tests don't need the compiler.
+
+const testFileID = 0xc0a7c0a7c0a70000
+
+type testAnnotation struct {
+	id   uint64
+	text string
+}
+
+type testField struct {
+	name   string
+	ord    uint16
+	typ    func(*C.Segment) caps.Type
+	offset uint32
+	disc   int // -1 if the field is not union member
+	group  uint64
+	def    int64 // Default of Int64 slots
+	anns   []testAnnotation
+}
+
+type testMethod struct {
+	name            string
+	params, results uint64
+}
+
+type testNode struct {
+	id                    uint64
+	name                  string
+	inPlace               bool // Parameter struct of method, outside of file scope
+	group                 bool
+	discCount, discOffset uint16
+	fields                []testField
+	enumerants            []string
+	methods               []testMethod
+}
+
+func primType(set func(caps.Type)) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		set(t)
+		return t
+	}
+}
+
+var (
+	voidType  = primType(caps.Type.SetVoid)
+	int64Type = primType(caps.Type.SetInt64)
+	textType  = primType(caps.Type.SetText)
+)
+
+func structType(id uint64) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetStruct()
+		t.Struct().SetTypeId(id)
+		return t
+	}
+}
+
+func enumType(id uint64) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetEnum()
+		t.Enum().SetTypeId(id)
+		return t
+	}
+}
+
+func listType(et func(*C.Segment) caps.Type) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetList()
+		t.List().SetElementType(et(seg))
+		return t
+	}
+}
+
+func testAnnotations(seg *C.Segment, as []testAnnotation) caps.Annotation_List {
+	l := caps.NewAnnotationList(seg, len(as))
+	for i, a := range as {
+		v := caps.NewValue(seg)
+		if a.text != "" {
+			v.SetText(a.text)
+		} else {
+			v.SetVoid()
+		}
+		l.At(i).SetId(a.id)
+		l.At(i).SetValue(v)
+	}
+	return l
+}
+
+// testSchema returns schema of file test.capnp holding the nodes. Names of
+// the nodes are relative to the file.
+func testSchema(nodes ...testNode) *dynamic.Schema {
+	seg := C.NewBuffer(nil)
+	req := caps.NewRootCodeGeneratorRequest(seg)
+
+	nl := caps.NewNodeList(seg, len(nodes)+1)
+	file := nl.At(0)
+	file.SetId(testFileID)
+	file.SetDisplayName("test.capnp")
+	file.SetAnnotations(testAnnotations(seg, nil))
+	file.SetFile()
+
+	for i, d := range nodes {
+		n := nl.At(i + 1)
+		n.SetId(d.id)
+		if !d.inPlace {
+			n.SetScopeId(testFileID)
+		}
+		n.SetDisplayName("test.capnp:" + d.name)
+		n.SetDisplayNamePrefixLength(uint32(strings.LastIndexAny("test.capnp:"+d.name, ":.") + 1))
+		n.SetAnnotations(testAnnotations(seg, nil))
+
+		switch {
+		case d.enumerants != nil:
+			n.SetEnum()
+			el := caps.NewEnumerantList(seg, len(d.enumerants))
+			for j, name := range d.enumerants {
+				el.At(j).SetName(name)
+				el.At(j).SetCodeOrder(uint16(j))
+			}
+			n.Enum().SetEnumerants(el)
+
+		case d.methods != nil:
+			n.SetInterface()
+			ml := caps.NewMethodList(seg, len(d.methods))
+			for j, tm := range d.methods {
+				ml.At(j).SetName(tm.name)
+				ml.At(j).SetCodeOrder(uint16(j))
+				ml.At(j).SetParamStructType(tm.params)
+				ml.At(j).SetResultStructType(tm.results)
+			}
+			n.Interface().SetMethods(ml)
+
+		default:
+			n.SetStruct()
+			st := n.Struct()
+			st.SetIsGroup(d.group)
+			st.SetDiscriminantCount(d.discCount)
+			st.SetDiscriminantOffset(uint32(d.discOffset))
+
+			fl := caps.NewFieldList(seg, len(d.fields))
+			for j, tf := range d.fields {
+				f := fl.At(j)
+				f.SetName(tf.name)
+				f.SetCodeOrder(uint16(j))
+				f.SetAnnotations(testAnnotations(seg, tf.anns))
+				if tf.disc >= 0 {
+					f.SetDiscriminantValue(uint16(tf.disc))
+				} else {
+					f.SetDiscriminantValue(caps.FieldNoDiscriminant)
+				}
+
+				if tf.group != 0 {
+					f.SetGroup()
+					f.Group().SetTypeId(tf.group)
+					continue
+				}
+				f.SetSlot()
+				f.Ordinal().SetExplicit(tf.ord)
+				f.Slot().SetOffset(tf.offset)
+				f.Slot().SetType(tf.typ(seg))
+				def := caps.NewValue(seg)
+				if tf.def != 0 {
+					def.SetInt64(tf.def)
+				} else {
+					def.SetVoid()
+				}
+				f.Slot().SetDefaultValue(def)
+			}
+			st.SetFields(fl)
+		}
+	}
+	req.SetNodes(nl)
+
+	rl := caps.NewCodeGeneratorRequestRequestedFileList(seg, 1)
+	rl.At(0).SetId(testFileID)
+	rl.At(0).SetFilename("test.capnp")
+	req.SetRequestedFiles(rl)
+
+	return dynamic.New(req)
+}
+
+const (
+	bookID = testFileID + 1 + iota
+	genreID
+	libraryID
+	addParamsID
+	addResultsID
+	detailsID
+)
+
+// bookNodes returns nodes of
+//
+//	struct Book {
+//	  title @0 :Text;
+//	  pages @1 :Int64;
+//	  union { paper @2 :Void; ebook @3 :Text; }
+//	}
+//	enum Genre { fiction @0; poetry @1; }
+//	interface Library { add @0 (book :Book) -> (); }
+//
+// Tests change them to get new versions of the schema.
+func bookNodes() []testNode {
+	return []testNode{
+		{id: bookID, name: "Book", discCount: 2, discOffset: 4, fields: []testField{
+			{name: "title", ord: 0, typ: textType, disc: -1},
+			{name: "pages", ord: 1, typ: int64Type, disc: -1},
+			{name: "paper", ord: 2, typ: voidType, disc: 0},
+			{name: "ebook", ord: 3, typ: textType, offset: 1, disc: 1},
+		}},
+		{id: genreID, name: "Genre", enumerants: []string{"fiction", "poetry"}},
+		{id: libraryID, name: "Library", methods: []testMethod{{"add", addParamsID, addResultsID}}},
+		{id: addParamsID, name: "Library.add$Params", inPlace: true, fields: []testField{
+			{name: "book", ord: 0, typ: structType(bookID), disc: -1},
+		}},
+		{id: addResultsID, name: "Library.add$Results", inPlace: true, fields: []testField{}},
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(nodes []testNode) []testNode
+		want   []string
+	}{
+		{"unchanged", func(nodes []testNode) []testNode { return nodes }, nil},
+
+		// Nodes
+		{"node removed", func(nodes []testNode) []testNode {
+			return append(nodes[:1], nodes[2:]...)
+		}, []string{"breaking: Genre: enum removed"}},
+		{"node added", func(nodes []testNode) []testNode {
+			return append(nodes, testNode{id: detailsID, name: "Author", fields: []testField{}})
+		}, []string{"compatible: Author: struct added"}},
+		{"node kind", func(nodes []testNode) []testNode {
+			nodes[1] = testNode{id: genreID, name: "Genre", fields: []testField{}}
+			return nodes
+		}, []string{"breaking: Genre: changed from enum to struct"}},
+		{"node renamed", func(nodes []testNode) []testNode {
+			nodes[0].name = "Novel"
+			return nodes
+		}, []string{"compatible: Novel: renamed from Book"}},
+
+		// Fields
+		{"discriminant moved", func(nodes []testNode) []testNode {
+			nodes[0].discOffset = 5
+			return nodes
+		}, []string{"breaking: Book: union discriminant moved from offset 4 to 5"}},
+		{"field removed", func(nodes []testNode) []testNode {
+			f := nodes[0].fields
+			nodes[0].fields = append(f[:1], f[2:]...)
+			return nodes
+		}, []string{"breaking: Book.pages: field @1 removed"}},
+		{"field added", func(nodes []testNode) []testNode {
+			nodes[0].fields = append(nodes[0].fields, testField{name: "isbn", ord: 4, typ: textType, offset: 2, disc: -1})
+			return nodes
+		}, []string{"compatible: Book.isbn: field @4 added"}},
+		{"required field added", func(nodes []testNode) []testNode {
+			nodes[0].fields = append(nodes[0].fields, testField{name: "isbn", ord: 4, typ: textType, offset: 2, disc: -1,
+				anns: []testAnnotation{{caps.FieldRequired, "isbn"}}})
+			return nodes
+		}, []string{"breaking: Book.isbn: required field @4 added, old messages lack it"}},
+		{"field renamed", func(nodes []testNode) []testNode {
+			nodes[0].fields[0].name = "name"
+			return nodes
+		}, []string{
+			"compatible: Book.name: renamed from title",
+			`breaking: Book.name: JSON and msgp key renamed from "title" to "name"`,
+		}},
+		{"field renamed keeping key", func(nodes []testNode) []testNode {
+			nodes[0].fields[0].name = "name"
+			nodes[0].fields[0].anns = []testAnnotation{{caps.FieldOptional, "title"}}
+			return nodes
+		}, []string{"compatible: Book.name: renamed from title"}},
+		{"field ignored", func(nodes []testNode) []testNode {
+			nodes[0].fields[0].anns = []testAnnotation{{id: caps.FieldIgnored}}
+			return nodes
+		}, []string{"breaking: Book.title: ignored by codecs, JSON and msgp leave it out"}},
+		{"key renamed", func(nodes []testNode) []testNode {
+			nodes[0].fields[0].anns = []testAnnotation{{caps.FieldOptional, "name"}}
+			return nodes
+		}, []string{`breaking: Book.title: JSON and msgp key renamed from "title" to "name"`}},
+		{"became required", func(nodes []testNode) []testNode {
+			nodes[0].fields[0].anns = []testAnnotation{{caps.FieldRequired, "title"}}
+			return nodes
+		}, []string{"breaking: Book.title: became required, old messages may lack it"}},
+		{"moved into union", func(nodes []testNode) []testNode {
+			nodes[0].fields[1].disc = 2
+			return nodes
+		}, []string{"breaking: Book.pages: moved into union"}},
+		{"moved out of union", func(nodes []testNode) []testNode {
+			nodes[0].fields[3].disc = -1
+			return nodes
+		}, []string{"breaking: Book.ebook: moved out of union"}},
+		{"discriminant changed", func(nodes []testNode) []testNode {
+			nodes[0].fields[2].disc, nodes[0].fields[3].disc = 1, 0
+			return nodes
+		}, []string{
+			"breaking: Book.paper: union discriminant changed from 0 to 1",
+			"breaking: Book.ebook: union discriminant changed from 1 to 0",
+		}},
+		{"slot became group", func(nodes []testNode) []testNode {
+			nodes[0].fields[1] = testField{name: "pages", group: detailsID, disc: -1}
+			return append(nodes, testNode{id: detailsID, name: "Book.pages", group: true, fields: []testField{
+				{name: "count", ord: 1, typ: int64Type, disc: -1},
+			}})
+		}, []string{"breaking: Book.pages: changed between group and slot"}},
+		{"type changed", func(nodes []testNode) []testNode {
+			nodes[0].fields[1].typ = listType(enumType(genreID))
+			return nodes
+		}, []string{"breaking: Book.pages: type changed from Int64 to List(Genre)"}},
+		{"offset changed", func(nodes []testNode) []testNode {
+			nodes[0].fields[1].offset = 2
+			return nodes
+		}, []string{"breaking: Book.pages: moved from offset 0 to 2"}},
+		{"default changed", func(nodes []testNode) []testNode {
+			nodes[0].fields[1].def = 7
+			return nodes
+		}, []string{"breaking: Book.pages: default changed from 0 to 7"}},
+		{"semantic type changed", func(nodes []testNode) []testNode {
+			nodes[0].fields[0].anns = []testAnnotation{{id: caps.TypeUuid}}
+			return nodes
+		}, []string{"breaking: Book.title: $Type changed from none to uuid, JSON and msgp encoding changes"}},
+
+		// Enumerants
+		{"enumerant removed", func(nodes []testNode) []testNode {
+			nodes[1].enumerants = []string{"fiction"}
+			return nodes
+		}, []string{"breaking: Genre.poetry: enumerant 1 removed"}},
+		{"enumerant renamed", func(nodes []testNode) []testNode {
+			nodes[1].enumerants = []string{"fiction", "verse"}
+			return nodes
+		}, []string{"breaking: Genre.verse: enumerant 1 renamed from poetry, JSON names change"}},
+		{"enumerant added", func(nodes []testNode) []testNode {
+			nodes[1].enumerants = []string{"fiction", "poetry", "drama"}
+			return nodes
+		}, []string{"compatible: Genre.drama: enumerant 2 added"}},
+
+		// Methods
+		{"method removed", func(nodes []testNode) []testNode {
+			nodes[2].methods = []testMethod{}
+			return nodes
+		}, []string{"breaking: Library.add: method @0 removed"}},
+		{"method renamed", func(nodes []testNode) []testNode {
+			nodes[2].methods[0].name = "put"
+			return nodes
+		}, []string{"compatible: Library.put: renamed from add"}},
+		{"method added", func(nodes []testNode) []testNode {
+			nodes[2].methods = append(nodes[2].methods, testMethod{"list", addResultsID, addParamsID})
+			return nodes
+		}, []string{"compatible: Library.list: method @1 added"}},
+		{"parameters changed type", func(nodes []testNode) []testNode {
+			nodes[2].methods[0].params = bookID
+			return nodes
+		}, []string{"breaking: Library.add$Params: parameters changed type"}},
+		{"parameter changed", func(nodes []testNode) []testNode {
+			nodes[3].fields[0].typ = enumType(genreID)
+			return nodes
+		}, []string{"breaking: Library.add$Params.book: type changed from Book to Genre"}},
+	}
+
+	for _, tt := range tests {
+		changes := Compare(testSchema(bookNodes()...), testSchema(tt.change(bookNodes())...))
+
+		var got []string
+		breaking := false
+		for _, c := range changes {
+			got = append(got, c.String())
+			breaking = breaking || strings.HasPrefix(c.String(), "breaking:")
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: changes are\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+		if Breaking(changes) != breaking {
+			t.Errorf("%s: Breaking is %v", tt.name, !breaking)
+		}
+	}
+}
+
+// TestCompareGroups checks that groups are matched by ordinals of their
+// fields, so renamed groups are compared field by field.
+func TestCompareGroups(t *testing.T) {
+	group := func(name string, offset uint32) []testNode {
+		return []testNode{
+			{id: bookID, name: "Book", fields: []testField{
+				{name: name, group: detailsID, disc: -1},
+			}},
+			{id: detailsID, name: "Book." + name, group: true, fields: []testField{
+				{name: "pages", ord: 0, typ: int64Type, offset: offset, disc: -1},
+			}},
+		}
+	}
+
+	changes := Compare(testSchema(group("details", 0)...), testSchema(group("info", 1)...))
+	want := []Change{
+		{false, "Book.info", "renamed from details"},
+		{true, "Book.info", `JSON and msgp key renamed from "details" to "info"`},
+		{true, "Book.info.pages", "moved from offset 0 to 1"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes are %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d is %v, want %v", i, changes[i], want[i])
+		}
+	}
+}
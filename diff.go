@@ -0,0 +1,17 @@
+package caps
+
+import "fmt"
+
+// FieldChange is a change of field value reported by generated Diff
+// methods. Path follows schema names of the fields like
+// "description.review" or "authors[1].email". Old is nil for appended list
+// elements and New is nil for removed ones.
+type FieldChange struct {
+	Path string
+	Old  interface{}
+	New  interface{}
+}
+
+func (c FieldChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Path, c.Old, c.New)
+}
@@ -0,0 +1,10 @@
+package caps
+
+import "testing"
+
+func TestFieldChange(t *testing.T) {
+	c := FieldChange{Path: "authors[1].email", Old: nil, New: "a@b"}
+	if s := c.String(); s != "authors[1].email: <nil> -> a@b" {
+		t.Errorf("change is written as %s", s)
+	}
+}
@@ -0,0 +1,73 @@
+package dynamic
+
+import (
+	"github.com/tpukep/caps"
+	"github.com/tpukep/caps/caplit"
+)
+
+// AppendCapLit appends the struct in Cap'n Proto text format. Literal uses
+// field names of the schema and includes active union members only. Null
+// struct pointers are left out.
+func (st *Struct) AppendCapLit(b []byte) ([]byte, error) {
+	b = append(b, '(')
+
+	for _, fv := range st.Fields {
+		if !fv.Active || fv.null() {
+			continue
+		}
+
+		b = caplit.AppendField(b, fv.Field.Name())
+		if g, ok := fv.Value.(*Struct); ok && fv.Field.Which() == caps.FIELD_GROUP {
+			b, _ = g.AppendCapLit(b)
+		} else {
+			b = appendCapLitValue(b, fv.Field.Slot().Type(), fv.Value)
+		}
+	}
+
+	return append(b, ')'), nil
+}
+
+func appendCapLitValue(b []byte, t caps.Type, v Value) []byte {
+	switch v := v.(type) {
+	case nil:
+		if t.Which() == caps.TYPE_STRUCT {
+			// Element of struct list
+			return append(b, "()"...)
+		}
+		return append(b, "void"...)
+	case bool:
+		return caplit.AppendBool(b, v)
+	case int64:
+		return caplit.AppendInt(b, v)
+	case uint64:
+		return caplit.AppendUint(b, v)
+	case float64:
+		if t.Which() == caps.TYPE_FLOAT32 {
+			return caplit.AppendFloat(b, v, 32)
+		}
+		return caplit.AppendFloat(b, v, 64)
+	case string:
+		return caplit.AppendString(b, v)
+	case []byte:
+		return caplit.AppendData(b, v)
+	case Enum:
+		if name := v.Name(); name != "" {
+			return append(b, name...)
+		}
+		return caplit.AppendUint(b, uint64(v.Value))
+	case *Struct:
+		b, _ = v.AppendCapLit(b)
+		return b
+	case []Value:
+		et := t.List().ElementType()
+		b = append(b, '[')
+		for i, e := range v {
+			if i > 0 {
+				b = append(b, ", "...)
+			}
+			b = appendCapLitValue(b, et, e)
+		}
+		return append(b, ']')
+	}
+	return append(b, "void"...)
+}
@@ -0,0 +1,261 @@
+package dynamic
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+// Nesting limit of decoded structs and lists
+const maxDepth = 64
+
+// ReadMessage reads root struct of type n from Cap'n Proto message.
+func (s *Schema) ReadMessage(r io.Reader, n caps.Node, packed bool) (*Struct, error) {
+	var seg *C.Segment
+	var err error
+
+	if packed {
+		seg, err = C.ReadFromPackedStream(r, nil)
+	} else {
+		seg, err = C.ReadFromStream(r, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Decode(n, seg.Root(0))
+}
+
+// Decode decodes struct of type n. Null pointers are decoded as default
+// values of the fields, null struct pointers with no explicit default as
+// nil.
+func (s *Schema) Decode(n caps.Node, obj C.Object) (st *Struct, err error) {
+	if n.Which() != caps.NODE_STRUCT {
+		return nil, fmt.Errorf("%s is not a struct", n.DisplayName())
+	}
+
+	defer func() {
+		// Malformed message makes accessors panic on out of range offsets
+		if r := recover(); r != nil {
+			st, err = nil, fmt.Errorf("malformed message: %v", r)
+		}
+	}()
+
+	d := decoder{s}
+	return d.structValue(n, C.Struct(obj), 0)
+}
+
+type decoder struct {
+	*Schema
+}
+
+func (d decoder) structValue(n caps.Node, p C.Struct, depth int) (*Struct, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%s: message is nested too deep", n.DisplayName())
+	}
+
+	st := &Struct{Node: n}
+	disc := p.Get16(int(n.Struct().DiscriminantOffset()) * 2)
+
+	for _, f := range Fields(n) {
+		fv := FieldValue{Field: f, Active: true}
+		if fd := f.DiscriminantValue(); fd != caps.FieldNoDiscriminant {
+			fv.Active = fd == disc
+		}
+
+		if f.Which() == caps.FIELD_GROUP {
+			g, err := d.Node(f.Group().TypeId())
+			if err != nil {
+				return nil, err
+			}
+			if fv.Active {
+				fv.Value, err = d.structValue(g, p, depth+1)
+			}
+			if err != nil {
+				return nil, err
+			}
+			st.Fields = append(st.Fields, fv)
+			continue
+		}
+
+		slot := f.Slot()
+		switch slot.Type().Which() {
+		case caps.TYPE_INTERFACE:
+			continue
+		case caps.TYPE_VOID:
+			if f.DiscriminantValue() == caps.FieldNoDiscriminant {
+				continue
+			}
+		}
+
+		if fv.Active {
+			v, err := d.slot(p, slot, depth)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", n.DisplayName()[n.DisplayNamePrefixLength():], f.Name(), err)
+			}
+			fv.Value = v
+		}
+		st.Fields = append(st.Fields, fv)
+	}
+
+	return st, nil
+}
+
+// slot reads field value. Data section holds values XORed with defaults.
+func (d decoder) slot(p C.Struct, slot caps.FieldSlot, depth int) (Value, error) {
+	t, def := slot.Type(), slot.DefaultValue()
+	off := int(slot.Offset())
+
+	switch t.Which() {
+	case caps.TYPE_VOID:
+		return nil, nil
+	case caps.TYPE_BOOL:
+		return p.Get1(off) != def.Bool(), nil
+	case caps.TYPE_INT8:
+		return int64(int8(p.Get8(off) ^ uint8(def.Int8()))), nil
+	case caps.TYPE_INT16:
+		return int64(int16(p.Get16(off*2) ^ uint16(def.Int16()))), nil
+	case caps.TYPE_INT32:
+		return int64(int32(p.Get32(off*4) ^ uint32(def.Int32()))), nil
+	case caps.TYPE_INT64:
+		return int64(p.Get64(off*8) ^ uint64(def.Int64())), nil
+	case caps.TYPE_UINT8:
+		return uint64(p.Get8(off) ^ def.Uint8()), nil
+	case caps.TYPE_UINT16:
+		return uint64(p.Get16(off*2) ^ def.Uint16()), nil
+	case caps.TYPE_UINT32:
+		return uint64(p.Get32(off*4) ^ def.Uint32()), nil
+	case caps.TYPE_UINT64:
+		return p.Get64(off*8) ^ def.Uint64(), nil
+	case caps.TYPE_FLOAT32:
+		bits := p.Get32(off*4) ^ math.Float32bits(def.Float32())
+		return float64(math.Float32frombits(bits)), nil
+	case caps.TYPE_FLOAT64:
+		bits := p.Get64(off*8) ^ math.Float64bits(def.Float64())
+		return math.Float64frombits(bits), nil
+	case caps.TYPE_ENUM:
+		n, err := d.Node(t.Enum().TypeId())
+		return Enum{Node: n, Value: p.Get16(off*2) ^ def.Enum()}, err
+	}
+
+	// Pointer fields fall back to default value if null
+	obj := p.GetObject(off)
+	if obj.Type() == C.TypeNull {
+		switch t.Which() {
+		case caps.TYPE_TEXT:
+			return def.Text(), nil
+		case caps.TYPE_DATA:
+			return def.Data(), nil
+		case caps.TYPE_STRUCT:
+			if !slot.HadExplicitDefault() {
+				return nil, nil
+			}
+			obj = def.Struct()
+		case caps.TYPE_LIST:
+			obj = def.List()
+		}
+	}
+	return d.pointer(t, obj, depth)
+}
+
+func (d decoder) pointer(t caps.Type, obj C.Object, depth int) (Value, error) {
+	switch t.Which() {
+	case caps.TYPE_TEXT:
+		return obj.ToText(), nil
+	case caps.TYPE_DATA:
+		return obj.ToData(), nil
+	case caps.TYPE_STRUCT:
+		if obj.Type() == C.TypeNull {
+			return nil, nil
+		}
+		n, err := d.Node(t.Struct().TypeId())
+		if err != nil {
+			return nil, err
+		}
+		return d.structValue(n, C.Struct(obj), depth+1)
+	case caps.TYPE_LIST:
+		if obj.Type() == C.TypeNull {
+			return []Value(nil), nil
+		}
+		return d.list(t.List().ElementType(), obj, depth+1)
+	}
+	// AnyPointer and Interface can't be decoded without schema
+	return nil, nil
+}
+
+func (d decoder) list(et caps.Type, obj C.Object, depth int) (Value, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("message is nested too deep")
+	}
+
+	var l []Value
+	switch et.Which() {
+	case caps.TYPE_VOID:
+		l = make([]Value, C.VoidList(obj).Len())
+	case caps.TYPE_BOOL:
+		for _, v := range C.BitList(obj).ToArray() {
+			l = append(l, v)
+		}
+	case caps.TYPE_INT8:
+		for _, v := range C.Int8List(obj).ToArray() {
+			l = append(l, int64(v))
+		}
+	case caps.TYPE_INT16:
+		for _, v := range C.Int16List(obj).ToArray() {
+			l = append(l, int64(v))
+		}
+	case caps.TYPE_INT32:
+		for _, v := range C.Int32List(obj).ToArray() {
+			l = append(l, int64(v))
+		}
+	case caps.TYPE_INT64:
+		for _, v := range C.Int64List(obj).ToArray() {
+			l = append(l, v)
+		}
+	case caps.TYPE_UINT8:
+		for _, v := range C.UInt8List(obj).ToArray() {
+			l = append(l, uint64(v))
+		}
+	case caps.TYPE_UINT16:
+		for _, v := range C.UInt16List(obj).ToArray() {
+			l = append(l, uint64(v))
+		}
+	case caps.TYPE_UINT32:
+		for _, v := range C.UInt32List(obj).ToArray() {
+			l = append(l, uint64(v))
+		}
+	case caps.TYPE_UINT64:
+		for _, v := range C.UInt64List(obj).ToArray() {
+			l = append(l, v)
+		}
+	case caps.TYPE_FLOAT32:
+		for _, v := range C.Float32List(obj).ToArray() {
+			l = append(l, float64(v))
+		}
+	case caps.TYPE_FLOAT64:
+		for _, v := range C.Float64List(obj).ToArray() {
+			l = append(l, v)
+		}
+	case caps.TYPE_ENUM:
+		n, err := d.Node(et.Enum().TypeId())
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range C.UInt16List(obj).ToArray() {
+			l = append(l, Enum{Node: n, Value: v})
+		}
+	default:
+		pl := C.PointerList(obj)
+		for i := 0; i < pl.Len(); i++ {
+			v, err := d.pointer(et, pl.At(i), depth)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			l = append(l, v)
+		}
+	}
+	return l, nil
+}
@@ -0,0 +1,140 @@
+package dynamic
+
+import (
+	"bytes"
+	"testing"
+
+	C "github.com/glycerine/go-capnproto"
+)
+
+// newNode allocates Node of the recursive schema holding values of the
+// chain, the last one has null next.
+func newNode(seg *C.Segment, root bool, values ...int64) C.Struct {
+	var p C.Struct
+	if root {
+		p = seg.NewRootStruct(8, 1)
+	} else {
+		p = seg.NewStruct(8, 1)
+	}
+	p.Set64(0, uint64(values[0]))
+	if len(values) > 1 {
+		p.SetObject(0, C.Object(newNode(seg, false, values[1:]...)))
+	}
+	return p
+}
+
+func TestDecodeNullStruct(t *testing.T) {
+	s := recursiveSchema()
+	seg := C.NewBuffer(nil)
+
+	st, err := s.Decode(mustStruct(s, "Node"), C.Object(newNode(seg, true, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := st.Fields[1].Value; v != nil {
+		t.Errorf("null next decoded as %v, want nil", v)
+	}
+
+	data, _ := st.MarshalJSON()
+	if want := `{"value":1,"next":null}`; string(data) != want {
+		t.Errorf("JSON is %s, want %s", data, want)
+	}
+	lit, _ := st.AppendCapLit(nil)
+	if want := `(value = 1)`; string(lit) != want {
+		t.Errorf("text format is %s, want %s", lit, want)
+	}
+}
+
+func TestDecodeRecursive(t *testing.T) {
+	s := recursiveSchema()
+	seg := C.NewBuffer(nil)
+
+	st, err := s.Decode(mustStruct(s, "Node"), C.Object(newNode(seg, true, 1, 2, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := st.MarshalJSON()
+	if want := `{"value":1,"next":{"value":2,"next":{"value":3,"next":null}}}`; string(data) != want {
+		t.Errorf("JSON is %s, want %s", data, want)
+	}
+
+	// Chains longer than nesting limit are rejected
+	values := make([]int64, maxDepth+2)
+	if _, err := s.Decode(mustStruct(s, "Node"), C.Object(newNode(C.NewBuffer(nil), true, values...))); err == nil {
+		t.Errorf("chain of %d nodes decoded", len(values))
+	}
+}
+
+func TestDecodeRecursiveUnion(t *testing.T) {
+	s := recursiveSchema()
+	tree := mustStruct(s, "Tree")
+
+	// Tree(node = Tree(leaf = 7))
+	seg := C.NewBuffer(nil)
+	root := seg.NewRootStruct(16, 1)
+	root.Set16(8, 1)
+	inner := seg.NewStruct(16, 1)
+	inner.Set64(0, 7)
+	root.SetObject(0, C.Object(inner))
+
+	st, err := s.Decode(tree, C.Object(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Fields[0].Active || st.Fields[0].Value != nil {
+		t.Errorf("inactive leaf is %+v, want nil", st.Fields[0])
+	}
+	lit, _ := st.AppendCapLit(nil)
+	if want := `(node = (leaf = 7))`; string(lit) != want {
+		t.Errorf("text format is %s, want %s", lit, want)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteMessage(&buf, st, false); err != nil {
+		t.Fatal(err)
+	}
+	back, err := s.ReadMessage(&buf, tree, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lit, _ := back.AppendCapLit(nil); string(lit) != `(node = (leaf = 7))` {
+		t.Errorf("round trip text format is %s", lit)
+	}
+}
+
+func TestZeroRecursive(t *testing.T) {
+	s := recursiveSchema()
+
+	st := s.zeroStruct(mustStruct(s, "Tree"))
+	if !st.Fields[0].Active || st.Fields[0].Value != int64(0) {
+		t.Errorf("leaf of zero Tree is %+v", st.Fields[0])
+	}
+	if st.Fields[1].Active || st.Fields[1].Value != nil {
+		t.Errorf("node of zero Tree is %+v", st.Fields[1])
+	}
+	if v := Zero(s, structType(treeID)(C.NewBuffer(nil))); v != nil {
+		t.Errorf("zero Tree is %v, want nil", v)
+	}
+}
+
+func TestEncodeNullStruct(t *testing.T) {
+	s := recursiveSchema()
+	forest := mustStruct(s, "Forest")
+
+	// Elements of struct lists can't be null, they are written zero
+	st := s.zeroStruct(forest)
+	st.Fields[0].Value = []Value{nil, s.zeroStruct(mustStruct(s, "Tree"))}
+
+	var buf bytes.Buffer
+	if err := s.WriteMessage(&buf, st, true); err != nil {
+		t.Fatal(err)
+	}
+	back, err := s.ReadMessage(&buf, forest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lit, _ := back.AppendCapLit(nil)
+	if want := `(trees = [(leaf = 0), (leaf = 0)])`; string(lit) != want {
+		t.Errorf("text format is %s, want %s", lit, want)
+	}
+}
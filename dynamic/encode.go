@@ -0,0 +1,257 @@
+package dynamic
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+// WriteMessage writes the struct as root of Cap'n Proto message.
+func (s *Schema) WriteMessage(w io.Writer, st *Struct, packed bool) error {
+	seg := C.NewBuffer(nil)
+
+	if _, err := s.Encode(seg, st, true); err != nil {
+		return err
+	}
+
+	var err error
+	if packed {
+		_, err = seg.WriteToPacked(w)
+	} else {
+		_, err = seg.WriteTo(w)
+	}
+	return err
+}
+
+// Encode allocates the struct in segment, as message root if root is set.
+func (s *Schema) Encode(seg *C.Segment, st *Struct, root bool) (p C.Struct, err error) {
+	defer func() {
+		// Values of unexpected Go types make type assertions panic
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid value: %v", r)
+		}
+	}()
+
+	e := encoder{s, seg}
+	if root {
+		p = seg.NewRootStruct(structSize(st.Node))
+	} else {
+		p = seg.NewStruct(structSize(st.Node))
+	}
+	err = e.structValue(p, st)
+	return p, err
+}
+
+// structSize returns size of data section in bytes and number of pointers
+func structSize(n caps.Node) (int, int) {
+	return int(n.Struct().DataWordCount()) * 8, int(n.Struct().PointerCount())
+}
+
+type encoder struct {
+	*Schema
+	seg *C.Segment
+}
+
+func (e encoder) structValue(p C.Struct, st *Struct) error {
+	for _, fv := range st.Fields {
+		if !fv.Active {
+			continue
+		}
+
+		f := fv.Field
+		if disc := f.DiscriminantValue(); disc != caps.FieldNoDiscriminant {
+			p.Set16(int(st.Node.Struct().DiscriminantOffset())*2, disc)
+		}
+
+		// Groups share data and pointer sections of the parent
+		if f.Which() == caps.FIELD_GROUP {
+			if g, ok := fv.Value.(*Struct); ok && g != nil {
+				if err := e.structValue(p, g); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := e.slot(p, f.Slot(), fv.Value); err != nil {
+			return fmt.Errorf("%s: %v", f.Name(), err)
+		}
+	}
+	return nil
+}
+
+// slot writes field value. Data section holds values XORed with defaults.
+func (e encoder) slot(p C.Struct, slot caps.FieldSlot, v Value) error {
+	t, def := slot.Type(), slot.DefaultValue()
+	off := int(slot.Offset())
+
+	switch t.Which() {
+	case caps.TYPE_VOID:
+	case caps.TYPE_BOOL:
+		p.Set1(off, v.(bool) != def.Bool())
+	case caps.TYPE_INT8:
+		p.Set8(off, uint8(v.(int64))^uint8(def.Int8()))
+	case caps.TYPE_INT16:
+		p.Set16(off*2, uint16(v.(int64))^uint16(def.Int16()))
+	case caps.TYPE_INT32:
+		p.Set32(off*4, uint32(v.(int64))^uint32(def.Int32()))
+	case caps.TYPE_INT64:
+		p.Set64(off*8, uint64(v.(int64))^uint64(def.Int64()))
+	case caps.TYPE_UINT8:
+		p.Set8(off, uint8(v.(uint64))^def.Uint8())
+	case caps.TYPE_UINT16:
+		p.Set16(off*2, uint16(v.(uint64))^def.Uint16())
+	case caps.TYPE_UINT32:
+		p.Set32(off*4, uint32(v.(uint64))^def.Uint32())
+	case caps.TYPE_UINT64:
+		p.Set64(off*8, v.(uint64)^def.Uint64())
+	case caps.TYPE_FLOAT32:
+		p.Set32(off*4, math.Float32bits(float32(v.(float64)))^math.Float32bits(def.Float32()))
+	case caps.TYPE_FLOAT64:
+		p.Set64(off*8, math.Float64bits(v.(float64))^math.Float64bits(def.Float64()))
+	case caps.TYPE_ENUM:
+		p.Set16(off*2, v.(Enum).Value^def.Enum())
+	default:
+		obj, err := e.pointer(t, v)
+		if err != nil {
+			return err
+		}
+		p.SetObject(off, obj)
+	}
+	return nil
+}
+
+func (e encoder) pointer(t caps.Type, v Value) (C.Object, error) {
+	switch t.Which() {
+	case caps.TYPE_TEXT:
+		return e.seg.NewText(v.(string)), nil
+	case caps.TYPE_DATA:
+		if v.([]byte) == nil {
+			return C.Object{}, nil
+		}
+		return e.seg.NewData(v.([]byte)), nil
+	case caps.TYPE_STRUCT:
+		if v == nil {
+			return C.Object{}, nil
+		}
+		st := v.(*Struct)
+		p := e.seg.NewStruct(structSize(st.Node))
+		return C.Object(p), e.structValue(p, st)
+	case caps.TYPE_LIST:
+		if v.([]Value) == nil {
+			return C.Object{}, nil
+		}
+		return e.list(t.List().ElementType(), v.([]Value))
+	}
+	// AnyPointer and Interface are left null
+	return C.Object{}, nil
+}
+
+func (e encoder) list(et caps.Type, l []Value) (C.Object, error) {
+	switch et.Which() {
+	case caps.TYPE_VOID:
+		return C.Object(e.seg.NewVoidList(len(l))), nil
+	case caps.TYPE_BOOL:
+		bl := e.seg.NewBitList(len(l))
+		for i, v := range l {
+			bl.Set(i, v.(bool))
+		}
+		return C.Object(bl), nil
+	case caps.TYPE_INT8:
+		il := e.seg.NewInt8List(len(l))
+		for i, v := range l {
+			il.Set(i, int8(v.(int64)))
+		}
+		return C.Object(il), nil
+	case caps.TYPE_INT16:
+		il := e.seg.NewInt16List(len(l))
+		for i, v := range l {
+			il.Set(i, int16(v.(int64)))
+		}
+		return C.Object(il), nil
+	case caps.TYPE_INT32:
+		il := e.seg.NewInt32List(len(l))
+		for i, v := range l {
+			il.Set(i, int32(v.(int64)))
+		}
+		return C.Object(il), nil
+	case caps.TYPE_INT64:
+		il := e.seg.NewInt64List(len(l))
+		for i, v := range l {
+			il.Set(i, v.(int64))
+		}
+		return C.Object(il), nil
+	case caps.TYPE_UINT8:
+		ul := e.seg.NewUInt8List(len(l))
+		for i, v := range l {
+			ul.Set(i, uint8(v.(uint64)))
+		}
+		return C.Object(ul), nil
+	case caps.TYPE_UINT16:
+		ul := e.seg.NewUInt16List(len(l))
+		for i, v := range l {
+			ul.Set(i, uint16(v.(uint64)))
+		}
+		return C.Object(ul), nil
+	case caps.TYPE_UINT32:
+		ul := e.seg.NewUInt32List(len(l))
+		for i, v := range l {
+			ul.Set(i, uint32(v.(uint64)))
+		}
+		return C.Object(ul), nil
+	case caps.TYPE_UINT64:
+		ul := e.seg.NewUInt64List(len(l))
+		for i, v := range l {
+			ul.Set(i, v.(uint64))
+		}
+		return C.Object(ul), nil
+	case caps.TYPE_FLOAT32:
+		fl := e.seg.NewFloat32List(len(l))
+		for i, v := range l {
+			fl.Set(i, float32(v.(float64)))
+		}
+		return C.Object(fl), nil
+	case caps.TYPE_FLOAT64:
+		fl := e.seg.NewFloat64List(len(l))
+		for i, v := range l {
+			fl.Set(i, v.(float64))
+		}
+		return C.Object(fl), nil
+	case caps.TYPE_ENUM:
+		el := e.seg.NewUInt16List(len(l))
+		for i, v := range l {
+			el.Set(i, v.(Enum).Value)
+		}
+		return C.Object(el), nil
+	case caps.TYPE_STRUCT:
+		n, err := e.Node(et.Struct().TypeId())
+		if err != nil {
+			return C.Object{}, err
+		}
+		datasz, ptrs := structSize(n)
+		sl := e.seg.NewCompositeList(datasz, ptrs, len(l))
+		for i, v := range l {
+			// Elements of struct lists can't be null, nil is left zero
+			if v == nil {
+				continue
+			}
+			if err := e.structValue(C.Struct(sl.At(i)), v.(*Struct)); err != nil {
+				return C.Object{}, fmt.Errorf("[%d].%v", i, err)
+			}
+		}
+		return C.Object(sl), nil
+	}
+
+	pl := e.seg.NewPointerList(len(l))
+	for i, v := range l {
+		obj, err := e.pointer(et, v)
+		if err != nil {
+			return C.Object{}, fmt.Errorf("[%d]: %v", i, err)
+		}
+		pl.Set(i, obj)
+	}
+	return C.Object(pl), nil
+}
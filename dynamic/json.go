@@ -0,0 +1,126 @@
+package dynamic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/tpukep/caps"
+)
+
+// MarshalJSON encodes the struct as JSON object. Keys are $Field names of
+// the fields, ignored fields and inactive union members are left out.
+// Enums are encoded by names, Data and List(UInt8) as base64 strings like
+// encoding/json does for []byte. Infinite and NaN floats are encoded as
+// strings "inf", "-inf" and "nan".
+func (st *Struct) MarshalJSON() ([]byte, error) {
+	return appendJSONStruct(nil, st), nil
+}
+
+// IndentJSON returns indented JSON encoding of the struct.
+func (st *Struct) IndentJSON() []byte {
+	var b bytes.Buffer
+	json.Indent(&b, appendJSONStruct(nil, st), "", "  ")
+	return b.Bytes()
+}
+
+func appendJSONString(b []byte, s string) []byte {
+	data, _ := json.Marshal(s)
+	return append(b, data...)
+}
+
+func appendJSONStruct(b []byte, st *Struct) []byte {
+	b = append(b, '{')
+	first := true
+
+	for _, fv := range st.Fields {
+		c := caps.CodecOf(fv.Field)
+		if c.Ignored || !fv.Active {
+			continue
+		}
+
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+
+		b = appendJSONString(b, c.Name)
+		b = append(b, ':')
+
+		if g, ok := fv.Value.(*Struct); ok && fv.Field.Which() == caps.FIELD_GROUP {
+			b = appendJSONStruct(b, g)
+		} else if sb, ok := appendJSONSemantic(b, fv.Field, fv.Value); ok {
+			b = sb
+		} else {
+			b = appendJSONValue(b, fv.Field.Slot().Type(), fv.Value)
+		}
+	}
+
+	return append(b, '}')
+}
+
+func appendJSONFloat(b []byte, f float64, bitSize int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(b, `"nan"`...)
+	case math.IsInf(f, 1):
+		return append(b, `"inf"`...)
+	case math.IsInf(f, -1):
+		return append(b, `"-inf"`...)
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, bitSize)
+}
+
+func appendJSONValue(b []byte, t caps.Type, v Value) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, "null"...)
+	case bool:
+		return strconv.AppendBool(b, v)
+	case int64:
+		return strconv.AppendInt(b, v, 10)
+	case uint64:
+		return strconv.AppendUint(b, v, 10)
+	case float64:
+		if t.Which() == caps.TYPE_FLOAT32 {
+			return appendJSONFloat(b, v, 32)
+		}
+		return appendJSONFloat(b, v, 64)
+	case string:
+		return appendJSONString(b, v)
+	case []byte:
+		return appendJSONString(b, base64.StdEncoding.EncodeToString(v))
+	case Enum:
+		if name := v.Name(); name != "" {
+			return appendJSONString(b, name)
+		}
+		return strconv.AppendUint(b, uint64(v.Value), 10)
+	case *Struct:
+		return appendJSONStruct(b, v)
+	case []Value:
+		et := t.List().ElementType()
+		if et.Which() == caps.TYPE_UINT8 {
+			data := make([]byte, len(v))
+			for i, e := range v {
+				data[i] = uint8(e.(uint64))
+			}
+			return appendJSONValue(b, t, data)
+		}
+
+		if v == nil {
+			return append(b, "null"...)
+		}
+
+		b = append(b, '[')
+		for i, e := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONValue(b, et, e)
+		}
+		return append(b, ']')
+	}
+	return append(b, "null"...)
+}
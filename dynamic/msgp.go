@@ -0,0 +1,559 @@
+package dynamic
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tinylib/msgp/msgp"
+	"github.com/tpukep/caps"
+)
+
+// AppendMsgp appends MessagePack encoding of the struct, as MarshalMsg of
+// the generated code does. Fields follow generated Go structs: keys are
+// $Field names if the file enables msgp codec and Go field names otherwise,
+// inactive union members are written with zero values, enums as numbers.
+// Presence fields holding default values are written as nil, as unset
+// pointers of the generated code are. Null structs are written as zero
+// structs, except ones of recursive types, which are written as nil.
+// Structs of files with msgpTuple are written as arrays in ordinal order.
+func (s *Schema) AppendMsgp(b []byte, st *Struct) []byte {
+	return s.appendMsgpStruct(b, st, false)
+}
+
+func (s *Schema) hasCodec(n caps.Node, codec uint64) bool {
+	return caps.HasAnnotation(s.file(n).Annotations(), codec)
+}
+
+// goFieldName returns name of the field in generated Go struct
+func goFieldName(f caps.Field) string {
+	name := f.Name()
+	for _, a := range f.Annotations().ToArray() {
+		if a.Id() == C.Name && a.Value().Text() != "" {
+			name = a.Value().Text()
+		}
+	}
+	return strings.Title(name)
+}
+
+// fieldNumber returns ordinal of the field plus one. Groups take the least
+// number of their members.
+func (s *Schema) fieldNumber(f caps.Field) int {
+	if f.Which() == caps.FIELD_GROUP {
+		min := -1
+		if g, err := s.Node(f.Group().TypeId()); err == nil {
+			for _, gf := range g.Struct().Fields().ToArray() {
+				if num := s.fieldNumber(gf); min == -1 || num < min {
+					min = num
+				}
+			}
+		}
+		return min
+	}
+	return int(f.Ordinal().Explicit()) + 1
+}
+
+type byFieldNumber struct {
+	s      *Schema
+	fields []caps.Field
+}
+
+func (o byFieldNumber) Len() int { return len(o.fields) }
+func (o byFieldNumber) Less(i, j int) bool {
+	return o.s.fieldNumber(o.fields[i]) < o.s.fieldNumber(o.fields[j])
+}
+func (o byFieldNumber) Swap(i, j int) { o.fields[i], o.fields[j] = o.fields[j], o.fields[i] }
+
+// msgpLayout describes how generated code writes struct fields: keys of
+// the map or order of the tuple elements.
+type msgpLayout struct {
+	fields []caps.Field
+	keys   []string
+	tuple  bool
+}
+
+// Groups are anonymous structs of generated code, codecs don't apply to
+// their fields and they are always written as maps.
+func (s *Schema) msgpLayout(n caps.Node, group bool) msgpLayout {
+	codec := !group && s.hasCodec(n, caps.CodecMsgp)
+	l := msgpLayout{tuple: codec && (s.hasCodec(n, caps.CodecMsgpTuple) || caps.HasAnnotation(n.Annotations(), caps.CodecMsgpTuple))}
+
+	for _, f := range Fields(n) {
+		// Void and Interface fields have no Go fields
+		if f.Which() == caps.FIELD_SLOT {
+			switch f.Slot().Type().Which() {
+			case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+				continue
+			}
+		}
+		if codec && caps.CodecOf(f).Ignored {
+			continue
+		}
+		l.fields = append(l.fields, f)
+	}
+
+	if l.tuple {
+		sort.Stable(byFieldNumber{s, l.fields})
+	}
+
+	for _, f := range l.fields {
+		// Group fields have no tags
+		if codec && f.Which() != caps.FIELD_GROUP {
+			l.keys = append(l.keys, caps.CodecOf(f).Name)
+		} else {
+			l.keys = append(l.keys, goFieldName(f))
+		}
+	}
+	return l
+}
+
+func (s *Schema) appendMsgpStruct(b []byte, st *Struct, group bool) []byte {
+	l := s.msgpLayout(st.Node, group)
+
+	values := make(map[uint16]Value)
+	for _, fv := range st.Fields {
+		values[fv.Field.CodeOrder()] = fv.Value
+	}
+
+	if l.tuple {
+		b = msgp.AppendArrayHeader(b, uint32(len(l.fields)))
+	} else {
+		b = msgp.AppendMapHeader(b, uint32(len(l.fields)))
+	}
+
+	for i, f := range l.fields {
+		if !l.tuple {
+			b = msgp.AppendString(b, l.keys[i])
+		}
+
+		// Inactive union members are nil
+		v := values[f.CodeOrder()]
+		if v == nil && f.Which() == caps.FIELD_SLOT {
+			v = Zero(s, f.Slot().Type())
+		}
+
+		switch {
+		case f.Which() == caps.FIELD_GROUP:
+			g, _ := v.(*Struct)
+			if g == nil {
+				n, _ := s.Node(f.Group().TypeId())
+				g = s.zeroStruct(n)
+			}
+			b = s.appendMsgpStruct(b, g, true)
+		case s.presence(st.Node, f) && v == s.DefaultValue(f.Slot()):
+			b = msgp.AppendNil(b)
+		default:
+			if sb, ok := appendMsgpSemantic(b, f, v); ok {
+				b = sb
+			} else {
+				b = s.appendMsgpValue(b, f.Slot().Type(), v)
+			}
+		}
+	}
+	return b
+}
+
+func (s *Schema) appendMsgpValue(b []byte, t caps.Type, v Value) []byte {
+	switch t.Which() {
+	case caps.TYPE_BOOL:
+		return msgp.AppendBool(b, v.(bool))
+	case caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_INT32, caps.TYPE_INT64:
+		return msgp.AppendInt64(b, v.(int64))
+	case caps.TYPE_UINT8, caps.TYPE_UINT16, caps.TYPE_UINT32, caps.TYPE_UINT64:
+		return msgp.AppendUint64(b, v.(uint64))
+	case caps.TYPE_FLOAT32:
+		return msgp.AppendFloat32(b, float32(v.(float64)))
+	case caps.TYPE_FLOAT64:
+		return msgp.AppendFloat64(b, v.(float64))
+	case caps.TYPE_TEXT:
+		return msgp.AppendString(b, v.(string))
+	case caps.TYPE_DATA:
+		return msgp.AppendBytes(b, v.([]byte))
+	case caps.TYPE_ENUM:
+		return msgp.AppendUint16(b, v.(Enum).Value)
+	case caps.TYPE_STRUCT:
+		if v != nil {
+			return s.appendMsgpStruct(b, v.(*Struct), false)
+		}
+		// Generated code keeps structs by value, null ones are zero
+		id := t.Struct().TypeId()
+		n, err := s.Node(id)
+		if err != nil || s.reaches(id, id, make(map[uint64]bool)) {
+			return msgp.AppendNil(b)
+		}
+		return s.appendMsgpStruct(b, s.zeroStruct(n), false)
+	case caps.TYPE_LIST:
+		return s.appendMsgpList(b, t.List().ElementType(), v.([]Value))
+	}
+	// AnyPointer is interface{} holding nil
+	return msgp.AppendNil(b)
+}
+
+func (s *Schema) appendMsgpList(b []byte, et caps.Type, l []Value) []byte {
+	if et.Which() == caps.TYPE_DATA {
+		// List(Data) is generated as []byte, its elements don't fit in
+		return msgp.AppendBytes(b, nil)
+	}
+
+	b = msgp.AppendArrayHeader(b, uint32(len(l)))
+	for _, v := range l {
+		switch et.Which() {
+		case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+			// Elements of []struct{}
+			b = msgp.AppendMapHeader(b, 0)
+		case caps.TYPE_INT32:
+			// List(Int32) is generated as []uint32
+			b = msgp.AppendUint32(b, uint32(v.(int64)))
+		case caps.TYPE_LIST, caps.TYPE_ANYPOINTER:
+			// Elements of []interface{}
+			b, _ = msgp.AppendIntf(b, intfValue(v))
+		default:
+			b = s.appendMsgpValue(b, et, v)
+		}
+	}
+	return b
+}
+
+// reaches reports whether struct from holds struct to in its fields or
+// fields of nested structs. Generated Go structs can't hold themselves by
+// value, so null structs of recursive types have no zero value.
+func (s *Schema) reaches(from, to uint64, seen map[uint64]bool) bool {
+	n, err := s.Node(from)
+	if err != nil {
+		return false
+	}
+
+	for _, f := range Fields(n) {
+		var id uint64
+		switch {
+		case f.Which() == caps.FIELD_GROUP:
+			id = f.Group().TypeId()
+		case f.Slot().Type().Which() == caps.TYPE_STRUCT:
+			id = f.Slot().Type().Struct().TypeId()
+		default:
+			continue
+		}
+
+		if id == to {
+			return true
+		}
+		if !seen[id] {
+			seen[id] = true
+			if s.reaches(id, to, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// intfValue converts value to type msgp.AppendIntf can write. Structs are
+// not representable and written as nil.
+func intfValue(v Value) interface{} {
+	switch v := v.(type) {
+	case Enum:
+		return v.Value
+	case []Value:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = intfValue(e)
+		}
+		return l
+	case *Struct:
+		return nil
+	}
+	return v
+}
+
+// ReadMsgp reads struct of type n from MessagePack written by generated
+// code or AppendMsgp and returns the remaining bytes. Unknown map keys are
+// skipped, missing fields get zero values, nil structs are null. Generated
+// Go structs don't keep union discriminant, so the first union member with
+// non-zero value becomes active, or the member with discriminant 0 if all
+// are zero.
+func (s *Schema) ReadMsgp(n caps.Node, b []byte) (*Struct, []byte, error) {
+	if n.Which() != caps.NODE_STRUCT {
+		return nil, b, fmt.Errorf("%s is not a struct", n.DisplayName())
+	}
+	return s.readMsgpStruct(n, b, false, "", 0)
+}
+
+func (s *Schema) readMsgpStruct(n caps.Node, b []byte, group bool, path string, depth int) (*Struct, []byte, error) {
+	if depth > maxDepth {
+		return nil, b, errorAt(path, "value is nested too deep")
+	}
+
+	l := s.msgpLayout(n, group)
+	values := make(map[uint16]Value)
+	var err error
+
+	if l.tuple {
+		var sz uint32
+		if sz, b, err = msgp.ReadArrayHeaderBytes(b); err != nil {
+			return nil, b, errorAt(path, "%v", err)
+		}
+		if int(sz) != len(l.fields) {
+			return nil, b, errorAt(path, "expected array of %d elements, got %d", len(l.fields), sz)
+		}
+		for i, f := range l.fields {
+			var v Value
+			if v, b, err = s.readMsgpField(n, f, b, joinPath(path, l.keys[i]), depth); err != nil {
+				return nil, b, err
+			}
+			values[f.CodeOrder()] = v
+		}
+	} else {
+		var sz uint32
+		if sz, b, err = msgp.ReadMapHeaderBytes(b); err != nil {
+			return nil, b, errorAt(path, "%v", err)
+		}
+
+		for ; sz > 0; sz-- {
+			var key string
+			if key, b, err = msgp.ReadStringBytes(b); err != nil {
+				return nil, b, errorAt(path, "%v", err)
+			}
+
+			found := false
+			for i, f := range l.fields {
+				if l.keys[i] != key {
+					continue
+				}
+				var v Value
+				if v, b, err = s.readMsgpField(n, f, b, joinPath(path, key), depth); err != nil {
+					return nil, b, err
+				}
+				values[f.CodeOrder()] = v
+				found = true
+				break
+			}
+			if !found {
+				if b, err = msgp.Skip(b); err != nil {
+					return nil, b, errorAt(joinPath(path, key), "%v", err)
+				}
+			}
+		}
+	}
+
+	// Union member to make active
+	disc := uint16(0)
+	for _, f := range Fields(n) {
+		if fd := f.DiscriminantValue(); fd != caps.FieldNoDiscriminant {
+			if v, ok := values[f.CodeOrder()]; ok && !isZero(v) {
+				disc = fd
+				break
+			}
+		}
+	}
+
+	st := &Struct{Node: n}
+	for _, f := range Fields(n) {
+		fv := FieldValue{Field: f, Active: true}
+		if fd := f.DiscriminantValue(); fd != caps.FieldNoDiscriminant {
+			fv.Active = fd == disc
+		}
+
+		if f.Which() == caps.FIELD_GROUP {
+			if v, ok := values[f.CodeOrder()]; ok && fv.Active {
+				fv.Value = v
+			} else if g, err := s.Node(f.Group().TypeId()); err == nil && fv.Active {
+				fv.Value = s.zeroStruct(g)
+			} else if err != nil {
+				return nil, b, err
+			}
+			st.Fields = append(st.Fields, fv)
+			continue
+		}
+
+		switch f.Slot().Type().Which() {
+		case caps.TYPE_INTERFACE:
+			continue
+		case caps.TYPE_VOID:
+			if f.DiscriminantValue() == caps.FieldNoDiscriminant {
+				continue
+			}
+		}
+
+		if v, ok := values[f.CodeOrder()]; ok && fv.Active {
+			fv.Value = v
+		} else if fv.Active {
+			fv.Value = Zero(s, f.Slot().Type())
+		}
+		st.Fields = append(st.Fields, fv)
+	}
+
+	return st, b, nil
+}
+
+func (s *Schema) readMsgpField(n caps.Node, f caps.Field, b []byte, path string, depth int) (Value, []byte, error) {
+	if f.Which() == caps.FIELD_GROUP {
+		g, err := s.Node(f.Group().TypeId())
+		if err != nil {
+			return nil, b, err
+		}
+		return s.readMsgpStruct(g, b, true, path, depth+1)
+	}
+	if msgp.IsNil(b) && s.presence(n, f) {
+		return s.DefaultValue(f.Slot()), b[1:], nil
+	}
+	if v, o, ok, err := readMsgpSemantic(f, b, path); ok {
+		return v, o, err
+	}
+	return s.readMsgpValue(f.Slot().Type(), b, path, depth)
+}
+
+func (s *Schema) readMsgpValue(t caps.Type, b []byte, path string, depth int) (v Value, o []byte, err error) {
+	switch t.Which() {
+	case caps.TYPE_BOOL:
+		v, o, err = msgp.ReadBoolBytes(b)
+
+	case caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_INT32, caps.TYPE_INT64:
+		var i int64
+		if i, o, err = msgp.ReadInt64Bytes(b); err == nil {
+			if bits := intBits(t.Which()); bits < 64 && (i < -1<<uint(bits-1) || i > 1<<uint(bits-1)-1) {
+				return nil, o, errorAt(path, "value %d is out of range of Int%d", i, bits)
+			}
+		}
+		v = i
+
+	case caps.TYPE_UINT8, caps.TYPE_UINT16, caps.TYPE_UINT32, caps.TYPE_UINT64:
+		var u uint64
+		if u, o, err = msgp.ReadUint64Bytes(b); err == nil {
+			if bits := intBits(t.Which()); bits < 64 && u > 1<<uint(bits)-1 {
+				return nil, o, errorAt(path, "value %d is out of range of UInt%d", u, bits)
+			}
+		}
+		v = u
+
+	case caps.TYPE_FLOAT32, caps.TYPE_FLOAT64:
+		var f float64
+		if f, o, err = msgp.ReadFloat64Bytes(b); err == nil {
+			if t.Which() == caps.TYPE_FLOAT32 && !math.IsInf(f, 0) && math.Abs(f) > math.MaxFloat32 {
+				return nil, o, errorAt(path, "value %v is out of range of Float32", f)
+			}
+		}
+		v = f
+
+	case caps.TYPE_TEXT:
+		v, o, err = msgp.ReadStringBytes(b)
+
+	case caps.TYPE_DATA:
+		if msgp.IsNil(b) {
+			v, o = []byte(nil), b[1:]
+			break
+		}
+		v, o, err = msgp.ReadBytesBytes(b, nil)
+
+	case caps.TYPE_ENUM:
+		n, err := s.Node(t.Enum().TypeId())
+		if err != nil {
+			return nil, b, err
+		}
+		var e uint16
+		if e, o, err = msgp.ReadUint16Bytes(b); err != nil {
+			return nil, o, errorAt(path, "%v", err)
+		}
+		return Enum{Node: n, Value: e}, o, nil
+
+	case caps.TYPE_STRUCT:
+		if msgp.IsNil(b) {
+			return nil, b[1:], nil
+		}
+		n, err := s.Node(t.Struct().TypeId())
+		if err != nil {
+			return nil, b, err
+		}
+		return s.readMsgpStruct(n, b, false, path, depth+1)
+
+	case caps.TYPE_LIST:
+		return s.readMsgpList(t.List().ElementType(), b, path, depth+1)
+
+	default:
+		// AnyPointer can't be read without schema
+		o, err = msgp.Skip(b)
+	}
+
+	if err != nil {
+		return nil, o, errorAt(path, "%v", err)
+	}
+	return v, o, nil
+}
+
+func (s *Schema) readMsgpList(et caps.Type, b []byte, path string, depth int) (Value, []byte, error) {
+	if depth > maxDepth {
+		return nil, b, errorAt(path, "value is nested too deep")
+	}
+	if msgp.IsNil(b) {
+		return []Value(nil), b[1:], nil
+	}
+
+	if et.Which() == caps.TYPE_DATA {
+		// List(Data) is generated as []byte which can't hold the elements
+		o, err := msgp.Skip(b)
+		if err != nil {
+			return nil, o, errorAt(path, "%v", err)
+		}
+		return []Value(nil), o, nil
+	}
+
+	sz, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, b, errorAt(path, "%v", err)
+	}
+
+	l := make([]Value, sz)
+	for i := range l {
+		epath := fmt.Sprintf("%s[%d]", path, i)
+		switch et.Which() {
+		case caps.TYPE_VOID, caps.TYPE_INTERFACE:
+			b, err = msgp.Skip(b)
+		case caps.TYPE_INT32:
+			// List(Int32) is generated as []uint32
+			var u uint32
+			u, b, err = msgp.ReadUint32Bytes(b)
+			l[i] = int64(int32(u))
+		default:
+			l[i], b, err = s.readMsgpValue(et, b, epath, depth)
+			if err != nil {
+				return nil, b, err
+			}
+		}
+		if err != nil {
+			return nil, b, errorAt(epath, "%v", err)
+		}
+	}
+	return l, b, nil
+}
+
+// isZero reports whether the value is zero value of its Go type
+func isZero(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case int64:
+		return v == 0
+	case uint64:
+		return v == 0
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case []byte:
+		return len(v) == 0
+	case Enum:
+		return v.Value == 0
+	case []Value:
+		return len(v) == 0
+	case *Struct:
+		for _, fv := range v.Fields {
+			if !isZero(fv.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package dynamic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// convert writes the struct in format as caps convert does and reads it
+// back.
+func convert(s *Schema, st *Struct, format string) (*Struct, error) {
+	switch format {
+	case "capnp", "packed":
+		var buf bytes.Buffer
+		if err := s.WriteMessage(&buf, st, format == "packed"); err != nil {
+			return nil, err
+		}
+		return s.ReadMessage(&buf, st.Node, format == "packed")
+	case "msgp":
+		back, rest, err := s.ReadMsgp(st.Node, s.AppendMsgp(nil, st))
+		if err == nil && len(rest) > 0 {
+			panic("msgp is not read to the end")
+		}
+		return back, err
+	}
+	data, _ := st.MarshalJSON()
+	return s.ParseJSON(st.Node, data)
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	recursive, union := recursiveSchema(), unionSchema()
+
+	for _, tt := range []struct {
+		s    *Schema
+		name string
+		in   string
+	}{
+		{recursive, "Node", `{"value":1,"next":null}`},
+		{recursive, "Node", `{"value":1,"next":{"value":2,"next":{"value":3,"next":null}}}`},
+		{recursive, "Tree", `{"leaf":5}`},
+		{recursive, "Tree", `{"node":{"node":{"leaf":-1}}}`},
+		{recursive, "Forest", `{"trees":[{"leaf":1},{"node":{"leaf":2}}]}`},
+		{recursive, "Forest", `{"trees":[{"leaf":0}]}`},
+		{union, "Shape", `{"circle":3,"color":"green","image":"AQI="}`},
+		{union, "Shape", `{"square":"abc","color":"red","image":""}`},
+	} {
+		st, err := tt.s.ParseJSON(mustStruct(tt.s, tt.name), []byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+
+		for _, format := range []string{"capnp", "packed", "msgp", "json"} {
+			back, err := convert(tt.s, st, format)
+			if err != nil {
+				t.Errorf("%s: %s: %v", tt.in, format, err)
+				continue
+			}
+			if data, _ := back.MarshalJSON(); string(data) != tt.in {
+				t.Errorf("%s: %s round trip gives %s", tt.in, format, data)
+			}
+		}
+	}
+}
+
+func TestMsgpNullStruct(t *testing.T) {
+	s := recursiveSchema()
+
+	// Recursive types can't be generated by value, null structs are nil
+	st, _ := s.ParseJSON(mustStruct(s, "Tree"), []byte(`{"leaf":5}`))
+	var out bytes.Buffer
+	if _, err := msgp.UnmarshalAsJSON(&out, s.AppendMsgp(nil, st)); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"Leaf":5,"Node":null}`; out.String() != want {
+		t.Errorf("msgp of Tree is %s, want %s", out.String(), want)
+	}
+
+	// Inactive members of generated structs are zero
+	u := unionSchema()
+	st, _ = u.ParseJSON(mustStruct(u, "Shape"), []byte(`{"square":"abc"}`))
+	out.Reset()
+	if _, err := msgp.UnmarshalAsJSON(&out, u.AppendMsgp(nil, st)); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"Circle":0,"Square":"abc","Color":0,"Image":""}`; out.String() != want {
+		t.Errorf("msgp of Shape is %s, want %s", out.String(), want)
+	}
+}
+
+func TestReadMsgpErrors(t *testing.T) {
+	s := recursiveSchema()
+	node := mustStruct(s, "Node")
+
+	for _, tt := range []struct {
+		in  []byte
+		err string
+	}{
+		{msgp.AppendInt64(nil, 1), "<root>: msgp: attempted to decode type \"int\" with method for \"map\""},
+		{msgp.AppendInt64(msgp.AppendString(msgp.AppendMapHeader(nil, 1), "Next"), 1), "Next: msgp: attempted to decode type \"int\" with method for \"map\""},
+		{msgp.AppendMapHeader(nil, 1), "<root>: msgp: too few bytes left to read object"},
+	} {
+		_, _, err := s.ReadMsgp(node, tt.in)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%x: error is %v, want %s", tt.in, err, tt.err)
+		}
+	}
+
+	// Nesting is limited
+	var b []byte
+	for i := 0; i <= maxDepth+1; i++ {
+		b = msgp.AppendString(msgp.AppendMapHeader(b, 1), "Next")
+	}
+	if _, _, err := s.ReadMsgp(node, msgp.AppendNil(b)); err == nil {
+		t.Errorf("msgp nested %d times is read", maxDepth+2)
+	}
+}
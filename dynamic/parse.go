@@ -0,0 +1,422 @@
+package dynamic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+// ParseJSON parses JSON object as struct of type n. It accepts JSON written
+// by MarshalJSON and by encoding/json for generated Go structs: keys are
+// $Field names, enums are names or numbers, Data is base64 string or null.
+// Missing fields get default values of the schema. If several union
+// members are present, the first one with non-zero value becomes active.
+// Unknown keys, type mismatches and out of range values are reported with
+// path of the value like "authors[1].age".
+func (s *Schema) ParseJSON(n caps.Node, data []byte) (*Struct, error) {
+	if n.Which() != caps.NODE_STRUCT {
+		return nil, fmt.Errorf("%s is not a struct", n.DisplayName())
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+
+	p := parser{s}
+	return p.structValue(n, v, "", 0)
+}
+
+type parser struct {
+	*Schema
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func errorAt(path string, format string, a ...interface{}) error {
+	if path == "" {
+		path = "<root>"
+	}
+	return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, a...))
+}
+
+// jsonType returns name of JSON value type for error messages
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (p parser) structValue(n caps.Node, v interface{}, path string, depth int) (*Struct, error) {
+	if depth > maxDepth {
+		return nil, errorAt(path, "value is nested too deep")
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errorAt(path, "expected object, got %s", jsonType(v))
+	}
+
+	fields := make(map[string]caps.Field)
+	for _, f := range Fields(n) {
+		c := caps.CodecOf(f)
+		if c.Ignored {
+			continue
+		}
+		if f.Which() == caps.FIELD_SLOT {
+			switch f.Slot().Type().Which() {
+			case caps.TYPE_INTERFACE:
+				continue
+			case caps.TYPE_VOID:
+				if f.DiscriminantValue() == caps.FieldNoDiscriminant {
+					continue
+				}
+			}
+		}
+		fields[c.Name] = f
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if _, ok := fields[k]; !ok {
+			return nil, errorAt(joinPath(path, k), "unknown field of %s", n.DisplayName()[n.DisplayNamePrefixLength():])
+		}
+	}
+
+	d := decoder{p.Schema}
+	values := make(map[uint16]Value)
+	var members []caps.Field
+
+	for _, f := range Fields(n) {
+		c := caps.CodecOf(f)
+		jv, found := obj[c.Name]
+		if !found || c.Ignored || jv == nil && p.presence(n, f) {
+			continue
+		}
+		if f.Which() == caps.FIELD_SLOT && f.Slot().Type().Which() == caps.TYPE_INTERFACE {
+			continue
+		}
+
+		fpath := joinPath(path, c.Name)
+		var v Value
+		var err error
+		if f.Which() == caps.FIELD_GROUP {
+			var g caps.Node
+			if g, err = p.Node(f.Group().TypeId()); err == nil {
+				v, err = p.structValue(g, jv, fpath, depth+1)
+			}
+		} else {
+			var semantic bool
+			if v, semantic, err = semanticValue(f, jv, fpath); !semantic {
+				v, err = p.value(f.Slot().Type(), jv, fpath, depth)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		values[f.CodeOrder()] = v
+		if f.DiscriminantValue() != caps.FieldNoDiscriminant {
+			members = append(members, f)
+		}
+	}
+
+	// Discriminant of the struct without union members in JSON is zero.
+	// Generated Go structs write every member, the first one with non-zero
+	// value is active then.
+	disc := uint16(0)
+	switch {
+	case len(members) == 1:
+		disc = members[0].DiscriminantValue()
+	case len(members) > 1:
+		for _, f := range members {
+			if !isZero(values[f.CodeOrder()]) {
+				disc = f.DiscriminantValue()
+				break
+			}
+		}
+	}
+
+	st := &Struct{Node: n}
+
+	for _, f := range Fields(n) {
+		fv := FieldValue{Field: f, Active: true}
+		if fd := f.DiscriminantValue(); fd != caps.FieldNoDiscriminant {
+			fv.Active = fd == disc
+		}
+
+		if f.Which() == caps.FIELD_SLOT {
+			switch f.Slot().Type().Which() {
+			case caps.TYPE_INTERFACE:
+				continue
+			case caps.TYPE_VOID:
+				if f.DiscriminantValue() == caps.FieldNoDiscriminant {
+					continue
+				}
+			}
+		}
+
+		v, found := values[f.CodeOrder()]
+		switch {
+		case !fv.Active:
+		case found:
+			fv.Value = v
+		case f.Which() == caps.FIELD_GROUP:
+			g, err := p.Node(f.Group().TypeId())
+			if err != nil {
+				return nil, err
+			}
+			if fv.Value, err = d.structValue(g, C.Struct{}, depth+1); err != nil {
+				return nil, err
+			}
+		default:
+			// Null pointers and zero data read as defaults of the fields
+			var err error
+			if fv.Value, err = d.slot(C.Struct{}, f.Slot(), depth); err != nil {
+				return nil, err
+			}
+		}
+		st.Fields = append(st.Fields, fv)
+	}
+
+	return st, nil
+}
+
+func (p parser) value(t caps.Type, v interface{}, path string, depth int) (Value, error) {
+	switch t.Which() {
+	case caps.TYPE_VOID:
+		if v != nil {
+			return nil, errorAt(path, "expected null for Void, got %s", jsonType(v))
+		}
+		return nil, nil
+
+	case caps.TYPE_BOOL:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errorAt(path, "expected boolean, got %s", jsonType(v))
+		}
+		return b, nil
+
+	case caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_INT32, caps.TYPE_INT64:
+		num, ok := v.(json.Number)
+		if !ok {
+			return nil, errorAt(path, "expected integer, got %s", jsonType(v))
+		}
+		bits := intBits(t.Which())
+		i, err := strconv.ParseInt(string(num), 10, 64)
+		if err != nil {
+			if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+				return nil, errorAt(path, "value %s is out of range of Int%d", num, bits)
+			}
+			return nil, errorAt(path, "expected integer, got %s", num)
+		}
+		if bits < 64 && (i < -1<<uint(bits-1) || i > 1<<uint(bits-1)-1) {
+			return nil, errorAt(path, "value %s is out of range of Int%d", num, bits)
+		}
+		return i, nil
+
+	case caps.TYPE_UINT8, caps.TYPE_UINT16, caps.TYPE_UINT32, caps.TYPE_UINT64:
+		num, ok := v.(json.Number)
+		if !ok {
+			return nil, errorAt(path, "expected unsigned integer, got %s", jsonType(v))
+		}
+		bits := intBits(t.Which())
+		u, err := strconv.ParseUint(string(num), 10, 64)
+		if err != nil {
+			if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange || strings.HasPrefix(string(num), "-") {
+				return nil, errorAt(path, "value %s is out of range of UInt%d", num, bits)
+			}
+			return nil, errorAt(path, "expected unsigned integer, got %s", num)
+		}
+		if bits < 64 && u > 1<<uint(bits)-1 {
+			return nil, errorAt(path, "value %s is out of range of UInt%d", num, bits)
+		}
+		return u, nil
+
+	case caps.TYPE_FLOAT32, caps.TYPE_FLOAT64:
+		var f float64
+		switch v := v.(type) {
+		case json.Number:
+			var err error
+			if f, err = strconv.ParseFloat(string(v), 64); err != nil {
+				return nil, errorAt(path, "value %s is out of range of Float64", v)
+			}
+		case string:
+			switch v {
+			case "nan":
+				f = math.NaN()
+			case "inf":
+				f = math.Inf(1)
+			case "-inf":
+				f = math.Inf(-1)
+			default:
+				return nil, errorAt(path, "expected number, got string %q", v)
+			}
+		default:
+			return nil, errorAt(path, "expected number, got %s", jsonType(v))
+		}
+		if t.Which() == caps.TYPE_FLOAT32 && !math.IsInf(f, 0) && math.Abs(f) > math.MaxFloat32 {
+			return nil, errorAt(path, "value %v is out of range of Float32", v)
+		}
+		return f, nil
+
+	case caps.TYPE_TEXT:
+		str, ok := v.(string)
+		if !ok {
+			return nil, errorAt(path, "expected string, got %s", jsonType(v))
+		}
+		return str, nil
+
+	case caps.TYPE_DATA:
+		return p.data(v, path)
+
+	case caps.TYPE_ENUM:
+		n, err := p.Node(t.Enum().TypeId())
+		if err != nil {
+			return nil, err
+		}
+		return p.enum(n, v, path)
+
+	case caps.TYPE_STRUCT:
+		if v == nil {
+			return nil, nil
+		}
+		n, err := p.Node(t.Struct().TypeId())
+		if err != nil {
+			return nil, err
+		}
+		return p.structValue(n, v, path, depth+1)
+
+	case caps.TYPE_LIST:
+		return p.list(t.List().ElementType(), v, path, depth+1)
+	}
+
+	if v != nil {
+		return nil, errorAt(path, "values of AnyPointer and Interface are not supported")
+	}
+	return nil, nil
+}
+
+func intBits(w caps.Type_Which) int {
+	switch w {
+	case caps.TYPE_INT8, caps.TYPE_UINT8:
+		return 8
+	case caps.TYPE_INT16, caps.TYPE_UINT16:
+		return 16
+	case caps.TYPE_INT32, caps.TYPE_UINT32:
+		return 32
+	}
+	return 64
+}
+
+func (p parser) data(v interface{}, path string) ([]byte, error) {
+	// encoding/json writes nil []byte as null
+	if v == nil {
+		return nil, nil
+	}
+
+	str, ok := v.(string)
+	if !ok {
+		return nil, errorAt(path, "expected base64 string, got %s", jsonType(v))
+	}
+	data, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, errorAt(path, "invalid base64 string: %v", err)
+	}
+	return data, nil
+}
+
+func (p parser) enum(n caps.Node, v interface{}, path string) (Enum, error) {
+	es := n.Enum().Enumerants()
+
+	switch v := v.(type) {
+	case string:
+		for i := 0; i < es.Len(); i++ {
+			if es.At(i).Name() == v {
+				return Enum{Node: n, Value: uint16(i)}, nil
+			}
+		}
+		return Enum{}, errorAt(path, "unknown enumerant %q of %s", v, n.DisplayName()[n.DisplayNamePrefixLength():])
+	case json.Number:
+		u, err := strconv.ParseUint(string(v), 10, 16)
+		if err != nil || int(u) >= es.Len() {
+			return Enum{}, errorAt(path, "value %s is out of range of %s", v, n.DisplayName()[n.DisplayNamePrefixLength():])
+		}
+		return Enum{Node: n, Value: uint16(u)}, nil
+	}
+	return Enum{}, errorAt(path, "expected enumerant name, got %s", jsonType(v))
+}
+
+func (p parser) list(et caps.Type, v interface{}, path string, depth int) (Value, error) {
+	if depth > maxDepth {
+		return nil, errorAt(path, "value is nested too deep")
+	}
+
+	// List(UInt8) is written as base64 string like Data
+	if str, ok := v.(string); ok && et.Which() == caps.TYPE_UINT8 {
+		data, err := p.data(str, path)
+		if err != nil {
+			return nil, err
+		}
+		l := make([]Value, len(data))
+		for i, b := range data {
+			l[i] = uint64(b)
+		}
+		return l, nil
+	}
+
+	if v == nil {
+		return []Value(nil), nil
+	}
+
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, errorAt(path, "expected array, got %s", jsonType(v))
+	}
+
+	l := make([]Value, len(arr))
+	for i, e := range arr {
+		ev, err := p.value(et, e, fmt.Sprintf("%s[%d]", path, i), depth)
+		if err != nil {
+			return nil, err
+		}
+		l[i] = ev
+	}
+	return l, nil
+}
@@ -0,0 +1,77 @@
+package dynamic
+
+import "testing"
+
+func TestParseRecursive(t *testing.T) {
+	s := recursiveSchema()
+
+	for _, tt := range []struct{ in, out string }{
+		{`{"value":1}`, `{"value":1,"next":null}`},
+		{`{"value":1,"next":null}`, `{"value":1,"next":null}`},
+		{`{"next":{"value":2}}`, `{"value":0,"next":{"value":2,"next":null}}`},
+	} {
+		st, err := s.ParseJSON(mustStruct(s, "Node"), []byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if data, _ := st.MarshalJSON(); string(data) != tt.out {
+			t.Errorf("%s is parsed as %s, want %s", tt.in, data, tt.out)
+		}
+	}
+
+	for _, tt := range []struct{ in, out string }{
+		{`{}`, `{"leaf":0}`},
+		{`{"node":{"node":{"leaf":3}}}`, `{"node":{"node":{"leaf":3}}}`},
+		{`{"leaf":0,"node":null}`, `{"leaf":0}`},
+	} {
+		st, err := s.ParseJSON(mustStruct(s, "Tree"), []byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if data, _ := st.MarshalJSON(); string(data) != tt.out {
+			t.Errorf("%s is parsed as %s, want %s", tt.in, data, tt.out)
+		}
+	}
+}
+
+func TestParseGoJSON(t *testing.T) {
+	s := unionSchema()
+
+	// encoding/json writes every union member, numeric enums and nil Data
+	// as null
+	for _, tt := range []struct{ in, out string }{
+		{`{"circle":0,"square":"abc","color":1,"image":null}`, `{"square":"abc","color":"green","image":""}`},
+		{`{"circle":5,"square":"abc","color":0,"image":"AQI="}`, `{"circle":5,"color":"red","image":"AQI="}`},
+		{`{"circle":0,"square":"","color":0,"image":null}`, `{"circle":0,"color":"red","image":""}`},
+		{`{"square":""}`, `{"square":"","color":"red","image":""}`},
+		{`{"none":null}`, `{"none":null,"color":"red","image":""}`},
+	} {
+		st, err := s.ParseJSON(mustStruct(s, "Shape"), []byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if data, _ := st.MarshalJSON(); string(data) != tt.out {
+			t.Errorf("%s is parsed as %s, want %s", tt.in, data, tt.out)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	s := unionSchema()
+
+	for _, tt := range []struct{ in, err string }{
+		{`{"triangle":1}`, `triangle: unknown field of Shape`},
+		{`{"circle":"x"}`, `circle: expected integer, got string`},
+		{`{"color":2}`, `color: value 2 is out of range of Color`},
+		{`{"image":1}`, `image: expected base64 string, got number`},
+		{`[]`, `<root>: expected object, got array`},
+	} {
+		_, err := s.ParseJSON(mustStruct(s, "Shape"), []byte(tt.in))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: error is %v, want %s", tt.in, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package dynamic works with Cap'n Proto messages at runtime using schema
+// nodes of CodeGeneratorRequest, with no generated code involved.
+package dynamic
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+// Schema is a set of nodes of the compiled schema
+type Schema struct {
+	nodes     map[uint64]caps.Node
+	requested map[uint64]bool
+	order     []caps.Node
+}
+
+// New returns schema of nodes of the request.
+func New(req caps.CodeGeneratorRequest) *Schema {
+	s := &Schema{nodes: make(map[uint64]caps.Node), requested: make(map[uint64]bool)}
+
+	for _, n := range req.Nodes().ToArray() {
+		s.nodes[n.Id()] = n
+		s.order = append(s.order, n)
+	}
+	for _, f := range req.RequestedFiles().ToArray() {
+		s.requested[f.Id()] = true
+	}
+	return s
+}
+
+// Load reads serialized CodeGeneratorRequest, as written by `capnp compile -o-`.
+func Load(r io.Reader) (*Schema, error) {
+	seg, err := C.ReadFromStream(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return New(caps.ReadRootCodeGeneratorRequest(seg)), nil
+}
+
+// Node returns node with the id.
+func (s *Schema) Node(id uint64) (caps.Node, error) {
+	n, ok := s.nodes[id]
+	if !ok {
+		return caps.Node{}, fmt.Errorf("node 0x%x not found in schema", id)
+	}
+	return n, nil
+}
+
+// Nodes returns nodes of requested files in order of the request. Nodes of
+// imported files and parameter structs of methods are left out.
+func (s *Schema) Nodes() []caps.Node {
+	var nodes []caps.Node
+	for _, n := range s.order {
+		if s.requested[s.file(n).Id()] {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (s *Schema) file(n caps.Node) caps.Node {
+	for n.Which() != caps.NODE_FILE {
+		p, ok := s.nodes[n.ScopeId()]
+		if !ok {
+			break
+		}
+		n = p
+	}
+	return n
+}
+
+// Struct finds struct by name relative to the file like "Book" or
+// "Book.Chapter", or by display name like "model.capnp:Book". Structs of
+// requested files take precedence over imported ones.
+func (s *Schema) Struct(name string) (caps.Node, error) {
+	var found []caps.Node
+
+	for _, n := range s.order {
+		if n.Which() != caps.NODE_STRUCT || n.Struct().IsGroup() {
+			continue
+		}
+
+		dn := n.DisplayName()
+		if dn == name || strings.HasSuffix(dn, ":"+name) {
+			found = append(found, n)
+		}
+	}
+
+	if len(found) > 1 {
+		var requested []caps.Node
+		for _, n := range found {
+			if s.requested[s.file(n).Id()] {
+				requested = append(requested, n)
+			}
+		}
+		if len(requested) > 0 {
+			found = requested
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return caps.Node{}, fmt.Errorf("struct %s not found in schema", name)
+	case 1:
+		return found[0], nil
+	}
+
+	var names []string
+	for _, n := range found {
+		names = append(names, n.DisplayName())
+	}
+	return caps.Node{}, fmt.Errorf("struct name %s is ambiguous: %s", name, strings.Join(names, ", "))
+}
+
+// Fields returns fields of the struct in code order.
+func Fields(n caps.Node) []caps.Field {
+	fields := n.Struct().Fields().ToArray()
+	mbrs := make([]caps.Field, len(fields))
+	for _, f := range fields {
+		mbrs[f.CodeOrder()] = f
+	}
+	return mbrs
+}
+
+// presence reports whether field of struct n is generated as pointer by
+// $Field.presence. Unset fields are written as nil by msgp and stand for
+// default values of the schema.
+func (s *Schema) presence(n caps.Node, f caps.Field) bool {
+	if f.Which() != caps.FIELD_SLOT || !caps.CodecOf(f).Optional {
+		return false
+	}
+	switch f.Slot().Type().Which() {
+	case caps.TYPE_BOOL, caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_INT32, caps.TYPE_INT64,
+		caps.TYPE_UINT8, caps.TYPE_UINT16, caps.TYPE_UINT32, caps.TYPE_UINT64,
+		caps.TYPE_FLOAT32, caps.TYPE_FLOAT64:
+		return caps.HasAnnotation(f.Annotations(), caps.FieldPresence) || s.hasCodec(n, caps.FieldPresence)
+	}
+	return false
+}
+
+// DefaultValue returns default value of the slot.
+func (s *Schema) DefaultValue(slot caps.FieldSlot) Value {
+	v, _ := decoder{s}.slot(C.Struct{}, slot, 0)
+	return v
+}
@@ -0,0 +1,234 @@
+package dynamic
+
+import (
+	"strings"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+// Schemas of the tests are built the way capnp compile writes them, so the
+// tests don't need the compiler.
+
+const testFileID = 0xd0b5e1f14a6c0000
+
+type testAnnotation struct {
+	id   uint64
+	text string
+}
+
+type testField struct {
+	name   string
+	typ    func(*C.Segment) caps.Type
+	offset uint32
+	disc   int // -1 if the field is not union member
+	group  uint64
+	anns   []testAnnotation
+}
+
+type testNode struct {
+	id                    uint64
+	name                  string
+	group                 bool
+	data, ptrs            uint16
+	discCount, discOffset uint16
+	fields                []testField
+	enumerants            []string
+}
+
+func primType(set func(caps.Type)) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		set(t)
+		return t
+	}
+}
+
+var (
+	voidType  = primType(caps.Type.SetVoid)
+	boolType  = primType(caps.Type.SetBool)
+	int64Type = primType(caps.Type.SetInt64)
+	textType  = primType(caps.Type.SetText)
+	dataType  = primType(caps.Type.SetData)
+)
+
+func structType(id uint64) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetStruct()
+		t.Struct().SetTypeId(id)
+		return t
+	}
+}
+
+func enumType(id uint64) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetEnum()
+		t.Enum().SetTypeId(id)
+		return t
+	}
+}
+
+func listType(et func(*C.Segment) caps.Type) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetList()
+		t.List().SetElementType(et(seg))
+		return t
+	}
+}
+
+func testAnnotations(seg *C.Segment, as []testAnnotation) caps.Annotation_List {
+	l := caps.NewAnnotationList(seg, len(as))
+	for i, a := range as {
+		v := caps.NewValue(seg)
+		if a.text != "" {
+			v.SetText(a.text)
+		} else {
+			v.SetVoid()
+		}
+		l.At(i).SetId(a.id)
+		l.At(i).SetValue(v)
+	}
+	return l
+}
+
+// testSchema returns schema of file test.capnp holding the nodes. Names of
+// the nodes are relative to the file.
+func testSchema(fileAnns []testAnnotation, nodes ...testNode) *Schema {
+	seg := C.NewBuffer(nil)
+	req := caps.NewRootCodeGeneratorRequest(seg)
+
+	nl := caps.NewNodeList(seg, len(nodes)+1)
+	file := nl.At(0)
+	file.SetId(testFileID)
+	file.SetDisplayName("test.capnp")
+	file.SetDisplayNamePrefixLength(0)
+	file.SetAnnotations(testAnnotations(seg, fileAnns))
+	file.SetFile()
+
+	for i, d := range nodes {
+		n := nl.At(i + 1)
+		n.SetId(d.id)
+		n.SetScopeId(testFileID)
+		n.SetDisplayName("test.capnp:" + d.name)
+		n.SetDisplayNamePrefixLength(uint32(strings.LastIndexAny("test.capnp:"+d.name, ":.") + 1))
+		n.SetAnnotations(testAnnotations(seg, nil))
+
+		if d.enumerants != nil {
+			n.SetEnum()
+			el := caps.NewEnumerantList(seg, len(d.enumerants))
+			for j, name := range d.enumerants {
+				el.At(j).SetName(name)
+				el.At(j).SetCodeOrder(uint16(j))
+			}
+			n.Enum().SetEnumerants(el)
+			continue
+		}
+
+		n.SetStruct()
+		st := n.Struct()
+		st.SetIsGroup(d.group)
+		st.SetDataWordCount(d.data)
+		st.SetPointerCount(d.ptrs)
+		st.SetDiscriminantCount(d.discCount)
+		st.SetDiscriminantOffset(uint32(d.discOffset))
+
+		fl := caps.NewFieldList(seg, len(d.fields))
+		for j, tf := range d.fields {
+			f := fl.At(j)
+			f.SetName(tf.name)
+			f.SetCodeOrder(uint16(j))
+			f.SetAnnotations(testAnnotations(seg, tf.anns))
+			f.Ordinal().SetExplicit(uint16(j))
+			if tf.disc >= 0 {
+				f.SetDiscriminantValue(uint16(tf.disc))
+			} else {
+				f.SetDiscriminantValue(caps.FieldNoDiscriminant)
+			}
+
+			if tf.group != 0 {
+				f.SetGroup()
+				f.Group().SetTypeId(tf.group)
+				continue
+			}
+			f.SetSlot()
+			f.Slot().SetOffset(tf.offset)
+			f.Slot().SetType(tf.typ(seg))
+			def := caps.NewValue(seg)
+			def.SetVoid()
+			f.Slot().SetDefaultValue(def)
+		}
+		st.SetFields(fl)
+	}
+	req.SetNodes(nl)
+
+	rl := caps.NewCodeGeneratorRequestRequestedFileList(seg, 1)
+	rl.At(0).SetId(testFileID)
+	rl.At(0).SetFilename("test.capnp")
+	req.SetRequestedFiles(rl)
+
+	return New(req)
+}
+
+const (
+	nodeID = 0xd0b5e1f14a6c0001 + iota
+	treeID
+	forestID
+)
+
+// recursiveSchema holds self-referential types:
+//
+//	struct Node { value @0 :Int64; next @1 :Node; }
+//	struct Tree { union { leaf @0 :Int64; node @1 :Tree; } }
+//	struct Forest { trees @0 :List(Tree); }
+func recursiveSchema() *Schema {
+	return testSchema(nil,
+		testNode{id: nodeID, name: "Node", data: 1, ptrs: 1, fields: []testField{
+			{name: "value", typ: int64Type, disc: -1},
+			{name: "next", typ: structType(nodeID), disc: -1},
+		}},
+		testNode{id: treeID, name: "Tree", data: 2, ptrs: 1, discCount: 2, discOffset: 4, fields: []testField{
+			{name: "leaf", typ: int64Type, disc: 0},
+			{name: "node", typ: structType(treeID), disc: 1},
+		}},
+		testNode{id: forestID, name: "Forest", ptrs: 1, fields: []testField{
+			{name: "trees", typ: listType(structType(treeID)), disc: -1},
+		}},
+	)
+}
+
+func mustStruct(s *Schema, name string) caps.Node {
+	n, err := s.Struct(name)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+const (
+	shapeID = 0xd0b5e1f14a6c0101 + iota
+	colorID
+)
+
+// unionSchema holds union of generated Go shape:
+//
+//	struct Shape {
+//	  union { circle @0 :Int64; square @1 :Text; none @2 :Void; }
+//	  color @3 :Color;
+//	  image @4 :Data;
+//	}
+//	enum Color { red @0; green @1; }
+func unionSchema(fileAnns ...testAnnotation) *Schema {
+	return testSchema(fileAnns,
+		testNode{id: shapeID, name: "Shape", data: 2, ptrs: 2, discCount: 3, discOffset: 4, fields: []testField{
+			{name: "circle", typ: int64Type, disc: 0},
+			{name: "square", typ: textType, disc: 1},
+			{name: "none", typ: voidType, disc: 2},
+			{name: "color", typ: enumType(colorID), offset: 5, disc: -1},
+			{name: "image", typ: dataType, offset: 1, disc: -1},
+		}},
+		testNode{id: colorID, name: "Color", enumerants: []string{"red", "green"}},
+	)
+}
@@ -0,0 +1,164 @@
+package dynamic
+
+import (
+	"net/netip"
+	"time"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tinylib/msgp/msgp"
+	"github.com/tpukep/caps"
+)
+
+// semanticType returns $Type annotation of the field and unit of
+// timestamps. Generated code keeps such fields as time.Time, caps.UUID and
+// netip.Addr, whose JSON and msgp encodings differ from the slot types.
+func semanticType(f caps.Field) (uint64, caps.TimeUnit) {
+	if f.Which() != caps.FIELD_SLOT {
+		return 0, 0
+	}
+	for _, a := range f.Annotations().ToArray() {
+		switch a.Id() {
+		case caps.TypeTimestamp:
+			return a.Id(), caps.TimeUnit(a.Value().Enum())
+		case caps.TypeUuid, caps.TypeIp:
+			return a.Id(), 0
+		}
+	}
+	return 0, 0
+}
+
+func timeFromCapn(v int64, unit caps.TimeUnit) time.Time {
+	switch unit {
+	case caps.TIMEUNIT_SECONDS:
+		return caps.TimestampSecondsFromCapn(v)
+	case caps.TIMEUNIT_MILLISECONDS:
+		return caps.TimestampMillisecondsFromCapn(v)
+	case caps.TIMEUNIT_MICROSECONDS:
+		return caps.TimestampMicrosecondsFromCapn(v)
+	}
+	return caps.TimestampNanosecondsFromCapn(v)
+}
+
+func timeToCapn(t time.Time, unit caps.TimeUnit) int64 {
+	switch unit {
+	case caps.TIMEUNIT_SECONDS:
+		return caps.TimestampSecondsToCapn(t)
+	case caps.TIMEUNIT_MILLISECONDS:
+		return caps.TimestampMillisecondsToCapn(t)
+	case caps.TIMEUNIT_MICROSECONDS:
+		return caps.TimestampMicrosecondsToCapn(t)
+	}
+	return caps.TimestampNanosecondsToCapn(t)
+}
+
+// appendJSONSemantic appends JSON of value of $Type field as encoding/json
+// writes the generated Go type: timestamps in RFC 3339, UUIDs in canonical
+// form and IP addresses as text.
+func appendJSONSemantic(b []byte, f caps.Field, v Value) ([]byte, bool) {
+	switch id, unit := semanticType(f); id {
+	case caps.TypeTimestamp:
+		data, err := timeFromCapn(v.(int64), unit).MarshalJSON()
+		if err != nil {
+			// Years out of range of RFC 3339
+			return append(b, "null"...), true
+		}
+		return append(b, data...), true
+	case caps.TypeUuid:
+		return appendJSONString(b, caps.UUIDFromCapn(v.([]byte)).String()), true
+	case caps.TypeIp:
+		text, _ := caps.IPFromCapn(v.([]byte)).MarshalText()
+		return appendJSONString(b, string(text)), true
+	}
+	return b, false
+}
+
+// semanticValue parses JSON of $Type field written by appendJSONSemantic
+func semanticValue(f caps.Field, v interface{}, path string) (Value, bool, error) {
+	id, unit := semanticType(f)
+	if id == 0 {
+		return nil, false, nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, true, errorAt(path, "expected string, got %s", jsonType(v))
+	}
+
+	switch id {
+	case caps.TypeTimestamp:
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, true, errorAt(path, "expected RFC 3339 time, got %q", s)
+		}
+		return timeToCapn(t, unit), true, nil
+
+	case caps.TypeUuid:
+		u, err := caps.ParseUUID(s)
+		if err != nil {
+			return nil, true, errorAt(path, "expected UUID, got %q", s)
+		}
+		return caps.UUIDToCapn(u), true, nil
+	}
+
+	var a netip.Addr
+	if err := a.UnmarshalText([]byte(s)); err != nil {
+		return nil, true, errorAt(path, "expected IP address, got %q", s)
+	}
+	return caps.IPToCapn(a), true, nil
+}
+
+// shimmedData reports whether the field is Data of custom Go type, which
+// generated code shims as string for msgp.
+func shimmedData(f caps.Field) bool {
+	if f.Which() != caps.FIELD_SLOT || f.Slot().Type().Which() != caps.TYPE_DATA {
+		return false
+	}
+	id, _ := semanticType(f)
+	return id == caps.TypeIp || id == 0 && caps.HasAnnotation(f.Annotations(), C.Customtype)
+}
+
+// appendMsgpSemantic appends msgp of value of $Type field encoded by msgp
+// as extension: timestamps as msgp time and UUIDs as caps.UUIDExtension.
+// Data of other custom types is written as str.
+func appendMsgpSemantic(b []byte, f caps.Field, v Value) ([]byte, bool) {
+	switch id, unit := semanticType(f); id {
+	case caps.TypeTimestamp:
+		return msgp.AppendTime(b, timeFromCapn(v.(int64), unit)), true
+	case caps.TypeUuid:
+		u := caps.UUIDFromCapn(v.([]byte))
+		b, _ = msgp.AppendExtension(b, &u)
+		return b, true
+	}
+	if shimmedData(f) {
+		return msgp.AppendStringFromBytes(b, v.([]byte)), true
+	}
+	return b, false
+}
+
+// readMsgpSemantic reads value of $Type field written by appendMsgpSemantic
+func readMsgpSemantic(f caps.Field, b []byte, path string) (Value, []byte, bool, error) {
+	switch id, unit := semanticType(f); id {
+	case caps.TypeTimestamp:
+		t, o, err := msgp.ReadTimeBytes(b)
+		if err != nil {
+			return nil, o, true, errorAt(path, "%v", err)
+		}
+		return timeToCapn(t, unit), o, true, nil
+
+	case caps.TypeUuid:
+		var u caps.UUID
+		o, err := msgp.ReadExtensionBytes(b, &u)
+		if err != nil {
+			return nil, o, true, errorAt(path, "%v", err)
+		}
+		return caps.UUIDToCapn(u), o, true, nil
+	}
+	if shimmedData(f) {
+		v, o, err := msgp.ReadStringAsBytes(b, nil)
+		if err != nil {
+			return nil, o, true, errorAt(path, "%v", err)
+		}
+		return v, o, true, nil
+	}
+	return nil, b, false, nil
+}
@@ -0,0 +1,104 @@
+package dynamic
+
+import (
+	"github.com/tpukep/caps"
+)
+
+// Value is a value of schema type. It is nil for Void, AnyPointer and
+// Interface, bool, int64 for signed integers, uint64 for unsigned, float64
+// for floats, string for Text, []byte for Data, Enum, *Struct or []Value
+// for List. Null struct pointers are nil.
+type Value interface{}
+
+// Enum is a value of enum type
+type Enum struct {
+	Node  caps.Node
+	Value uint16
+}
+
+// Name returns name of the enumerant or "" if value is out of range.
+func (e Enum) Name() string {
+	es := e.Node.Enum().Enumerants()
+	if int(e.Value) < es.Len() {
+		return es.At(int(e.Value)).Name()
+	}
+	return ""
+}
+
+// Struct is a value of struct or group type. Fields are in code order,
+// Interface and Void fields which are not union members are left out.
+type Struct struct {
+	Node   caps.Node
+	Fields []FieldValue
+}
+
+// FieldValue is a value of the struct field. Value of group is *Struct.
+// Union members which are not set are inactive and have nil value.
+type FieldValue struct {
+	Field  caps.Field
+	Value  Value
+	Active bool
+}
+
+// null reports whether the field is null struct pointer
+func (fv FieldValue) null() bool {
+	return fv.Value == nil && fv.Field.Which() == caps.FIELD_SLOT && fv.Field.Slot().Type().Which() == caps.TYPE_STRUCT
+}
+
+// Zero returns zero value of the type. Zero value of struct is nil, as
+// null pointer.
+func Zero(s *Schema, t caps.Type) Value {
+	switch t.Which() {
+	case caps.TYPE_BOOL:
+		return false
+	case caps.TYPE_INT8, caps.TYPE_INT16, caps.TYPE_INT32, caps.TYPE_INT64:
+		return int64(0)
+	case caps.TYPE_UINT8, caps.TYPE_UINT16, caps.TYPE_UINT32, caps.TYPE_UINT64:
+		return uint64(0)
+	case caps.TYPE_FLOAT32, caps.TYPE_FLOAT64:
+		return float64(0)
+	case caps.TYPE_TEXT:
+		return ""
+	case caps.TYPE_DATA:
+		return []byte(nil)
+	case caps.TYPE_LIST:
+		return []Value(nil)
+	case caps.TYPE_ENUM:
+		if n, err := s.Node(t.Enum().TypeId()); err == nil {
+			return Enum{Node: n}
+		}
+	}
+	return nil
+}
+
+// zeroStruct returns struct of type n with zero fields. Struct fields are
+// null, so recursive types are not expanded.
+func (s *Schema) zeroStruct(n caps.Node) *Struct {
+	st := &Struct{Node: n}
+
+	for _, f := range Fields(n) {
+		// Discriminant of zero struct selects the first union member
+		disc := f.DiscriminantValue()
+		fv := FieldValue{Field: f, Active: disc == caps.FieldNoDiscriminant || disc == 0}
+
+		if f.Which() == caps.FIELD_GROUP {
+			if g, err := s.Node(f.Group().TypeId()); err == nil && fv.Active {
+				fv.Value = s.zeroStruct(g)
+			}
+		} else {
+			switch f.Slot().Type().Which() {
+			case caps.TYPE_INTERFACE:
+				continue
+			case caps.TYPE_VOID:
+				if disc == caps.FieldNoDiscriminant {
+					continue
+				}
+			}
+			if fv.Active {
+				fv.Value = Zero(s, f.Slot().Type())
+			}
+		}
+		st.Fields = append(st.Fields, fv)
+	}
+	return st
+}
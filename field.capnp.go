@@ -3,3 +3,6 @@ package caps
 const FieldRequired = uint64(0xd7a496b371b3cf32)
 const FieldIgnored = uint64(0xbd6ce89716d84707)
 const FieldOptional = uint64(0xad97186ee95a88b9)
+const FieldSensitive = uint64(0xdfd824ea45771b30)
+const FieldPresence = uint64(0x8e7744611e664df8)
+const FieldConverter = uint64(0x8dadab572165204f)
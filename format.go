@@ -0,0 +1,25 @@
+package caps
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// FormatString returns directive of fmt which invoked Formatter with the
+// state and verb, e.g. "%-8.2v". Generated Format methods use it to print
+// masked copies of their values.
+func FormatString(f fmt.State, verb rune) string {
+	s := "%"
+	for _, c := range "+-# 0" {
+		if f.Flag(int(c)) {
+			s += string(c)
+		}
+	}
+	if w, ok := f.Width(); ok {
+		s += strconv.Itoa(w)
+	}
+	if p, ok := f.Precision(); ok {
+		s += "." + strconv.Itoa(p)
+	}
+	return s + string(verb)
+}
@@ -0,0 +1,428 @@
+// Package lint checks schemas for problems of code generation with caps
+// before generation: conflicting annotations, clashing names, misplaced
+// checks and style.
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+	"github.com/tpukep/caps/dynamic"
+)
+
+// Severity of findings. Off disables the rule.
+type Severity int
+
+const (
+	Off Severity = iota
+	Note
+	Warning
+	Error
+)
+
+var severityNames = []string{"off", "note", "warning", "error"}
+
+func (s Severity) String() string {
+	if int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return strconv.Itoa(int(s))
+}
+
+// ParseSeverity parses name of severity: off, note, warning or error.
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if n == name {
+			return Severity(i), nil
+		}
+	}
+	return Off, fmt.Errorf("unknown severity %q", name)
+}
+
+// Rule is a check of the linter
+type Rule struct {
+	ID          string
+	Description string
+	Severity    Severity // Default severity
+}
+
+// Rules lists rules of the linter in order of their checks.
+var Rules = []Rule{
+	{"missing-package", "File has no $Go.package annotation", Error},
+	{"field-annotations", "Field has conflicting $Field.required, $Field.optional or $Field.ignored annotations", Error},
+	{"duplicate-name", "Fields share JSON/msgp key or Go field name after $Field and $Go.name renames", Error},
+	{"check-type", "$Check.value rule doesn't apply to the type of the field", Error},
+	{"naming", "Name doesn't follow Cap'n Proto conventions: UpperCamelCase types, lowerCamelCase members", Warning},
+	{"missing-doc", "Struct has no $Go.doc comment", Note},
+}
+
+// Config overrides default severities of rules by rule ID.
+type Config map[string]Severity
+
+// ParseConfig parses list of rule=severity pairs separated by commas like
+// "missing-doc=off,naming=error".
+func ParseConfig(s string) (Config, error) {
+	cfg := make(Config)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		i := strings.Index(item, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("expected rule=severity, got %q", item)
+		}
+		id := item[:i]
+		if _, found := ruleByID(id); !found {
+			return nil, fmt.Errorf("unknown rule %q", id)
+		}
+		sev, err := ParseSeverity(item[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		cfg[id] = sev
+	}
+	return cfg, nil
+}
+
+func ruleByID(id string) (Rule, bool) {
+	for _, r := range Rules {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Finding is a problem found in the schema. Path is name of the node
+// relative to the file and of its member like "Book.title". Line is 0
+// until Locate finds the declaration.
+type Finding struct {
+	Rule     string
+	Severity Severity
+	File     string
+	Path     string
+	Message  string
+	Line     int
+
+	// Declarations leading to the node or member, searched in turn
+	decls []*regexp.Regexp
+}
+
+func (f Finding) String() string {
+	loc := f.File
+	if f.Line > 0 {
+		loc += ":" + strconv.Itoa(f.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s [%s]", loc, f.Severity, f.Path, f.Message, f.Rule)
+}
+
+// Locate sets line of the finding by searching its declaration in source
+// of the file. Line stays 0 if declaration isn't found.
+func (f *Finding) Locate(src []byte) {
+	off := 0
+	for _, re := range f.decls {
+		loc := re.FindIndex(src[off:])
+		if loc == nil {
+			return
+		}
+		off += loc[0]
+	}
+	f.Line = 1 + strings.Count(string(src[:off]), "\n")
+}
+
+// Max returns the highest severity of findings, Off if there are none.
+func Max(findings []Finding) Severity {
+	max := Off
+	for _, f := range findings {
+		if f.Severity > max {
+			max = f.Severity
+		}
+	}
+	return max
+}
+
+type linter struct {
+	schema   *dynamic.Schema
+	cfg      Config
+	findings []Finding
+}
+
+// scope is a node or member with its path and declarations
+type scope struct {
+	file  string
+	path  string
+	decls []*regexp.Regexp
+}
+
+func (s scope) member(name, decl string) scope {
+	decls := append(append([]*regexp.Regexp(nil), s.decls...), regexp.MustCompile(decl))
+	return scope{s.file, s.path + "." + name, decls}
+}
+
+func (l *linter) report(rule string, at scope, format string, a ...interface{}) {
+	r, _ := ruleByID(rule)
+	sev := r.Severity
+	if s, found := l.cfg[rule]; found {
+		sev = s
+	}
+	if sev == Off {
+		return
+	}
+
+	l.findings = append(l.findings, Finding{
+		Rule:     rule,
+		Severity: sev,
+		File:     at.file,
+		Path:     at.path,
+		Message:  fmt.Sprintf(format, a...),
+		decls:    at.decls,
+	})
+}
+
+// Run checks nodes of requested files of the schema. Findings follow the
+// order of nodes in the request.
+func Run(s *dynamic.Schema, cfg Config) []Finding {
+	l := &linter{schema: s, cfg: cfg}
+
+	for _, n := range s.Nodes() {
+		switch n.Which() {
+		case caps.NODE_FILE:
+			l.file(n)
+		case caps.NODE_STRUCT:
+			if !n.Struct().IsGroup() {
+				l.structNode(n)
+			}
+		case caps.NODE_ENUM:
+			l.enumNode(n)
+		case caps.NODE_INTERFACE:
+			l.interfaceNode(n)
+		}
+	}
+	return l.findings
+}
+
+// nodeScope returns scope of the node. Declarations of nested nodes
+// follow declarations of their parents.
+func (l *linter) nodeScope(n caps.Node) scope {
+	name := n.DisplayName()
+	file := name
+	if i := strings.Index(name, ":"); i != -1 {
+		file, name = name[:i], name[i+1:]
+	}
+
+	var decls []*regexp.Regexp
+	for _, part := range strings.Split(name, ".") {
+		decls = append(decls, regexp.MustCompile(`\b(struct|enum|interface)\s+`+regexp.QuoteMeta(part)+`\b`))
+	}
+	return scope{file, name, decls}
+}
+
+func (l *linter) file(n caps.Node) {
+	if !caps.HasAnnotation(n.Annotations(), C.Package) {
+		at := scope{file: n.DisplayName(), path: n.DisplayName()}
+		l.report("missing-package", at, "file has no $Go.package annotation, generation fails")
+	}
+}
+
+var (
+	typeName   = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+	memberName = regexp.MustCompile(`^[a-z][A-Za-z0-9]*$`)
+)
+
+func (l *linter) typeNaming(at scope) {
+	name := at.path[strings.LastIndex(at.path, ".")+1:]
+	if !typeName.MatchString(name) {
+		l.report("naming", at, "type name %s should be UpperCamelCase", name)
+	}
+}
+
+func (l *linter) memberNaming(name string, at scope) {
+	if !memberName.MatchString(name) {
+		l.report("naming", at, "member name %s should be lowerCamelCase", name)
+	}
+}
+
+func (l *linter) structNode(n caps.Node) {
+	at := l.nodeScope(n)
+	l.typeNaming(at)
+
+	if !caps.HasAnnotation(n.Annotations(), C.Doc) {
+		l.report("missing-doc", at, "struct has no $Go.doc comment")
+	}
+
+	l.fields(n, at)
+}
+
+// fieldScope returns scope of the field of struct or group
+func fieldScope(at scope, f caps.Field) scope {
+	name := regexp.QuoteMeta(f.Name())
+	if f.Which() == caps.FIELD_GROUP {
+		return at.member(f.Name(), `\b`+name+`\s*:\s*(group|union)\b`)
+	}
+	return at.member(f.Name(), fmt.Sprintf(`\b%s\s*@%d\b`, name, f.Ordinal().Explicit()))
+}
+
+// fields checks fields of struct or group n. Groups are nested structs in
+// generated code, so names are unique within each group.
+func (l *linter) fields(n caps.Node, at scope) {
+	keys := make(map[string]string)
+	goNames := make(map[string]string)
+
+	for _, f := range dynamic.Fields(n) {
+		fat := fieldScope(at, f)
+		l.memberNaming(f.Name(), fat)
+
+		c := l.fieldAnnotations(f, fat)
+		if !c.Ignored {
+			if other, found := keys[c.Name]; found {
+				l.report("duplicate-name", fat, "JSON/msgp key %q is also used by %s", c.Name, other)
+			} else {
+				keys[c.Name] = f.Name()
+			}
+		}
+
+		goName := goFieldName(f)
+		if other, found := goNames[goName]; found {
+			l.report("duplicate-name", fat, "Go field name %s is also used by %s", goName, other)
+		} else {
+			goNames[goName] = f.Name()
+		}
+
+		if f.Which() == caps.FIELD_GROUP {
+			if g, err := l.schema.Node(f.Group().TypeId()); err == nil {
+				l.fields(g, fat)
+			}
+			continue
+		}
+
+		for _, a := range f.Annotations().ToArray() {
+			if a.Id() == caps.CheckValue {
+				l.check(f.Slot().Type(), a.Value().Text(), fat)
+			}
+		}
+	}
+}
+
+// goFieldName returns name of the field in generated struct
+func goFieldName(f caps.Field) string {
+	for _, a := range f.Annotations().ToArray() {
+		if a.Id() == C.Name && a.Value().Text() != "" {
+			return strings.Title(a.Value().Text())
+		}
+	}
+	return strings.Title(f.Name())
+}
+
+// fieldAnnotations reports combinations of $Field annotations rejected by
+// the generator and returns codec description of the field.
+func (l *linter) fieldAnnotations(f caps.Field, at scope) caps.FieldCodec {
+	c := caps.CodecOf(f)
+	if err := c.Err(); err != nil {
+		l.report("field-annotations", at, "%v", err)
+	}
+	return c
+}
+
+// textFormats are go-playground/validator rules checking format of
+// strings
+var textFormats = map[string]bool{
+	"email": true, "url": true, "uri": true, "uuid": true, "uuid3": true, "uuid4": true, "uuid5": true,
+	"ipv4": true, "ipv6": true, "ip": true, "hostname": true, "datetime": true, "alpha": true,
+	"alphanum": true, "alphaunicode": true, "numeric": true, "number": true, "hexadecimal": true,
+	"hexcolor": true, "lowercase": true, "uppercase": true, "ascii": true, "contains": true,
+	"startswith": true, "endswith": true, "base64": true, "json": true,
+}
+
+// check reports rules of $Check.value expression which don't apply to
+// type t. "dive" moves to elements of lists.
+func (l *linter) check(t caps.Type, exp string, at scope) {
+	for _, rule := range strings.Split(exp, ",") {
+		if strings.Contains(rule, "|") {
+			continue
+		}
+
+		key, param := rule, ""
+		if i := strings.Index(rule, "="); i != -1 {
+			key, param = rule[:i], rule[i+1:]
+		}
+
+		which := t.Which()
+		switch key {
+		case "dive":
+			if which != caps.TYPE_LIST {
+				l.report("check-type", at, "%q applies to lists, not %s", key, typeString(t))
+				return
+			}
+			t = t.List().ElementType()
+
+		case "min", "max", "len", "gt", "gte", "lt", "lte", "eq", "ne":
+			switch which {
+			case caps.TYPE_VOID, caps.TYPE_BOOL, caps.TYPE_STRUCT, caps.TYPE_INTERFACE, caps.TYPE_ANYPOINTER:
+				l.report("check-type", at, "%q doesn't apply to %s", rule, typeString(t))
+				continue
+			}
+			if _, err := strconv.ParseFloat(param, 64); err != nil && key != "eq" && key != "ne" {
+				l.report("check-type", at, "%q expects number", rule)
+			}
+
+		case "oneof":
+			switch which {
+			case caps.TYPE_VOID, caps.TYPE_BOOL, caps.TYPE_DATA, caps.TYPE_LIST, caps.TYPE_STRUCT,
+				caps.TYPE_INTERFACE, caps.TYPE_ANYPOINTER:
+				l.report("check-type", at, "%q doesn't apply to %s", key, typeString(t))
+			}
+
+		default:
+			if textFormats[key] && which != caps.TYPE_TEXT {
+				l.report("check-type", at, "%q applies to Text, not %s", key, typeString(t))
+			}
+		}
+	}
+}
+
+func typeString(t caps.Type) string {
+	switch t.Which() {
+	case caps.TYPE_VOID:
+		return "Void"
+	case caps.TYPE_BOOL:
+		return "Bool"
+	case caps.TYPE_DATA:
+		return "Data"
+	case caps.TYPE_TEXT:
+		return "Text"
+	case caps.TYPE_LIST:
+		return "List"
+	case caps.TYPE_ENUM:
+		return "enum"
+	case caps.TYPE_STRUCT:
+		return "struct"
+	case caps.TYPE_INTERFACE:
+		return "interface"
+	case caps.TYPE_ANYPOINTER:
+		return "AnyPointer"
+	}
+	return "number"
+}
+
+func (l *linter) enumNode(n caps.Node) {
+	at := l.nodeScope(n)
+	l.typeNaming(at)
+
+	for i, e := range n.Enum().Enumerants().ToArray() {
+		l.memberNaming(e.Name(), at.member(e.Name(), fmt.Sprintf(`\b%s\s*@%d\b`, regexp.QuoteMeta(e.Name()), i)))
+	}
+}
+
+func (l *linter) interfaceNode(n caps.Node) {
+	at := l.nodeScope(n)
+	l.typeNaming(at)
+
+	for i, m := range n.Interface().Methods().ToArray() {
+		l.memberNaming(m.Name(), at.member(m.Name(), fmt.Sprintf(`\b%s\s*@%d\b`, regexp.QuoteMeta(m.Name()), i)))
+	}
+}
@@ -0,0 +1,353 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+	"github.com/tpukep/caps/dynamic"
+)
+
+// Schemas of the tests are built the way capnp compile writes them, so the
+// tests don't need the compiler.
+
+const testFileID = 0xf1a9f1a9f1a90000
+
+type testAnnotation struct {
+	id   uint64
+	text string
+}
+
+type testField struct {
+	name  string
+	typ   func(*C.Segment) caps.Type
+	group uint64
+	anns  []testAnnotation
+}
+
+type testNode struct {
+	id         uint64
+	name       string
+	group      bool
+	anns       []testAnnotation
+	fields     []testField
+	enumerants []string
+	methods    []string
+}
+
+func primType(set func(caps.Type)) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		set(t)
+		return t
+	}
+}
+
+var (
+	boolType  = primType(caps.Type.SetBool)
+	int64Type = primType(caps.Type.SetInt64)
+	textType  = primType(caps.Type.SetText)
+	dataType  = primType(caps.Type.SetData)
+)
+
+func listType(et func(*C.Segment) caps.Type) func(*C.Segment) caps.Type {
+	return func(seg *C.Segment) caps.Type {
+		t := caps.NewType(seg)
+		t.SetList()
+		t.List().SetElementType(et(seg))
+		return t
+	}
+}
+
+func testAnnotations(seg *C.Segment, as []testAnnotation) caps.Annotation_List {
+	l := caps.NewAnnotationList(seg, len(as))
+	for i, a := range as {
+		v := caps.NewValue(seg)
+		if a.text != "" {
+			v.SetText(a.text)
+		} else {
+			v.SetVoid()
+		}
+		l.At(i).SetId(a.id)
+		l.At(i).SetValue(v)
+	}
+	return l
+}
+
+// testSchema returns schema of file test.capnp holding the nodes. Names of
+// the nodes are relative to the file. Fields get ordinals in code order.
+func testSchema(fileAnns []testAnnotation, nodes ...testNode) *dynamic.Schema {
+	seg := C.NewBuffer(nil)
+	req := caps.NewRootCodeGeneratorRequest(seg)
+
+	nl := caps.NewNodeList(seg, len(nodes)+1)
+	file := nl.At(0)
+	file.SetId(testFileID)
+	file.SetDisplayName("test.capnp")
+	file.SetAnnotations(testAnnotations(seg, fileAnns))
+	file.SetFile()
+
+	for i, d := range nodes {
+		n := nl.At(i + 1)
+		n.SetId(d.id)
+		n.SetScopeId(testFileID)
+		n.SetDisplayName("test.capnp:" + d.name)
+		n.SetDisplayNamePrefixLength(uint32(strings.LastIndexAny("test.capnp:"+d.name, ":.") + 1))
+		n.SetAnnotations(testAnnotations(seg, d.anns))
+
+		switch {
+		case d.enumerants != nil:
+			n.SetEnum()
+			el := caps.NewEnumerantList(seg, len(d.enumerants))
+			for j, name := range d.enumerants {
+				el.At(j).SetName(name)
+				el.At(j).SetCodeOrder(uint16(j))
+			}
+			n.Enum().SetEnumerants(el)
+
+		case d.methods != nil:
+			n.SetInterface()
+			ml := caps.NewMethodList(seg, len(d.methods))
+			for j, name := range d.methods {
+				ml.At(j).SetName(name)
+				ml.At(j).SetCodeOrder(uint16(j))
+			}
+			n.Interface().SetMethods(ml)
+
+		default:
+			n.SetStruct()
+			n.Struct().SetIsGroup(d.group)
+			fl := caps.NewFieldList(seg, len(d.fields))
+			for j, tf := range d.fields {
+				f := fl.At(j)
+				f.SetName(tf.name)
+				f.SetCodeOrder(uint16(j))
+				f.SetAnnotations(testAnnotations(seg, tf.anns))
+				f.SetDiscriminantValue(caps.FieldNoDiscriminant)
+
+				if tf.group != 0 {
+					f.SetGroup()
+					f.Group().SetTypeId(tf.group)
+					continue
+				}
+				f.SetSlot()
+				f.Ordinal().SetExplicit(uint16(j))
+				f.Slot().SetType(tf.typ(seg))
+			}
+			n.Struct().SetFields(fl)
+		}
+	}
+	req.SetNodes(nl)
+
+	rl := caps.NewCodeGeneratorRequestRequestedFileList(seg, 1)
+	rl.At(0).SetId(testFileID)
+	rl.At(0).SetFilename("test.capnp")
+	req.SetRequestedFiles(rl)
+
+	return dynamic.New(req)
+}
+
+const (
+	bookID = testFileID + 1 + iota
+	detailsID
+	genreID
+	libraryID
+)
+
+var (
+	goPackage = []testAnnotation{{C.Package, "test"}}
+	doc       = []testAnnotation{{C.Doc, "Book of the library."}}
+)
+
+func check(exp string) []testAnnotation {
+	return []testAnnotation{{caps.CheckValue, exp}}
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileAnns []testAnnotation
+		nodes    []testNode
+		want     []string
+	}{
+		{"clean", goPackage, []testNode{
+			{id: bookID, name: "Book", anns: doc, fields: []testField{
+				{name: "title", typ: textType},
+				{name: "details", group: detailsID},
+			}},
+			{id: detailsID, name: "Book.details", group: true, fields: []testField{
+				// Groups are nested structs, names don't clash with Book
+				{name: "title", typ: textType},
+			}},
+			{id: genreID, name: "Genre", enumerants: []string{"fiction", "scienceFiction"}},
+			{id: libraryID, name: "Library", methods: []string{"addBook"}},
+		}, nil},
+
+		{"missing-package", nil, []testNode{}, []string{
+			"test.capnp: error: test.capnp: file has no $Go.package annotation, generation fails [missing-package]",
+		}},
+
+		{"field-annotations", goPackage, []testNode{
+			{id: bookID, name: "Book", anns: doc, fields: []testField{
+				{name: "title", typ: textType, anns: []testAnnotation{{caps.FieldRequired, "title"}, {id: caps.FieldIgnored}}},
+				{name: "isbn", typ: textType, anns: []testAnnotation{{caps.FieldRequired, "isbn"}, {caps.FieldOptional, "isbn"}}},
+				{name: "pages", typ: int64Type, anns: []testAnnotation{{caps.FieldOptional, "pages"}, {id: caps.FieldIgnored}}},
+			}},
+		}, []string{
+			"test.capnp: error: Book.title: $Field.required and $Field.ignored are incompatible [field-annotations]",
+			"test.capnp: error: Book.isbn: $Field.required and $Field.optional are incompatible [field-annotations]",
+			"test.capnp: error: Book.pages: $Field.optional and $Field.ignored are incompatible [field-annotations]",
+		}},
+
+		{"duplicate-name", goPackage, []testNode{
+			{id: bookID, name: "Book", anns: doc, fields: []testField{
+				{name: "title", typ: textType, anns: []testAnnotation{{caps.FieldOptional, "name"}}},
+				{name: "name", typ: textType},
+				{name: "isbn", typ: textType, anns: []testAnnotation{{C.Name, "code"}}},
+				{name: "code", typ: textType},
+				// Ignored fields have no key
+				{name: "author", typ: textType, anns: []testAnnotation{{id: caps.FieldIgnored}}},
+				{name: "writer", typ: textType, anns: []testAnnotation{{caps.FieldOptional, "author"}}},
+			}},
+		}, []string{
+			`test.capnp: error: Book.name: JSON/msgp key "name" is also used by title [duplicate-name]`,
+			"test.capnp: error: Book.code: Go field name Code is also used by isbn [duplicate-name]",
+		}},
+
+		{"check-type", goPackage, []testNode{
+			{id: bookID, name: "Book", anns: doc, fields: []testField{
+				{name: "title", typ: textType, anns: check("required,min=1,email|url")},
+				{name: "tags", typ: listType(textType), anns: check("max=5,dive,alpha")},
+				{name: "draft", typ: boolType, anns: check("min=1")},
+				{name: "pages", typ: int64Type, anns: check("email,max=x,eq=x")},
+				{name: "cover", typ: dataType, anns: check("oneof=a b")},
+				{name: "isbn", typ: textType, anns: check("dive")},
+			}},
+		}, []string{
+			`test.capnp: error: Book.draft: "min=1" doesn't apply to Bool [check-type]`,
+			`test.capnp: error: Book.pages: "email" applies to Text, not number [check-type]`,
+			`test.capnp: error: Book.pages: "max=x" expects number [check-type]`,
+			`test.capnp: error: Book.cover: "oneof" doesn't apply to Data [check-type]`,
+			`test.capnp: error: Book.isbn: "dive" applies to lists, not Text [check-type]`,
+		}},
+
+		{"naming", goPackage, []testNode{
+			{id: bookID, name: "book_shelf", anns: doc, fields: []testField{
+				{name: "Title", typ: textType},
+				{name: "details", group: detailsID},
+			}},
+			{id: detailsID, name: "book_shelf.details", group: true, fields: []testField{
+				{name: "page_count", typ: int64Type},
+			}},
+			{id: genreID, name: "Genre", enumerants: []string{"SCIENCE_FICTION"}},
+			{id: libraryID, name: "Library", methods: []string{"AddBook"}},
+		}, []string{
+			"test.capnp: warning: book_shelf: type name book_shelf should be UpperCamelCase [naming]",
+			"test.capnp: warning: book_shelf.Title: member name Title should be lowerCamelCase [naming]",
+			"test.capnp: warning: book_shelf.details.page_count: member name page_count should be lowerCamelCase [naming]",
+			"test.capnp: warning: Genre.SCIENCE_FICTION: member name SCIENCE_FICTION should be lowerCamelCase [naming]",
+			"test.capnp: warning: Library.AddBook: member name AddBook should be lowerCamelCase [naming]",
+		}},
+
+		{"missing-doc", goPackage, []testNode{
+			{id: bookID, name: "Book", fields: []testField{}},
+		}, []string{
+			"test.capnp: note: Book: struct has no $Go.doc comment [missing-doc]",
+		}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, f := range Run(testSchema(tt.fileAnns, tt.nodes...), nil) {
+			got = append(got, f.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: findings are\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	cfg, err := ParseConfig(" missing-doc=off, naming=error,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := testSchema(goPackage, testNode{id: bookID, name: "Book", fields: []testField{
+		{name: "Title", typ: textType},
+	}})
+	findings := Run(s, cfg)
+	if len(findings) != 1 || findings[0].Rule != "naming" || findings[0].Severity != Error {
+		t.Errorf("findings are %v, want naming error only", findings)
+	}
+	if max := Max(findings); max != Error {
+		t.Errorf("max severity is %s, want error", max)
+	}
+	if max := Max(nil); max != Off {
+		t.Errorf("max severity of no findings is %s, want off", max)
+	}
+
+	for _, tt := range []struct{ in, err string }{
+		{"naming", `expected rule=severity, got "naming"`},
+		{"naming=error,style=off", `unknown rule "style"`},
+		{"naming=fatal", `unknown severity "fatal"`},
+	} {
+		if _, err := ParseConfig(tt.in); err == nil || err.Error() != tt.err {
+			t.Errorf("%s: error is %v, want %s", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	for _, s := range []Severity{Off, Note, Warning, Error} {
+		if p, err := ParseSeverity(s.String()); err != nil || p != s {
+			t.Errorf("%s is parsed as %s: %v", s, p, err)
+		}
+	}
+	if s := Severity(7).String(); s != "7" {
+		t.Errorf("unknown severity is written as %s", s)
+	}
+}
+
+const bookSource = `@0xf1a9f1a9f1a90000;
+
+struct Library {
+  struct Book {
+    title @0 :Text;
+    details :group {
+      Pages @1 :Int64;
+    }
+  }
+}
+
+struct Book {}
+`
+
+func TestLocate(t *testing.T) {
+	s := testSchema(goPackage,
+		testNode{id: bookID, name: "Library.Book", anns: doc, fields: []testField{
+			{name: "title", typ: textType},
+			{name: "details", group: detailsID},
+		}},
+		testNode{id: detailsID, name: "Library.Book.details", group: true, fields: []testField{
+			{name: "filler", typ: textType},
+			{name: "Pages", typ: int64Type},
+		}},
+		testNode{id: genreID, name: "book", anns: doc, fields: []testField{}},
+	)
+
+	var got []string
+	for _, f := range Run(s, nil) {
+		f.Locate([]byte(bookSource))
+		got = append(got, f.String())
+	}
+	want := []string{
+		"test.capnp:7: warning: Library.Book.details.Pages: member name Pages should be lowerCamelCase [naming]",
+		// Not declared in the source
+		"test.capnp: warning: book: type name book should be UpperCamelCase [naming]",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings are\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
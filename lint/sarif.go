@@ -0,0 +1,113 @@
+package lint
+
+import (
+	"encoding/json"
+	"io"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+// level returns SARIF level of the severity
+func (s Severity) level() string {
+	if s == Off {
+		return "none"
+	}
+	return s.String()
+}
+
+// WriteSARIF writes findings as SARIF 2.1.0 log, the format of code
+// scanning tools.
+func WriteSARIF(w io.Writer, findings []Finding) error {
+	driver := sarifDriver{Name: "caps lint", InformationURI: "https://github.com/tpukep/caps"}
+	index := make(map[string]int)
+	for i, r := range Rules {
+		sr := sarifRule{ID: r.ID, ShortDescription: sarifMessage{r.Description}}
+		sr.DefaultConfiguration.Level = r.Severity.level()
+		driver.Rules = append(driver.Rules, sr)
+		index[r.ID] = i
+	}
+
+	run := sarifRun{Tool: sarifTool{driver}, Results: []sarifResult{}}
+	for _, f := range findings {
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = f.File
+		if f.Line > 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{f.Line}
+		}
+		loc.LogicalLocations = []sarifLogicalLocation{{f.Path}}
+
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    f.Rule,
+			RuleIndex: index[f.Rule],
+			Level:     f.Severity.level(),
+			Message:   sarifMessage{f.Message},
+			Locations: []sarifLocation{loc},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{sarifVersion, sarifSchema, []sarifRun{run}})
+}
@@ -0,0 +1,106 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWriteSARIF(t *testing.T) {
+	findings := []Finding{
+		{Rule: "naming", Severity: Warning, File: "book.capnp", Path: "Book.Title", Message: "member name Title should be lowerCamelCase", Line: 3},
+		{Rule: "missing-package", Severity: Error, File: "book.capnp", Path: "book.capnp", Message: "file has no $Go.package annotation"},
+	}
+
+	var b bytes.Buffer
+	if err := WriteSARIF(&b, findings); err != nil {
+		t.Fatal(err)
+	}
+
+	var log struct {
+		Version string `json:"version"`
+		Schema  string `json:"$schema"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string                   `json:"name"`
+					Rules []map[string]interface{} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []map[string]interface{} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &log); err != nil {
+		t.Fatalf("%s: %v", b.Bytes(), err)
+	}
+
+	if log.Version != "2.1.0" || log.Schema != "https://json.schemastore.org/sarif-2.1.0.json" || len(log.Runs) != 1 {
+		t.Fatalf("log is %s", b.Bytes())
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "caps lint" || len(run.Tool.Driver.Rules) != len(Rules) {
+		t.Errorf("driver is %+v", run.Tool.Driver)
+	}
+	for i, r := range Rules {
+		want := map[string]interface{}{
+			"id":                   r.ID,
+			"shortDescription":     map[string]interface{}{"text": r.Description},
+			"defaultConfiguration": map[string]interface{}{"level": r.Severity.String()},
+		}
+		if i < len(run.Tool.Driver.Rules) && !reflect.DeepEqual(run.Tool.Driver.Rules[i], want) {
+			t.Errorf("rule %d is %v, want %v", i, run.Tool.Driver.Rules[i], want)
+		}
+	}
+
+	location := func(uri string, region interface{}, path string) []interface{} {
+		physical := map[string]interface{}{"artifactLocation": map[string]interface{}{"uri": uri}}
+		if region != nil {
+			physical["region"] = region
+		}
+		return []interface{}{map[string]interface{}{
+			"physicalLocation": physical,
+			"logicalLocations": []interface{}{map[string]interface{}{"fullyQualifiedName": path}},
+		}}
+	}
+	want := []map[string]interface{}{
+		{
+			"ruleId":    "naming",
+			"ruleIndex": 4.0,
+			"level":     "warning",
+			"message":   map[string]interface{}{"text": "member name Title should be lowerCamelCase"},
+			"locations": location("book.capnp", map[string]interface{}{"startLine": 3.0}, "Book.Title"),
+		},
+		{
+			"ruleId":    "missing-package",
+			"ruleIndex": 0.0,
+			"level":     "error",
+			"message":   map[string]interface{}{"text": "file has no $Go.package annotation"},
+			// Findings which aren't located have no region
+			"locations": location("book.capnp", nil, "book.capnp"),
+		},
+	}
+	if !reflect.DeepEqual(run.Results, want) {
+		t.Errorf("results are\n%v\nwant\n%v", run.Results, want)
+	}
+}
+
+func TestWriteSARIFEmpty(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteSARIF(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var log struct {
+		Runs []struct {
+			Results json.RawMessage `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	// Code scanning tools reject null results
+	if len(log.Runs) != 1 || string(log.Runs[0].Results) != "[]" {
+		t.Errorf("log without findings is %s", b.Bytes())
+	}
+}
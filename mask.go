@@ -0,0 +1,63 @@
+package caps
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FieldMask is a set of field paths like "title" or "description.review".
+// A path covers all fields below it. Masks are built with New<Type>FieldMask
+// functions of generated code which reject paths not found in the schema.
+type FieldMask struct {
+	paths []string
+}
+
+// NewFieldMask returns mask of paths checked by valid.
+func NewFieldMask(valid func(path string) bool, paths ...string) (FieldMask, error) {
+	for _, p := range paths {
+		if !valid(p) {
+			return FieldMask{}, fmt.Errorf("invalid field path %q", p)
+		}
+	}
+
+	l := append([]string(nil), paths...)
+	sort.Strings(l)
+	return FieldMask{paths: l}, nil
+}
+
+// Paths returns paths of the mask in sorted order.
+func (m FieldMask) Paths() []string {
+	return append([]string(nil), m.paths...)
+}
+
+// Empty reports whether the mask has no paths.
+func (m FieldMask) Empty() bool {
+	return len(m.paths) == 0
+}
+
+// Has reports whether the field is covered by the mask, either by its path
+// or a path of its parent.
+func (m FieldMask) Has(path string) bool {
+	for _, p := range m.paths {
+		if p == path || strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Sub returns mask of paths below the field, relative to it.
+func (m FieldMask) Sub(path string) FieldMask {
+	var sub FieldMask
+	for _, p := range m.paths {
+		if strings.HasPrefix(p, path+".") {
+			sub.paths = append(sub.paths, p[len(path)+1:])
+		}
+	}
+	return sub
+}
+
+func (m FieldMask) String() string {
+	return strings.Join(m.paths, ",")
+}
@@ -0,0 +1,84 @@
+package caps
+
+import (
+	"reflect"
+	"testing"
+)
+
+var maskPaths = map[string]bool{
+	"title": true, "description": true, "description.review": true, "description.review.text": true, "descriptionText": true,
+}
+
+func TestFieldMask(t *testing.T) {
+	m, err := NewFieldMask(func(p string) bool { return maskPaths[p] }, "title", "description.review")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]bool{
+		"title":                   true,
+		"description.review":      true,
+		"description.review.text": true,
+		// Parent isn't covered by paths of its fields
+		"description": false,
+		// Prefix of the name isn't a parent
+		"descriptionText":   false,
+		"description.rev":   false,
+		"title.description": true,
+		"":                  false,
+	} {
+		if m.Has(path) != want {
+			t.Errorf("%s: Has is %v, want %v", path, !want, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		path string
+		want []string
+	}{
+		{"description", []string{"review"}},
+		{"description.review", nil},
+		{"descr", nil},
+		{"title", nil},
+	} {
+		if sub := m.Sub(tt.path); !reflect.DeepEqual(sub.Paths(), tt.want) {
+			t.Errorf("%s: Sub is %v, want %v", tt.path, sub.Paths(), tt.want)
+		}
+	}
+
+	deep, _ := NewFieldMask(func(string) bool { return true }, "a.b.c", "a.d", "ab.c")
+	if sub := deep.Sub("a"); sub.String() != "b.c,d" || !sub.Has("b.c.e") || sub.Has("b") {
+		t.Errorf("Sub of a is %s", sub)
+	}
+	if sub := deep.Sub("a").Sub("b"); sub.String() != "c" {
+		t.Errorf("Sub of a.b is %s", sub)
+	}
+}
+
+func TestFieldMaskPaths(t *testing.T) {
+	paths := []string{"title", "description"}
+	m, err := NewFieldMask(func(p string) bool { return maskPaths[p] }, paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.String() != "description,title" || m.Empty() {
+		t.Errorf("mask is %s", m)
+	}
+
+	// Mask keeps its own copy of paths
+	paths[0] = "author"
+	m.Paths()[0] = "author"
+	if m.String() != "description,title" {
+		t.Errorf("mask is changed to %s", m)
+	}
+
+	if _, err := NewFieldMask(func(p string) bool { return maskPaths[p] }, "title", "author"); err == nil ||
+		err.Error() != `invalid field path "author"` {
+		t.Errorf("error is %v", err)
+	}
+
+	var empty FieldMask
+	if !empty.Empty() || empty.Has("title") || !empty.Sub("title").Empty() {
+		t.Errorf("zero mask has paths")
+	}
+}
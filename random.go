@@ -0,0 +1,143 @@
+package caps
+
+import (
+	"math"
+	"math/rand"
+	"net/netip"
+	"time"
+)
+
+// RandomDepth limits nesting of structs in lists made by generated Random
+// functions, so recursive types end. Deeper lists of structs are empty.
+const RandomDepth = 3
+
+// RandomInt returns int64 in [min, max]
+func RandomInt(r *rand.Rand, min, max int64) int64 {
+	return min + int64(RandomUint(r, 0, uint64(max-min)))
+}
+
+// RandomUint returns uint64 in [min, max]
+func RandomUint(r *rand.Rand, min, max uint64) uint64 {
+	if max < min {
+		return min
+	}
+	span := max - min + 1
+	if span == 0 {
+		return r.Uint64()
+	}
+	return min + r.Uint64()%span
+}
+
+// RandomFloat returns float64 in [min, max)
+func RandomFloat(r *rand.Rand, min, max float64) float64 {
+	if !(max > min) {
+		return min
+	}
+	v := min + r.Float64()*(max-min)
+	if math.IsInf(v, 0) {
+		// Range wider than float64
+		return min/2 + r.Float64()*(max/2-min/2)
+	}
+	return v
+}
+
+// RandomLen returns length in [min, max]
+func RandomLen(r *rand.Rand, min, max int) int {
+	return int(RandomInt(r, int64(min), int64(max)))
+}
+
+// RandomBytes returns min to max random bytes
+func RandomBytes(r *rand.Rand, min, max int) []byte {
+	b := make([]byte, RandomLen(r, min, max))
+	r.Read(b)
+	return b
+}
+
+const (
+	lowerChars = "abcdefghijklmnopqrstuvwxyz"
+	upperChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars = "0123456789"
+	hexChars   = "0123456789abcdef"
+)
+
+var textChars = map[string]string{
+	"":            lowerChars + upperChars + digitChars,
+	"alpha":       lowerChars + upperChars,
+	"alphanum":    lowerChars + upperChars + digitChars,
+	"numeric":     digitChars,
+	"number":      digitChars,
+	"hexadecimal": hexChars,
+	"lowercase":   lowerChars + digitChars,
+	"uppercase":   upperChars + digitChars,
+	"ascii":       lowerChars + upperChars + digitChars + " !#$%&()*+,-./:;<=>?@[]^_{|}~",
+}
+
+func randomChars(r *rand.Rand, n int, chars string) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[r.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+// RandomText returns text of min to max characters. Format is a
+// go-playground/validator format tag like "email", "uuid" or "alpha".
+// Formats of fixed structure like email, uuid, url, ipv4, ipv6 and hostname
+// keep within max characters only if they fit in.
+func RandomText(r *rand.Rand, min, max int, format string) string {
+	switch format {
+	case "email":
+		local := randomChars(r, RandomLen(r, 1, 8), lowerChars+digitChars)
+		return local + "@" + randomChars(r, RandomLen(r, 1, 8), lowerChars) + ".com"
+	case "uuid", "uuid3", "uuid4", "uuid5":
+		var u UUID
+		r.Read(u[:])
+		u[6] = u[6]&0x0f | 0x40
+		u[8] = u[8]&0x3f | 0x80
+		return u.String()
+	case "url", "uri":
+		return "https://" + randomChars(r, RandomLen(r, 1, 8), lowerChars) + ".com/" + randomChars(r, RandomLen(r, 0, 8), lowerChars)
+	case "hostname":
+		return randomChars(r, RandomLen(r, 1, 8), lowerChars) + "." + randomChars(r, RandomLen(r, 2, 3), lowerChars)
+	case "ipv4":
+		var b [4]byte
+		r.Read(b[:])
+		return netip.AddrFrom4(b).String()
+	case "ipv6":
+		var b [16]byte
+		r.Read(b[:])
+		return netip.AddrFrom16(b).String()
+	}
+
+	chars, found := textChars[format]
+	if !found {
+		chars = textChars[""]
+	}
+	return randomChars(r, RandomLen(r, min, max), chars)
+}
+
+// RandomTime returns time in years 1970 to 2100 truncated to unit
+func RandomTime(r *rand.Rand, unit TimeUnit) time.Time {
+	v := RandomInt(r, 1e9, 4102444800e9)
+	switch unit {
+	case TIMEUNIT_SECONDS:
+		v -= v % int64(time.Second)
+	case TIMEUNIT_MILLISECONDS:
+		v -= v % int64(time.Millisecond)
+	case TIMEUNIT_MICROSECONDS:
+		v -= v % int64(time.Microsecond)
+	}
+	return time.Unix(0, v).UTC()
+}
+
+// RandomIP returns IPv4 or IPv6 address
+func RandomIP(r *rand.Rand) netip.Addr {
+	if r.Intn(2) == 0 {
+		var b [4]byte
+		r.Read(b[:])
+		return netip.AddrFrom4(b)
+	}
+	var b [16]byte
+	r.Read(b[:])
+	return netip.AddrFrom16(b)
+}
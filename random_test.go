@@ -0,0 +1,124 @@
+package caps
+
+import (
+	"math"
+	"math/rand"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRandomNumbers(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 1000; i++ {
+		if v := RandomInt(r, -3, 3); v < -3 || v > 3 {
+			t.Fatalf("RandomInt(-3, 3) is %d", v)
+		}
+		if v := RandomUint(r, 10, 12); v < 10 || v > 12 {
+			t.Fatalf("RandomUint(10, 12) is %d", v)
+		}
+		if v := RandomFloat(r, -1, 1); v < -1 || v >= 1 {
+			t.Fatalf("RandomFloat(-1, 1) is %v", v)
+		}
+		if v := RandomFloat(r, -math.MaxFloat64, math.MaxFloat64); math.IsInf(v, 0) || math.IsNaN(v) {
+			t.Fatalf("RandomFloat over the whole range is %v", v)
+		}
+		if n := RandomLen(r, 2, 4); n < 2 || n > 4 {
+			t.Fatalf("RandomLen(2, 4) is %d", n)
+		}
+		if b := RandomBytes(r, 0, 3); len(b) > 3 {
+			t.Fatalf("RandomBytes(0, 3) is %v", b)
+		}
+	}
+
+	// Whole ranges and empty ones
+	RandomInt(r, math.MinInt64, math.MaxInt64)
+	RandomUint(r, 0, math.MaxUint64)
+	if v := RandomInt(r, 5, 5); v != 5 {
+		t.Errorf("RandomInt(5, 5) is %d", v)
+	}
+	if v := RandomUint(r, 5, 4); v != 5 {
+		t.Errorf("RandomUint(5, 4) is %d", v)
+	}
+	if v := RandomFloat(r, 2, 2); v != 2 {
+		t.Errorf("RandomFloat(2, 2) is %v", v)
+	}
+}
+
+func TestRandomDeterministic(t *testing.T) {
+	a, b := rand.New(rand.NewSource(7)), rand.New(rand.NewSource(7))
+	for i := 0; i < 10; i++ {
+		if x, y := RandomText(a, 1, 20, ""), RandomText(b, 1, 20, ""); x != y {
+			t.Fatalf("texts of the same seed are %q and %q", x, y)
+		}
+	}
+}
+
+func TestRandomText(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	valid := map[string]func(string) bool{
+		"email": func(s string) bool { _, err := mail.ParseAddress(s); return err == nil },
+		"uuid4": func(s string) bool { u, err := ParseUUID(s); return err == nil && u[6]>>4 == 4 && u[8]>>6 == 2 },
+		"url": func(s string) bool {
+			u, err := url.Parse(s)
+			return err == nil && u.Scheme == "https" && u.Host != ""
+		},
+		"hostname": func(s string) bool { return strings.Count(s, ".") == 1 && !strings.HasPrefix(s, ".") },
+		"ipv4":     func(s string) bool { a, err := netip.ParseAddr(s); return err == nil && a.Is4() },
+		"ipv6":     func(s string) bool { a, err := netip.ParseAddr(s); return err == nil && a.Is6() },
+		"numeric":  func(s string) bool { return strings.Trim(s, digitChars) == "" },
+		"alpha":    func(s string) bool { return strings.Trim(s, lowerChars+upperChars) == "" },
+		"lowercase": func(s string) bool {
+			return strings.ToLower(s) == s
+		},
+		"unknown": func(s string) bool { return strings.Trim(s, lowerChars+upperChars+digitChars) == "" },
+	}
+
+	for format, ok := range valid {
+		for i := 0; i < 100; i++ {
+			s := RandomText(r, 3, 5, format)
+			if !ok(s) {
+				t.Fatalf("%s: %q is invalid", format, s)
+			}
+			if _, fixed := textChars[format]; (fixed || format == "unknown") && (len(s) < 3 || len(s) > 5) {
+				t.Fatalf("%s: %q isn't 3 to 5 characters", format, s)
+			}
+		}
+	}
+}
+
+func TestRandomTime(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	min, max := time.Date(1970, 1, 1, 0, 0, 1, 0, time.UTC), time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		unit TimeUnit
+		d    time.Duration
+	}{
+		{TIMEUNIT_SECONDS, time.Second},
+		{TIMEUNIT_MILLISECONDS, time.Millisecond},
+		{TIMEUNIT_MICROSECONDS, time.Microsecond},
+		{TIMEUNIT_NANOSECONDS, time.Nanosecond},
+	} {
+		for i := 0; i < 100; i++ {
+			tm := RandomTime(r, tt.unit)
+			if tm.Before(min) || tm.After(max) || tm.Truncate(tt.d) != tm || tm.Location() != time.UTC {
+				t.Fatalf("%s: time is %v", tt.unit, tm)
+			}
+		}
+	}
+
+	v4, v6 := false, false
+	for i := 0; i < 100; i++ {
+		a := RandomIP(r)
+		v4, v6 = v4 || a.Is4(), v6 || a.Is6()
+	}
+	if !v4 || !v6 {
+		t.Errorf("RandomIP returns IPv4 %v and IPv6 %v addresses", v4, v6)
+	}
+}
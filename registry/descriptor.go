@@ -0,0 +1,243 @@
+package registry
+
+import (
+	"fmt"
+	"reflect"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+// Descriptor describes schema node: struct, group, enum, interface, const,
+// annotation or file.
+type Descriptor struct {
+	Node   caps.Node
+	GoType reflect.Type // Generated Go type, nil for groups and unregistered nodes
+}
+
+// ID returns 64-bit node ID.
+func (d *Descriptor) ID() uint64 {
+	return d.Node.Id()
+}
+
+// DisplayName returns name with file prefix, like "model.capnp:Book".
+func (d *Descriptor) DisplayName() string {
+	return d.Node.DisplayName()
+}
+
+// Name returns name relative to the file, like "Book" or "Book.Chapter".
+func (d *Descriptor) Name() string {
+	return d.Node.DisplayName()[d.Node.DisplayNamePrefixLength():]
+}
+
+// Which returns kind of the node.
+func (d *Descriptor) Which() caps.Node_Which {
+	return d.Node.Which()
+}
+
+// Doc returns $Go.doc comment of the node.
+func (d *Descriptor) Doc() string {
+	return docAnnotation(d.Node.Annotations())
+}
+
+// Annotations returns annotations applied to the node.
+func (d *Descriptor) Annotations() []Annotation {
+	return annotations(d.Node.Annotations())
+}
+
+// Fields returns fields of struct or group in code order.
+func (d *Descriptor) Fields() []Field {
+	if d.Node.Which() != caps.NODE_STRUCT {
+		return nil
+	}
+
+	fields := d.Node.Struct().Fields().ToArray()
+	l := make([]Field, len(fields))
+	for _, f := range fields {
+		l[f.CodeOrder()] = newField(f)
+	}
+	return l
+}
+
+// Field returns field of struct or group by schema name.
+func (d *Descriptor) Field(name string) (Field, bool) {
+	for _, f := range d.Fields() {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Enumerants returns enumerants of enum in code order.
+func (d *Descriptor) Enumerants() []Enumerant {
+	if d.Node.Which() != caps.NODE_ENUM {
+		return nil
+	}
+
+	var l []Enumerant
+	for i, e := range d.Node.Enum().Enumerants().ToArray() {
+		l = append(l, Enumerant{
+			Name:        e.Name(),
+			Value:       uint16(i),
+			Doc:         docAnnotation(e.Annotations()),
+			Annotations: annotations(e.Annotations()),
+		})
+	}
+	return l
+}
+
+// Field describes struct field.
+type Field struct {
+	Name         string
+	Ordinal      int    // Explicit ordinal, -1 for groups
+	CodeOrder    int    // Position in the struct definition
+	Discriminant uint16 // caps.FieldNoDiscriminant unless union member
+	Type         Type   // Type of slot, Void for groups
+	GroupID      uint64 // Node ID of group
+	Doc          string
+	Annotations  []Annotation
+	Field        caps.Field
+}
+
+func newField(f caps.Field) Field {
+	fd := Field{
+		Name:         f.Name(),
+		Ordinal:      -1,
+		CodeOrder:    int(f.CodeOrder()),
+		Discriminant: f.DiscriminantValue(),
+		Doc:          docAnnotation(f.Annotations()),
+		Annotations:  annotations(f.Annotations()),
+		Field:        f,
+	}
+
+	if f.Ordinal().Which() == caps.FIELDORDINAL_EXPLICIT {
+		fd.Ordinal = int(f.Ordinal().Explicit())
+	}
+
+	if f.Which() == caps.FIELD_GROUP {
+		fd.GroupID = f.Group().TypeId()
+	} else {
+		fd.Type = newType(f.Slot().Type())
+	}
+	return fd
+}
+
+// IsGroup reports whether the field is a group.
+func (f Field) IsGroup() bool {
+	return f.GroupID != 0
+}
+
+// IsUnionMember reports whether the field is a member of union.
+func (f Field) IsUnionMember() bool {
+	return f.Discriminant != caps.FieldNoDiscriminant
+}
+
+// Group returns descriptor of the group.
+func (f Field) Group() (*Descriptor, bool) {
+	if !f.IsGroup() {
+		return nil, false
+	}
+	return Lookup(f.GroupID)
+}
+
+// Type describes type of field or list element.
+type Type struct {
+	Which caps.Type_Which
+	ID    uint64 // Node ID of struct, enum or interface
+	Elem  *Type  // Element type of list
+}
+
+func newType(t caps.Type) Type {
+	tp := Type{Which: t.Which()}
+
+	switch t.Which() {
+	case caps.TYPE_STRUCT:
+		tp.ID = t.Struct().TypeId()
+	case caps.TYPE_ENUM:
+		tp.ID = t.Enum().TypeId()
+	case caps.TYPE_INTERFACE:
+		tp.ID = t.Interface().TypeId()
+	case caps.TYPE_LIST:
+		elem := newType(t.List().ElementType())
+		tp.Elem = &elem
+	}
+	return tp
+}
+
+// Descriptor returns descriptor of struct, enum or interface type.
+func (t Type) Descriptor() (*Descriptor, bool) {
+	if t.ID == 0 {
+		return nil, false
+	}
+	return Lookup(t.ID)
+}
+
+var typeNames = map[caps.Type_Which]string{
+	caps.TYPE_VOID:       "Void",
+	caps.TYPE_BOOL:       "Bool",
+	caps.TYPE_INT8:       "Int8",
+	caps.TYPE_INT16:      "Int16",
+	caps.TYPE_INT32:      "Int32",
+	caps.TYPE_INT64:      "Int64",
+	caps.TYPE_UINT8:      "UInt8",
+	caps.TYPE_UINT16:     "UInt16",
+	caps.TYPE_UINT32:     "UInt32",
+	caps.TYPE_UINT64:     "UInt64",
+	caps.TYPE_FLOAT32:    "Float32",
+	caps.TYPE_FLOAT64:    "Float64",
+	caps.TYPE_TEXT:       "Text",
+	caps.TYPE_DATA:       "Data",
+	caps.TYPE_ANYPOINTER: "AnyPointer",
+}
+
+// String returns type in schema language, like "List(Text)" or "Book".
+// Types of unregistered nodes are written by ID.
+func (t Type) String() string {
+	if t.Which == caps.TYPE_LIST {
+		return fmt.Sprintf("List(%s)", t.Elem)
+	}
+	if name, ok := typeNames[t.Which]; ok {
+		return name
+	}
+	if d, ok := t.Descriptor(); ok {
+		return d.Name()
+	}
+	return fmt.Sprintf("@0x%x", t.ID)
+}
+
+// Enumerant describes enum value.
+type Enumerant struct {
+	Name        string
+	Value       uint16
+	Doc         string
+	Annotations []Annotation
+}
+
+// Annotation is an annotation applied to node, field or enumerant.
+type Annotation struct {
+	ID    uint64
+	Value caps.Value
+}
+
+// Descriptor returns descriptor of the annotation declaration.
+func (a Annotation) Descriptor() (*Descriptor, bool) {
+	return Lookup(a.ID)
+}
+
+func annotations(al caps.Annotation_List) []Annotation {
+	var l []Annotation
+	for _, a := range al.ToArray() {
+		l = append(l, Annotation{ID: a.Id(), Value: a.Value()})
+	}
+	return l
+}
+
+func docAnnotation(al caps.Annotation_List) string {
+	for _, a := range al.ToArray() {
+		if a.Id() == C.Doc {
+			return a.Value().Text()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+// Package registry keeps schema nodes of generated packages. Packages
+// generated with $Codec.registry register their nodes by ID and associate
+// generated Go types with them at init time. Generic tooling can then look
+// up descriptors of any generated type.
+package registry
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+var (
+	mu    sync.RWMutex
+	nodes = make(map[uint64]caps.Node)
+	types = make(map[uint64]reflect.Type)
+	ids   = make(map[reflect.Type]uint64)
+)
+
+// Register adds nodes of the packed CodeGeneratorRequest. Generated code
+// calls it with the schema embedded into the package. It panics if data is
+// malformed.
+func Register(data string) {
+	seg, err := C.ReadFromPackedStream(strings.NewReader(data), nil)
+	if err != nil {
+		panic(fmt.Sprintf("registry: malformed schema: %v", err))
+	}
+
+	req := caps.ReadRootCodeGeneratorRequest(seg)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, n := range req.Nodes().ToArray() {
+		nodes[n.Id()] = n
+	}
+}
+
+// RegisterType associates Go type of v with node id. Pointers are
+// dereferenced.
+func RegisterType(id uint64, v interface{}) {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	types[id] = t
+	ids[t] = id
+}
+
+// Lookup returns descriptor of the node with id.
+func Lookup(id uint64) (*Descriptor, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	n, ok := nodes[id]
+	if !ok {
+		return nil, false
+	}
+	return &Descriptor{Node: n, GoType: types[id]}, true
+}
+
+// LookupType returns descriptor of the node generated as Go type t.
+func LookupType(t reflect.Type) (*Descriptor, bool) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	mu.RLock()
+	id, ok := ids[t]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+	return Lookup(id)
+}
+
+// Of returns descriptor of the generated type of v.
+func Of(v interface{}) (*Descriptor, bool) {
+	return LookupType(reflect.TypeOf(v))
+}
+
+// IDs returns IDs of all registered nodes.
+func IDs() []uint64 {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	l := make([]uint64, 0, len(nodes))
+	for id := range nodes {
+		l = append(l, id)
+	}
+	return l
+}
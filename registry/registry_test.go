@@ -0,0 +1,277 @@
+package registry
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	C "github.com/glycerine/go-capnproto"
+	"github.com/tpukep/caps"
+)
+
+// Schemas of the tests are built the way capnp compile writes them, so the
+// tests don't need the compiler.
+
+const testFileID = 0xe9e9e9e9e9e90000
+
+const (
+	bookID = testFileID + 1 + iota
+	detailsID
+	genreID
+	chapterID
+	unregisteredID
+)
+
+func textValue(seg *C.Segment, text string) caps.Value {
+	v := caps.NewValue(seg)
+	v.SetText(text)
+	return v
+}
+
+func docAnnotations(seg *C.Segment, doc string) caps.Annotation_List {
+	if doc == "" {
+		return caps.NewAnnotationList(seg, 0)
+	}
+	l := caps.NewAnnotationList(seg, 1)
+	l.At(0).SetId(C.Doc)
+	l.At(0).SetValue(textValue(seg, doc))
+	return l
+}
+
+func newNode(seg *C.Segment, n caps.Node, id uint64, name, doc string) {
+	n.SetId(id)
+	n.SetScopeId(testFileID)
+	n.SetDisplayName("test.capnp:" + name)
+	n.SetDisplayNamePrefixLength(uint32(strings.LastIndexAny("test.capnp:"+name, ":.") + 1))
+	n.SetAnnotations(docAnnotations(seg, doc))
+}
+
+// testSchema returns packed request of file test.capnp holding
+//
+//	struct Book $Go.doc("Book of the library") {
+//	  title    @1 :Text $Go.doc("Title of the book");
+//	  chapters @0 :List(Chapter);
+//	  details :group { genre @2 :Genre; }
+//	  union { paper @3 :Void; ebook @4 :Data; }
+//	  extra    @5 :Unregistered;
+//	}
+//	enum Genre { fiction @0; poetry @1 $Go.doc("Verses"); }
+//
+// Chapter and Unregistered aren't in the request. Fields are listed out of
+// code order, the way capnp compile writes them.
+func testSchema() string {
+	seg := C.NewBuffer(nil)
+	req := caps.NewRootCodeGeneratorRequest(seg)
+
+	nl := caps.NewNodeList(seg, 4)
+	file := nl.At(0)
+	file.SetId(testFileID)
+	file.SetDisplayName("test.capnp")
+	file.SetFile()
+
+	book := nl.At(1)
+	newNode(seg, book, bookID, "Book", "Book of the library")
+	book.SetStruct()
+	book.Struct().SetDiscriminantCount(2)
+
+	slot := func(f caps.Field, name string, ord, order uint16, t caps.Type) {
+		f.SetName(name)
+		f.SetCodeOrder(order)
+		f.SetDiscriminantValue(caps.FieldNoDiscriminant)
+		f.SetAnnotations(docAnnotations(seg, ""))
+		f.Ordinal().SetExplicit(ord)
+		f.SetSlot()
+		f.Slot().SetType(t)
+	}
+	newType := func(set func(caps.Type)) caps.Type {
+		t := caps.NewType(seg)
+		set(t)
+		return t
+	}
+
+	fl := caps.NewFieldList(seg, 6)
+	chapters := newType(caps.Type.SetList)
+	chapter := newType(caps.Type.SetStruct)
+	chapter.Struct().SetTypeId(chapterID)
+	chapters.List().SetElementType(chapter)
+	slot(fl.At(0), "chapters", 0, 1, chapters)
+
+	slot(fl.At(1), "title", 1, 0, newType(caps.Type.SetText))
+	fl.At(1).SetAnnotations(docAnnotations(seg, "Title of the book"))
+
+	details := fl.At(2)
+	details.SetName("details")
+	details.SetCodeOrder(2)
+	details.SetDiscriminantValue(caps.FieldNoDiscriminant)
+	details.SetAnnotations(docAnnotations(seg, ""))
+	details.SetGroup()
+	details.Group().SetTypeId(detailsID)
+
+	slot(fl.At(3), "paper", 3, 3, newType(caps.Type.SetVoid))
+	fl.At(3).SetDiscriminantValue(0)
+	slot(fl.At(4), "ebook", 4, 4, newType(caps.Type.SetData))
+	fl.At(4).SetDiscriminantValue(1)
+
+	extra := newType(caps.Type.SetStruct)
+	extra.Struct().SetTypeId(unregisteredID)
+	slot(fl.At(5), "extra", 5, 5, extra)
+	book.Struct().SetFields(fl)
+
+	group := nl.At(2)
+	newNode(seg, group, detailsID, "Book.details", "")
+	group.SetScopeId(bookID)
+	group.SetStruct()
+	group.Struct().SetIsGroup(true)
+	gl := caps.NewFieldList(seg, 1)
+	genre := newType(caps.Type.SetEnum)
+	genre.Enum().SetTypeId(genreID)
+	slot(gl.At(0), "genre", 2, 0, genre)
+	group.Struct().SetFields(gl)
+
+	enum := nl.At(3)
+	newNode(seg, enum, genreID, "Genre", "")
+	enum.SetEnum()
+	el := caps.NewEnumerantList(seg, 2)
+	el.At(0).SetName("fiction")
+	el.At(0).SetAnnotations(docAnnotations(seg, ""))
+	el.At(1).SetName("poetry")
+	el.At(1).SetCodeOrder(1)
+	el.At(1).SetAnnotations(docAnnotations(seg, "Verses"))
+	enum.Enum().SetEnumerants(el)
+
+	req.SetNodes(nl)
+
+	var b bytes.Buffer
+	if _, err := seg.WriteToPacked(&b); err != nil {
+		panic(err)
+	}
+	return b.String()
+}
+
+type Book struct{}
+type Genre uint16
+
+func init() {
+	Register(testSchema())
+	RegisterType(bookID, &Book{})
+	RegisterType(genreID, Genre(0))
+}
+
+func TestLookup(t *testing.T) {
+	if _, found := Lookup(unregisteredID); found {
+		t.Errorf("unregistered node is found")
+	}
+	if _, found := Of(0); found {
+		t.Errorf("descriptor of int is found")
+	}
+
+	d, found := Lookup(bookID)
+	if !found {
+		t.Fatal("Book is not found")
+	}
+	if d.GoType != reflect.TypeOf(Book{}) {
+		t.Errorf("Go type of Book is %v", d.GoType)
+	}
+	for _, v := range []interface{}{Book{}, &Book{}} {
+		if d, found := Of(v); !found || d.ID() != bookID {
+			t.Errorf("descriptor of %T is %v", v, d)
+		}
+	}
+	if d, found := LookupType(reflect.TypeOf(Genre(0))); !found || d.Name() != "Genre" {
+		t.Errorf("descriptor of Genre is %v", d)
+	}
+	if d, found := Lookup(detailsID); !found || d.GoType != nil {
+		t.Errorf("group is found as %v", d)
+	}
+
+	registered := make(map[uint64]bool)
+	for _, id := range IDs() {
+		registered[id] = true
+	}
+	if want := map[uint64]bool{testFileID: true, bookID: true, detailsID: true, genreID: true}; !reflect.DeepEqual(registered, want) {
+		t.Errorf("IDs are %v, want %v", registered, want)
+	}
+}
+
+func TestRegisterMalformed(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("malformed schema is registered")
+		}
+	}()
+	// Stream without segment table
+	Register("")
+}
+
+func TestDescriptor(t *testing.T) {
+	d, _ := Lookup(bookID)
+	if d.DisplayName() != "test.capnp:Book" || d.Name() != "Book" || d.Which() != caps.NODE_STRUCT {
+		t.Errorf("Book is described as %s, %s, %v", d.DisplayName(), d.Name(), d.Which())
+	}
+	if d.Doc() != "Book of the library" || len(d.Annotations()) != 1 || d.Annotations()[0].ID != C.Doc {
+		t.Errorf("doc of Book is %q, annotations %v", d.Doc(), d.Annotations())
+	}
+
+	var names []string
+	for _, f := range d.Fields() {
+		names = append(names, f.Name)
+	}
+	if want := "title chapters details paper ebook extra"; strings.Join(names, " ") != want {
+		t.Errorf("fields of Book are %v, want %s", names, want)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		ordinal int
+		typ     string
+		union   bool
+		group   string
+		doc     string
+	}{
+		{"title", 1, "Text", false, "", "Title of the book"},
+		// Types of unregistered nodes are written by ID
+		{"chapters", 0, "List(@0xe9e9e9e9e9e90004)", false, "", ""},
+		{"details", -1, "Void", false, "details", ""},
+		{"paper", 3, "Void", true, "", ""},
+		{"ebook", 4, "Data", true, "", ""},
+		{"extra", 5, "@0xe9e9e9e9e9e90005", false, "", ""},
+	} {
+		f, found := d.Field(tt.name)
+		if !found {
+			t.Errorf("%s is not found", tt.name)
+			continue
+		}
+		if f.Ordinal != tt.ordinal || f.Type.String() != tt.typ || f.IsUnionMember() != tt.union || f.Doc != tt.doc {
+			t.Errorf("%s is %d %s, union member %v, doc %q", tt.name, f.Ordinal, f.Type, f.IsUnionMember(), f.Doc)
+		}
+		g, found := f.Group()
+		if found != (tt.group != "") || found && g.Name() != tt.group {
+			t.Errorf("group of %s is %v", tt.name, g)
+		}
+	}
+	if _, found := d.Field("genre"); found {
+		t.Errorf("field of group is found in Book")
+	}
+
+	g, _ := Lookup(detailsID)
+	genre, found := g.Field("genre")
+	if !found || genre.Type.String() != "Genre" {
+		t.Fatalf("genre is %+v", genre)
+	}
+	e, found := genre.Type.Descriptor()
+	if !found || e.Fields() != nil {
+		t.Fatalf("descriptor of Genre is %v", e)
+	}
+	for i, want := range []Enumerant{{Name: "fiction", Value: 0}, {Name: "poetry", Value: 1, Doc: "Verses"}} {
+		if l := e.Enumerants(); len(l) != 2 || l[i].Name != want.Name || l[i].Value != want.Value || l[i].Doc != want.Doc {
+			t.Errorf("enumerants of Genre are %+v, want %+v at %d", l, want, i)
+		}
+	}
+	if d.Enumerants() != nil {
+		t.Errorf("struct has enumerants")
+	}
+	if _, found := (Type{Which: caps.TYPE_TEXT}).Descriptor(); found {
+		t.Errorf("Text has descriptor")
+	}
+}
@@ -0,0 +1,6 @@
+package caps
+
+const SqlTable = uint64(0x82c2bf3f58d50164)
+const SqlColumn = uint64(0xc1dc0342a86ee7b3)
+const SqlPrimaryKey = uint64(0xfa7783441e79dea7)
+const SqlIndexed = uint64(0x80772af5beda036f)
@@ -0,0 +1,5 @@
+package caps
+
+// TestsEnv enables generation of <model>_test.go files by capnpc-pgo when
+// set to non-empty value. caps sets it with -tests.
+const TestsEnv = "CAPS_TESTS"
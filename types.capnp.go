@@ -0,0 +1,30 @@
+package caps
+
+const TypeTimestamp = uint64(0xef9650edb20df348)
+const TypeDuration = uint64(0xca45d10f6a4c2454)
+const TypeUuid = uint64(0xdd08315cb8f56054)
+const TypeIp = uint64(0xe3144f2a55c09f41)
+
+type TimeUnit uint16
+
+const (
+	TIMEUNIT_SECONDS      TimeUnit = 0
+	TIMEUNIT_MILLISECONDS TimeUnit = 1
+	TIMEUNIT_MICROSECONDS TimeUnit = 2
+	TIMEUNIT_NANOSECONDS  TimeUnit = 3
+)
+
+func (c TimeUnit) String() string {
+	switch c {
+	case TIMEUNIT_SECONDS:
+		return "seconds"
+	case TIMEUNIT_MILLISECONDS:
+		return "milliseconds"
+	case TIMEUNIT_MICROSECONDS:
+		return "microseconds"
+	case TIMEUNIT_NANOSECONDS:
+		return "nanoseconds"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,171 @@
+package caps
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// Converters of $Type annotations. Generated code converts field values to
+// and from Go types of the schema slots with them.
+
+// Zero time is stored as 0, so unset timestamps survive round trips. 0 is
+// read back as zero time, not as the Unix epoch.
+
+func TimestampSecondsToCapn(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func TimestampSecondsFromCapn(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(v, 0).UTC()
+}
+
+func TimestampMillisecondsToCapn(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func TimestampMillisecondsFromCapn(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(v/1e3, v%1e3*int64(time.Millisecond)).UTC()
+}
+
+func TimestampMicrosecondsToCapn(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Microsecond)
+}
+
+func TimestampMicrosecondsFromCapn(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(v/1e6, v%1e6*int64(time.Microsecond)).UTC()
+}
+
+func TimestampNanosecondsToCapn(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func TimestampNanosecondsFromCapn(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v).UTC()
+}
+
+// IPToCapn returns 4 bytes of IPv4 address or 16 bytes of IPv6 address,
+// nil for zero Addr. IPv6 zones are dropped.
+func IPToCapn(a netip.Addr) []byte {
+	if !a.IsValid() {
+		return nil
+	}
+	return a.AsSlice()
+}
+
+// IPFromCapn returns address of 4 or 16 bytes, zero Addr otherwise
+func IPFromCapn(b []byte) netip.Addr {
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// UUIDExtension is the msgp extension type of UUID
+const UUIDExtension = 16
+
+var errUUID = errors.New("caps: invalid UUID")
+
+// UUID is value of $Type.uuid field. It is written in JSON and text formats
+// in canonical form like "6ba7b810-9dad-11d1-80b4-00c04fd430c8" and in msgp
+// as extension of type UUIDExtension.
+type UUID [16]byte
+
+// ParseUUID parses UUID in canonical form
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	err := u.UnmarshalText([]byte(s))
+	return u, err
+}
+
+func (u UUID) String() string {
+	b, _ := u.MarshalText()
+	return string(b)
+}
+
+func (u UUID) MarshalText() ([]byte, error) {
+	b := make([]byte, 36)
+	hex.Encode(b[0:8], u[0:4])
+	hex.Encode(b[9:13], u[4:6])
+	hex.Encode(b[14:18], u[6:8])
+	hex.Encode(b[19:23], u[8:10])
+	hex.Encode(b[24:36], u[10:16])
+	b[8], b[13], b[18], b[23] = '-', '-', '-', '-'
+	return b, nil
+}
+
+func (u *UUID) UnmarshalText(b []byte) error {
+	if len(b) != 36 || b[8] != '-' || b[13] != '-' || b[18] != '-' || b[23] != '-' {
+		return errUUID
+	}
+
+	var h [32]byte
+	copy(h[0:8], b[0:8])
+	copy(h[8:12], b[9:13])
+	copy(h[12:16], b[14:18])
+	copy(h[16:20], b[19:23])
+	copy(h[20:32], b[24:36])
+
+	var v UUID
+	if _, err := hex.Decode(v[:], h[:]); err != nil {
+		return errUUID
+	}
+	*u = v
+	return nil
+}
+
+// ExtensionType implements msgp.Extension
+func (u *UUID) ExtensionType() int8 { return UUIDExtension }
+
+// Len implements msgp.Extension
+func (u *UUID) Len() int { return len(u) }
+
+// MarshalBinaryTo implements msgp.Extension
+func (u *UUID) MarshalBinaryTo(b []byte) error {
+	copy(b, u[:])
+	return nil
+}
+
+// UnmarshalBinary implements msgp.Extension
+func (u *UUID) UnmarshalBinary(b []byte) error {
+	if len(b) != len(u) {
+		return errUUID
+	}
+	copy(u[:], b)
+	return nil
+}
+
+// UUIDToCapn returns 16 bytes of UUID
+func UUIDToCapn(u UUID) []byte { return u[:] }
+
+// UUIDFromCapn returns UUID of 16 bytes, zero UUID otherwise
+func UUIDFromCapn(b []byte) UUID {
+	var u UUID
+	if len(b) == len(u) {
+		copy(u[:], b)
+	}
+	return u
+}
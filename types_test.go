@@ -0,0 +1,133 @@
+package caps
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestTimestamps(t *testing.T) {
+	tm := time.Date(2021, 3, 4, 5, 6, 7, 123456789, time.FixedZone("X", 3600))
+
+	for _, tt := range []struct {
+		unit string
+		to   func(time.Time) int64
+		from func(int64) time.Time
+		v    int64
+		back time.Time
+	}{
+		{"seconds", TimestampSecondsToCapn, TimestampSecondsFromCapn, 1614830767, tm.Truncate(time.Second)},
+		{"milliseconds", TimestampMillisecondsToCapn, TimestampMillisecondsFromCapn, 1614830767123, tm.Truncate(time.Millisecond)},
+		{"microseconds", TimestampMicrosecondsToCapn, TimestampMicrosecondsFromCapn, 1614830767123456, tm.Truncate(time.Microsecond)},
+		{"nanoseconds", TimestampNanosecondsToCapn, TimestampNanosecondsFromCapn, 1614830767123456789, tm},
+	} {
+		if v := tt.to(tm); v != tt.v {
+			t.Errorf("%s: %v is stored as %d, want %d", tt.unit, tm, v, tt.v)
+		}
+		back := tt.from(tt.v)
+		if !back.Equal(tt.back) || back.Location() != time.UTC {
+			t.Errorf("%s: %d is read as %v, want %v", tt.unit, tt.v, back, tt.back.UTC())
+		}
+
+		// Zero time isn't the Unix epoch
+		if v := tt.to(time.Time{}); v != 0 {
+			t.Errorf("%s: zero time is stored as %d", tt.unit, v)
+		}
+		if back := tt.from(0); !back.IsZero() {
+			t.Errorf("%s: 0 is read as %v", tt.unit, back)
+		}
+
+		before := time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC)
+		if back := tt.from(tt.to(before)); !back.Equal(before) {
+			t.Errorf("%s: %v is read back as %v", tt.unit, before, back)
+		}
+	}
+}
+
+func TestIP(t *testing.T) {
+	for _, tt := range []struct {
+		addr string
+		b    []byte
+	}{
+		{"192.168.0.1", []byte{192, 168, 0, 1}},
+		{"::ffff:10.0.0.1", []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 1}},
+		{"2001:db8::1", []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+	} {
+		a := netip.MustParseAddr(tt.addr)
+		if b := IPToCapn(a); !bytes.Equal(b, tt.b) {
+			t.Errorf("%s is stored as %v, want %v", tt.addr, b, tt.b)
+		}
+		if back := IPFromCapn(tt.b); back != a {
+			t.Errorf("%v is read as %s, want %s", tt.b, back, a)
+		}
+	}
+
+	// Zones are dropped
+	if b := IPToCapn(netip.MustParseAddr("fe80::1%eth0")); IPFromCapn(b) != netip.MustParseAddr("fe80::1") {
+		t.Errorf("fe80::1%%eth0 is stored as %v", b)
+	}
+	if b := IPToCapn(netip.Addr{}); b != nil {
+		t.Errorf("zero address is stored as %v", b)
+	}
+	for _, b := range [][]byte{nil, {1, 2, 3}, make([]byte, 5)} {
+		if a := IPFromCapn(b); a.IsValid() {
+			t.Errorf("%v is read as %s", b, a)
+		}
+	}
+}
+
+func TestUUID(t *testing.T) {
+	const s = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	want := UUID{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+	u, err := ParseUUID(s)
+	if err != nil || u != want {
+		t.Fatalf("%s is parsed as %v: %v", s, u, err)
+	}
+	if u.String() != s {
+		t.Errorf("%v is written as %s", u, u)
+	}
+	if u, err := ParseUUID("6BA7B810-9DAD-11D1-80B4-00C04FD430C8"); err != nil || u != want {
+		t.Errorf("upper case UUID is parsed as %v: %v", u, err)
+	}
+
+	for _, in := range []string{
+		"",
+		"6ba7b8109dad11d180b400c04fd430c8",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c",
+		"6ba7b810-9dad-11d1-80b400c04fd430c8-",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430cg",
+	} {
+		u := want
+		if err := u.UnmarshalText([]byte(in)); err == nil {
+			t.Errorf("%q is parsed", in)
+		}
+		if u != want {
+			t.Errorf("%q changes UUID to %v", in, u)
+		}
+	}
+
+	if b := UUIDToCapn(want); !bytes.Equal(b, want[:]) {
+		t.Errorf("UUID is stored as %v", b)
+	}
+	if back := UUIDFromCapn(want[:]); back != want {
+		t.Errorf("UUID is read as %v", back)
+	}
+	if back := UUIDFromCapn(want[:15]); back != (UUID{}) {
+		t.Errorf("15 bytes are read as %v", back)
+	}
+
+	// msgp extension
+	b := make([]byte, u.Len())
+	if err := u.MarshalBinaryTo(b); err != nil || !bytes.Equal(b, want[:]) || u.ExtensionType() != UUIDExtension {
+		t.Errorf("extension is %v: %v", b, err)
+	}
+	var ext UUID
+	if err := ext.UnmarshalBinary(b); err != nil || ext != want {
+		t.Errorf("extension is read as %v: %v", ext, err)
+	}
+	if err := ext.UnmarshalBinary(b[1:]); err == nil {
+		t.Errorf("extension of 15 bytes is read")
+	}
+}
@@ -0,0 +1,87 @@
+package caps
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// CloneValue returns deep copy of value of interface{} field of generated
+// struct, like AnyPointer or element of list of lists. Slices and maps are
+// copied, other values are returned as is.
+func CloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if v == nil {
+			return v
+		}
+		return append([]byte{}, v...)
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = CloneValue(e)
+		}
+		return l
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = CloneValue(e)
+		}
+		return m
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && !rv.IsNil() {
+		l := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+		reflect.Copy(l, rv)
+		return l.Interface()
+	}
+	return v
+}
+
+// EqualValue reports whether values of interface{} fields are deeply equal.
+// Nil and empty lists are equal.
+func EqualValue(a, b interface{}) bool {
+	if emptyList(a) && emptyList(b) {
+		return true
+	}
+
+	switch a := a.(type) {
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !EqualValue(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// IsZero reports whether v is zero value of its type. Generated code uses
+// it to find the member of union that is set.
+func IsZero(v interface{}) bool {
+	return v == nil || reflect.DeepEqual(v, reflect.Zero(reflect.TypeOf(v)).Interface())
+}
+
+func emptyList(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Slice && rv.Len() == 0
+}
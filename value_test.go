@@ -0,0 +1,82 @@
+package caps
+
+import (
+	"reflect"
+	"testing"
+)
+
+type point struct{ X, Y int }
+
+func TestEqualValue(t *testing.T) {
+	for _, tt := range []struct {
+		a, b  interface{}
+		equal bool
+	}{
+		{nil, nil, true},
+		{nil, []interface{}{}, true},
+		{[]byte{}, nil, true},
+		{[]string{}, []interface{}(nil), true},
+		{[]byte{1}, []byte{1}, true},
+		{[]byte{1}, []byte{2}, false},
+		{[]byte{1}, []interface{}{1}, false},
+		{"a", "a", true},
+		{"a", []byte("a"), false},
+		{int64(1), 1, false},
+		{[]interface{}{[]interface{}{}, []byte{1}}, []interface{}{nil, []byte{1}}, true},
+		{[]interface{}{"a"}, []interface{}{"a", "b"}, false},
+		{[]interface{}{[]interface{}{"a"}}, []interface{}{[]interface{}{"b"}}, false},
+		{[]string{"a"}, []string{"a"}, true},
+		{point{1, 2}, point{1, 2}, true},
+		{&point{1, 2}, &point{1, 3}, false},
+	} {
+		if EqualValue(tt.a, tt.b) != tt.equal || EqualValue(tt.b, tt.a) != tt.equal {
+			t.Errorf("EqualValue(%#v, %#v) is %v", tt.a, tt.b, !tt.equal)
+		}
+	}
+}
+
+func TestIsZero(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		zero bool
+	}{
+		{nil, true},
+		{0, true},
+		{int8(1), false},
+		{"", true},
+		{"a", false},
+		{point{}, true},
+		{point{0, 1}, false},
+		{[]byte(nil), true},
+		// Empty lists are set
+		{[]byte{}, false},
+		{(*point)(nil), true},
+		{&point{}, false},
+	} {
+		if IsZero(tt.v) != tt.zero {
+			t.Errorf("IsZero(%#v) is %v", tt.v, !tt.zero)
+		}
+	}
+}
+
+func TestCloneValue(t *testing.T) {
+	in := []interface{}{[]byte{1}, map[string]interface{}{"a": []interface{}{"b"}}, []string{"c"}, "d", nil}
+	out := CloneValue(in).([]interface{})
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("%v is cloned as %v", in, out)
+	}
+
+	out[0].([]byte)[0] = 2
+	out[1].(map[string]interface{})["a"].([]interface{})[0] = "x"
+	out[2].([]string)[0] = "x"
+	if !reflect.DeepEqual(in[0], []byte{1}) || !reflect.DeepEqual(in[1], map[string]interface{}{"a": []interface{}{"b"}}) ||
+		!reflect.DeepEqual(in[2], []string{"c"}) {
+		t.Errorf("clone shares values with %v", in)
+	}
+
+	for _, v := range []interface{}{[]byte(nil), []interface{}(nil), map[string]interface{}(nil), []string(nil)} {
+		if c := CloneValue(v); !reflect.DeepEqual(c, v) {
+			t.Errorf("%#v is cloned as %#v", v, c)
+		}
+	}
+}